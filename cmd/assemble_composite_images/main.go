@@ -2,40 +2,92 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"image"
 	"log"
 	"os"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/mchapman87501/go_mars_2020_img_utils/lib"
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
 )
 
 const outDir = "composite_images/"
 
+// Settings that control how each composite is assembled.
+type options struct {
+	blendMode     lib.BlendMode
+	featherWidth  int
+	pyramidLevels int
+	// Registration search radius, in pixels; 0 disables registration.
+	registrationRadius int
+	// Sensor pixels per output pixel; 0 means the finest scale in each set.
+	outputScale    int
+	resampleFilter lib.ResampleFilter
+	colorBalance   lib.ColorBalanceMode
+	// Which images to group into composite image sets, and how.
+	query    lib.CompositeSetQuery
+	grouping lib.GroupingOptions
+	verbose  bool
+	// Make uncovered areas transparent.
+	alpha bool
+	// Also save a map of which tile supplied each pixel.
+	tileMap bool
+	// How each composite's pixels are stored while it is assembled.
+	storage lib_image.StorageOptions
+	// Output image format: "png" or "tiff".
+	format string
+	// How E tiles are demosaiced.
+	demosaic lib.DemosaicOptions
+	// How each composite is white balanced, unless whiteBalance is
+	// lib.WhiteBalanceNone.
+	whiteBalance     lib.WhiteBalancePreset
+	whiteBalanceOpts lib.WhiteBalanceOptions
+	// Also composite color separations, merged as F tiles as merge directs.
+	separations bool
+	merge       lib.MergeOptions
+}
+
 func savePNG(image image.Image, filename string) {
 	if err := lib.SavePNG(image, filename); err != nil {
 		fmt.Printf("Error saving %v: %v\n", filename, err)
 	}
 }
 
-func demosaiced(cache lib.ImageCache, record lib.CompositeImageInfo) (image.Image, error) {
-	image, err := cache.FullSize(record.ImageID)
+// Save an image in the given format.  Both formats are encoded row by
+// row, so paged composites needn't fit in memory.
+func saveImage(image image.Image, filename string, format string) {
+	if format != "tiff" {
+		savePNG(image, filename)
+		return
+	}
+	if err := lib.SaveTIFF(image, filename); err != nil {
+		fmt.Printf("Error saving %v: %v\n", filename, err)
+	}
+}
+
+// tileSource retrieves full-size tile images: a lib.ImageCache, or a
+// lib.ColorSeparationSource, which also merges color separations.
+type tileSource interface {
+	FullSize(imageID string) (image.Image, error)
+}
+
+func tileImage(tiles tileSource, record lib.CompositeImageInfo, opts options) (image.Image, error) {
+	image, err := tiles.FullSize(record.ImageID)
 	if err != nil {
 		return image, err
 	}
-	if record.ColorType == "E" {
-		return lib.DemosaicRGBGray(image)
-	}
-	return image, nil
+	return record.CompositeTileImage(image, opts.demosaic)
 }
 
-func saveMetadata(records lib.CompositeImageSet, filename string) {
-	b, err := json.MarshalIndent(records, "", "  ")
+func saveJSON(value interface{}, filename string) {
+	b, err := json.MarshalIndent(value, "", "  ")
 	if err != nil {
-		fmt.Println("Error marshaling composite image set to JSON:", err)
+		fmt.Printf("Error marshaling %v to JSON: %v\n", filename, err)
 	} else {
 		outf, err := os.Create(filename)
 		if err != nil {
@@ -53,8 +105,38 @@ func saveMetadata(records lib.CompositeImageSet, filename string) {
 	}
 }
 
-func assembleImageSet(cache lib.ImageCache, imageSet lib.CompositeImageSet) {
-	filename := outDir + imageSet.Name() + ".png"
+// Add tiles, solving for their color corrections before any is
+// composited.  Rather than holding every tile in memory, which would
+// undercut -memory, each tile is reloaded from source when needed.
+// Returns the records of the tiles added.
+func addGloballyBalanced(
+	compositor *lib.Compositor, source tileSource, records lib.CompositeImageSet, opts options,
+) lib.CompositeImageSet {
+	tiles := make([]lib.Tile, len(records))
+	for i, record := range records {
+		record := record
+		tiles[i] = lib.Tile{
+			SubframeRect: record.SubframeRect,
+			ScaleFactor:  record.ScaleFactor,
+			Load: func() (image.Image, error) {
+				return tileImage(source, record, opts)
+			},
+		}
+	}
+
+	added := lib.CompositeImageSet{}
+	for i, err := range compositor.AddTiles(tiles) {
+		if err != nil {
+			fmt.Println("Error preparing full size image", records[i].ImageID, "-", err, "- skipping")
+		} else {
+			added = append(added, records[i])
+		}
+	}
+	return added
+}
+
+func assembleImageSet(tiles tileSource, imageSet lib.CompositeImageSet, opts options) {
+	filename := outDir + imageSet.Name() + "." + opts.format
 	// If the file already exists, just move on, eh.
 	if lib.FileExists(filename) {
 		fmt.Println(filename, "already exists; nothing to do.")
@@ -77,57 +159,115 @@ func assembleImageSet(cache lib.ImageCache, imageSet lib.CompositeImageSet) {
 		compositeRect = compositeRect.Union(record.SubframeRect)
 	}
 
-	compositor := lib.NewCompositor(compositeRect)
+	outputScale := opts.outputScale
+	if outputScale <= 0 {
+		outputScale = sorted.FinestScale()
+	}
+	compositor, err := lib.NewCompositorWithStorage(compositeRect, outputScale, opts.storage)
+	if err != nil {
+		fmt.Println("Error creating compositor:", err)
+		return
+	}
+	defer compositor.Close()
 	if compositor.Bounds.Empty() {
 		fmt.Println("composite image set has no extent.")
 		return
 	}
+	compositor.BlendMode = opts.blendMode
+	compositor.FeatherWidth = opts.featherWidth
+	compositor.PyramidLevels = opts.pyramidLevels
+	compositor.RegistrationRadius = opts.registrationRadius
+	compositor.ResampleFilter = opts.resampleFilter
+	compositor.ColorBalance = opts.colorBalance
 
-	for _, record := range sorted {
-		image, err := demosaiced(cache, record)
-		if err != nil {
-			fmt.Println("Error retrieving full size image", record.ImageID, "- skipping")
-		} else {
-			compositor.AddImage(image, record.SubframeRect)
+	added := lib.CompositeImageSet{}
+	if opts.colorBalance == lib.BalanceGlobal {
+		added = addGloballyBalanced(&compositor, tiles, sorted, opts)
+	} else {
+		for _, record := range sorted {
+			image, err := tileImage(tiles, record, opts)
+			if err != nil {
+				fmt.Println("Error preparing full size image", record.ImageID, "-", err, "- skipping")
+			} else {
+				compositor.AddScaledImage(image, record.SubframeRect, record.ScaleFactor)
+				added = append(added, record)
+			}
 		}
 	}
 
+	if opts.whiteBalance != lib.WhiteBalanceNone {
+		wb := compositor.WhiteBalance(opts.whiteBalanceOpts)
+		saveJSON(wb, outDir+imageSet.Name()+"_white_balance.json")
+	}
 	compositor.CompressDynamicRange()
-	savePNG(compositor.Result, filename)
-	saveMetadata(sorted, metadataFilename)
+	if opts.alpha {
+		saveImage(compositor.NRGBAView(), filename, opts.format)
+	} else {
+		saveImage(compositor.Result, filename, opts.format)
+	}
+	if err := compositor.Err(); err != nil {
+		fmt.Println("Error paging", imageSet.Name(), "-", err)
+	}
+	if opts.tileMap {
+		// Pixel value N in the map means the tile was added[N-1].
+		savePNG(compositor.TileIndexMap(), outDir+imageSet.Name()+"_tiles.png")
+		saveJSON(added, outDir+imageSet.Name()+"_tiles.json")
+	}
+	saveJSON(sorted, metadataFilename)
+	if opts.registrationRadius > 0 {
+		saveJSON(compositor.Corrections, outDir+imageSet.Name()+"_corrections.json")
+	}
+	if opts.colorBalance == lib.BalanceGlobal {
+		// Corrections are in the order of the added records.
+		saveJSON(compositor.ColorCorrections, outDir+imageSet.Name()+"_color_corrections.json")
+	}
 }
 
 func enqueueCameraImageSets(
-	imageDB lib.ImageDB, camera string, jobs chan lib.CompositeImageSet,
+	store lib.ImageStore, camera string, opts options, jobs chan lib.CompositeImageSet,
 ) {
-	imageSets, err := lib.GetCompositeImageSets(imageDB, camera)
+	imageSets, rejected, err := lib.QueryGroupedImageSets(store, camera, opts.query, opts.grouping)
 	if err != nil {
 		fmt.Println("Error retrieving image sets for", camera, "-", err)
 	} else {
+		fmt.Printf("%v: %d image sets, %d rejected\n", camera, len(imageSets), len(rejected))
+		if opts.verbose {
+			for _, r := range rejected {
+				fmt.Println("Rejected", r.Set.Name(), "-", r.Reason)
+			}
+		}
 		for _, imageSet := range imageSets {
 			jobs <- imageSet
 		}
 	}
 }
 
-func processJobs(workerID int, jobs chan lib.CompositeImageSet, cache lib.ImageCache, wg *sync.WaitGroup) {
+func processJobs(workerID int, jobs chan lib.CompositeImageSet, tiles tileSource, opts options, wg *sync.WaitGroup) {
 	for {
 		imageSet, ok := <-jobs
 		if !ok {
 			wg.Done()
 			return
 		}
-		assembleImageSet(cache, imageSet)
+		assembleImageSet(tiles, imageSet, opts)
 	}
 }
 
-func processConcurrently(imageDB lib.ImageDB, cameras []string) {
+func processConcurrently(store lib.ImageStore, cameras []string, opts options) {
 	// Lots of thread-safety issues here -- need to mutex access to
 	// cache operations.
-	cache, err := lib.NewImageCache(imageDB)
+	cache, err := lib.NewImageCache(store)
 	if err != nil {
 		log.Fatal("Could not instantiate image cache:", err)
 	}
+	var tiles tileSource = &cache
+	if opts.separations {
+		separations, err := lib.NewColorSeparationSource(store, cache, opts.merge)
+		if err != nil {
+			log.Fatal("Could not find color separations:", err)
+		}
+		store, tiles = separations, separations
+	}
 
 	concurrency := runtime.NumCPU()
 
@@ -136,18 +276,111 @@ func processConcurrently(imageDB lib.ImageDB, cameras []string) {
 	jobs := make(chan lib.CompositeImageSet, concurrency)
 	for i := 0; i < concurrency; i++ {
 		go func(workerID int) {
-			processJobs(workerID, jobs, cache, &wg)
+			processJobs(workerID, jobs, tiles, opts, &wg)
 		}(i)
 	}
 	for _, camera := range cameras {
-		enqueueCameraImageSets(imageDB, camera, jobs)
+		enqueueCameraImageSets(store, camera, opts, jobs)
 	}
 	close(jobs)
 	wg.Wait()
 }
 
 func main() {
-	err := os.MkdirAll(outDir, 0755)
+	blendName := flag.String("blend", "overwrite", "how to blend overlapping tiles: overwrite, feather, distance, multiband or seam")
+	featherWidth := flag.Int("feather", lib.DefaultFeatherWidth, "width in pixels of feathered tile borders")
+	pyramidLevels := flag.Int("levels", lib.DefaultPyramidLevels, "maximum number of pyramid levels for multiband blending")
+	registrationRadius := flag.Int("register", 0, "if positive, align tiles by searching up to this many pixels from their nominal positions")
+	outputScale := flag.Int("scale", 0, "sensor pixels per output pixel; 0 uses the finest scale in each image set")
+	filterName := flag.String("filter", "bilinear", "how to resample tiles: nearest, bilinear, catmullrom or lanczos3")
+	balanceName := flag.String("balance", "sequential", "how to match tile colors: sequential, adjusting each tile to those before it, or global, solving for all tiles at once")
+	defaultQuery := lib.DefaultAssemblyQuery()
+	defaultGrouping := lib.DefaultAssemblyGroupingOptions()
+	colorTypes := flag.String("color-types", strings.Join(defaultQuery.ColorTypes, ","), "comma-separated color types of tiles to composite; E tiles are demosaiced")
+	sampleType := flag.String("sample-type", defaultQuery.SampleType, "sample type of tiles to composite")
+	sclkTolerance := flag.Float64("sclk-tolerance", defaultGrouping.SclkTolerance, "maximum sclk difference, in seconds, between successive tiles of a set")
+	matchSequence := flag.Bool("match-sequence", defaultGrouping.MatchSequenceID, "require tiles of a set to have the same sequence ID")
+	verbose := flag.Bool("v", false, "report why image sets were rejected")
+	alpha := flag.Bool("alpha", false, "make areas that no tile covers transparent")
+	tileMap := flag.Bool("tile-map", false, "also save a 16-bit map of which tile supplied each pixel, with the list of tiles it indexes")
+	memoryMB := flag.Int64("memory", 0, "if positive, page each composite to a scratch file to keep it within this many MiB of memory")
+	scratchDir := flag.String("scratch-dir", "", "directory for scratch files; default is the system temporary directory")
+	blockSize := flag.Int("block-size", lib_image.DefaultBlockSize, "width and height, in pixels, of the blocks in which composites are stored")
+	format := flag.String("format", "png", "output image format: png or tiff")
+	demosaicName := flag.String("demosaic", "box", "how to demosaic E tiles: box, bilinear, mhc, vng or ahd")
+	cfaName := flag.String("cfa", "RGGB", "color filter pattern of E tiles, starting at the sensor's first pixel: RGGB, BGGR, GRBG or GBRG")
+	whiteBalanceName := flag.String("white-balance", "none", "how to white balance composites: none, mars, keeping the warm light of Mars, or earth, as if lit by terrestrial daylight")
+	estimatorName := flag.String("wb-estimator", "", "override the white balance preset's estimator: gray-world, white-patch or percentile")
+	adaptationName := flag.String("adaptation", "", "override the white balance preset's chromatic adaptation: bradford, vonkries or cat02")
+	separations := flag.Bool("separations", false, "also composite R, G and B color separations, merged into F tiles")
+	normalizeBands := flag.Bool("normalize-bands", false, "normalize the exposure of each band of merged color separations")
+	bandPercentile := flag.Float64("band-percentile", lib.DefaultNormalizePercentile, "percentile of each band that -normalize-bands maps to full scale")
+	registerBands := flag.Int("register-bands", 0, "if positive, align the red and blue bands of merged color separations with the green, searching up to this many pixels")
+	flag.Parse()
+
+	if (*format != "png") && (*format != "tiff") {
+		log.Fatalf("unknown output format %q", *format)
+	}
+
+	blendMode, err := lib.ParseBlendMode(*blendName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resampleFilter, err := lib.ParseResampleFilter(*filterName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	colorBalance, err := lib.ParseColorBalanceMode(*balanceName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	demosaicMethod, err := lib.ParseDemosaicMethod(*demosaicName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfaPattern, err := lib.ParseCFAPattern(*cfaName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	whiteBalance, whiteBalanceOpts, err := lib.ParseWhiteBalanceOptions(*whiteBalanceName, *estimatorName, *adaptationName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := options{
+		blendMode:          blendMode,
+		featherWidth:       *featherWidth,
+		pyramidLevels:      *pyramidLevels,
+		registrationRadius: *registrationRadius,
+		outputScale:        *outputScale,
+		resampleFilter:     resampleFilter,
+		colorBalance:       colorBalance,
+		query: lib.CompositeSetQuery{
+			ColorTypes: strings.Split(*colorTypes, ","),
+			SampleType: *sampleType,
+		},
+		verbose: *verbose,
+		alpha:   *alpha,
+		tileMap: *tileMap,
+		storage: lib_image.StorageOptions{
+			BlockSize:    *blockSize,
+			MemoryBudget: *memoryMB << 20,
+			ScratchDir:   *scratchDir,
+		},
+		format:           *format,
+		demosaic:         lib.DemosaicOptions{Method: demosaicMethod, Pattern: cfaPattern},
+		whiteBalance:     whiteBalance,
+		whiteBalanceOpts: whiteBalanceOpts,
+	}
+	opts.separations = *separations
+	opts.merge = lib.DefaultMergeOptions()
+	opts.merge.NormalizeExposure = *normalizeBands
+	opts.merge.NormalizePercentile = *bandPercentile
+	opts.merge.RegistrationRadius = *registerBands
+	opts.grouping = defaultGrouping
+	opts.grouping.SclkTolerance = *sclkTolerance
+	opts.grouping.MatchSequenceID = *matchSequence
+
+	err = os.MkdirAll(outDir, 0755)
 	if err != nil {
 		log.Fatal("Could not create output directory", outDir, ":", err)
 	}
@@ -157,5 +390,5 @@ func main() {
 		log.Fatal("Could not instantiate image DB:", err)
 	}
 
-	processConcurrently(imageDB, imageDB.Cameras())
+	processConcurrently(&imageDB, imageDB.Cameras(), opts)
 }
@@ -0,0 +1,38 @@
+package main
+
+// Report what the image database contains.
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mchapman87501/go_mars_2020_img_utils/lib"
+)
+
+func main() {
+	dbPath := flag.String("db", lib.DefaultDBPathname, "pathname of the image database")
+	asJSON := flag.Bool("json", false, "write the report as JSON")
+	flag.Parse()
+
+	imageDB, err := lib.NewImageDBAtPath(*dbPath)
+	if err != nil {
+		log.Fatal("Could not instantiate image DB:", err)
+	}
+
+	stats, err := imageDB.Stats()
+	if err != nil {
+		log.Fatal("Could not compute database statistics:", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(stats); err != nil {
+			log.Fatal("Error writing JSON:", err)
+		}
+	} else {
+		stats.WriteText(os.Stdout)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+// Build or update the image database from previously saved RSS API
+// responses, e.g., those archived by update_db -save-raw.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mchapman87501/go_mars_2020_img_utils/lib"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [-db PATH] FILE_OR_DIR...\n"+
+			"Each argument may be a directory, a tarball (.tar, .tar.gz, .tgz),\n"+
+			"a JSON Lines file (.jsonl) or a single .json RSS API response.\n",
+		os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbPath := flag.String("db", lib.DefaultDBPathname, "pathname of the image database")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() <= 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	imageDB, err := lib.NewImageDBAtPath(*dbPath)
+	if err != nil {
+		log.Fatal("Could not instantiate image DB:", err)
+	}
+
+	total := 0
+	for _, pathname := range flag.Args() {
+		numRecords, err := imageDB.ImportFile(pathname)
+		total += numRecords
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Imported", numRecords, "records from", pathname)
+	}
+	fmt.Println("Imported", total, "records in all.")
+}
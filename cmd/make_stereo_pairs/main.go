@@ -2,11 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"image"
 	"image/draw"
 	"log"
-	"math"
 	"os"
 	"runtime"
 	"strings"
@@ -21,93 +21,13 @@ func init() {
 	os.MkdirAll(outDir, 0755)
 }
 
-type StereoPair struct {
-	Left, Right string // image_ids
-}
-
-func idsMatch(imageID1, imageID2 string) bool {
-	// If TWO IDs differ only in the 2nd rune - one being
-	// 'L' and the other being 'R' - then the IDs are
-	// probably for the same snapshot.
-	run1 := []rune(imageID1)
-	run2 := []rune(imageID2)
-	if (len(run1) > 3) && (len(run2) > 3) {
-		prefix1 := string(run1[:1])
-		prefix2 := string(run2[:1])
-		suffix1 := string(run1[2:])
-		suffix2 := string(run2[2:])
-		if (prefix1 == prefix2) && (suffix1 == suffix2) {
-			return true
-		}
-	}
-	return false
-}
-
-func splitLRSuffix(s string) (prefix, suffix string) {
-	// Front hazcams use, e.g., "LEFT_A"
-	suffixes := []string{"_LEFT", "_RIGHT", "LEFT_A", "RIGHT_A"}
-
-	for _, suffix := range suffixes {
-		if strings.HasSuffix(s, suffix) {
-			return strings.Replace(s, suffix, "", 1), suffix
-		}
-	}
-	return s, ""
-}
-
-func instrumentsMatch(inst1, inst2 string) bool {
-	pf1, lr1 := splitLRSuffix(inst1)
-	pf2, lr2 := splitLRSuffix(inst2)
-	return (pf1 == pf2) && (lr1 != "") && (lr2 != "") && (lr1 != lr2)
-}
-
-func findStereoPairs(imageDB lib.ImageDB) []StereoPair {
-	result := []StereoPair{}
-
-	query := `SELECT image_id, cam_instrument, ext_sclk
-FROM Images
-WHERE color_type = 'F' and sample_type = 'Full'
-ORDER BY ext_sclk, cam_instrument`
-
-	rows, err := imageDB.DB.Query(query)
-	if err == nil {
-		defer rows.Close()
-		prevID := ""
-		prevInstrument := ""
-		prevSclk := -1.0
-
-		for rows.Next() {
-			var imageID string
-			var instrument string
-			var sclk float64
-			err := rows.Scan(&imageID, &instrument, &sclk)
-			if err == nil {
-				dt := math.Abs(sclk - prevSclk)
-				if (dt <= 1.0) && idsMatch(prevID, imageID) && instrumentsMatch(instrument, prevInstrument) {
-					pair := StereoPair{imageID, prevID}
-					leftOrRightPrev := []rune(prevID)[1]
-					if leftOrRightPrev == 'L' {
-						pair = StereoPair{prevID, imageID}
-					}
-					result = append(result, pair)
-				}
-			}
-
-			prevID = imageID
-			prevInstrument = instrument
-			prevSclk = sclk
-		}
-	}
-	return result
-}
-
 func savePNG(image image.Image, filename string) {
 	if err := lib.SavePNG(image, filename); err != nil {
 		fmt.Printf("Error saving %v: %v\n", filename, err)
 	}
 }
 
-func saveMetadata(sp StereoPair, filename string) {
+func saveMetadata(sp lib.StereoPair, filename string) {
 	b, err := json.MarshalIndent(sp, "", "  ")
 	if err != nil {
 		fmt.Println("Error marshaling composite image set to JSON:", err)
@@ -128,8 +48,14 @@ func saveMetadata(sp StereoPair, filename string) {
 	}
 }
 
-func makeImage(imageDB lib.ImageDB, sp StereoPair) (image.Image, error) {
-	cache, err := lib.NewImageCache(imageDB)
+// How each stereo pair is white balanced.
+type whiteBalanceOptions struct {
+	preset lib.WhiteBalancePreset
+	opts   lib.WhiteBalanceOptions
+}
+
+func makeImage(store lib.ImageStore, sp lib.StereoPair, wb whiteBalanceOptions) (image.Image, error) {
+	cache, err := lib.NewImageCache(store)
 	if err != nil {
 		return nil, fmt.Errorf("can't create image cache: %v", err)
 	}
@@ -165,17 +91,22 @@ func makeImage(imageDB lib.ImageDB, sp StereoPair) (image.Image, error) {
 	draw.Src.Draw(result, rightRect, rightReExposed, rightBounds.Min)
 
 	// TODO adjust dynamic range.
+	if wb.preset != lib.WhiteBalanceNone {
+		// Balance both halves alike, so that they still match.
+		balanced, _ := lib.WhiteBalanceImage(result, wb.opts)
+		return balanced, nil
+	}
 	return result, nil
 }
 
 type Job struct {
 	Index int
-	Pair  StereoPair
+	Pair  lib.StereoPair
 }
 
 func processJobs(
-	workerID int, jobs chan Job, imageDB lib.ImageDB,
-	wg *sync.WaitGroup,
+	workerID int, jobs chan Job, store lib.ImageStore,
+	wb whiteBalanceOptions, wg *sync.WaitGroup,
 ) {
 	for {
 		job, ok := <-jobs
@@ -193,7 +124,7 @@ func processJobs(
 
 		if !lib.FileExists(pngName) {
 			fmt.Println("L:", pair.Left, "R:", pair.Right)
-			image, err := makeImage(imageDB, pair)
+			image, err := makeImage(store, pair, wb)
 			if err != nil {
 				fmt.Println("Error creating stereo pair:", err)
 			} else {
@@ -204,7 +135,12 @@ func processJobs(
 	}
 }
 
-func processConcurrently(imageDB lib.ImageDB) {
+func processConcurrently(store lib.ImageStore, wb whiteBalanceOptions) {
+	pairs, err := lib.FindStereoPairs(store)
+	if err != nil {
+		fmt.Println("Error finding stereo pairs:", err)
+		return
+	}
 	concurrency := runtime.NumCPU()
 
 	wg := sync.WaitGroup{}
@@ -212,11 +148,11 @@ func processConcurrently(imageDB lib.ImageDB) {
 	jobs := make(chan Job, concurrency)
 	for i := 0; i < concurrency; i++ {
 		go func(workerID int) {
-			processJobs(workerID, jobs, imageDB, &wg)
+			processJobs(workerID, jobs, store, wb, &wg)
 		}(i)
 	}
 
-	for i, pair := range findStereoPairs(imageDB) {
+	for i, pair := range pairs {
 		jobs <- Job{i, pair}
 	}
 
@@ -225,10 +161,23 @@ func processConcurrently(imageDB lib.ImageDB) {
 }
 
 func main() {
+	whiteBalanceName := flag.String("white-balance", "none", "how to white balance stereo pairs: none, mars, keeping the warm light of Mars, or earth, as if lit by terrestrial daylight")
+	estimatorName := flag.String("wb-estimator", "", "override the white balance preset's estimator: gray-world, white-patch or percentile")
+	adaptationName := flag.String("adaptation", "", "override the white balance preset's chromatic adaptation: bradford, vonkries or cat02")
+	flag.Parse()
+
+	preset, wbOpts, err := lib.ParseWhiteBalanceOptions(*whiteBalanceName, *estimatorName, *adaptationName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wb := whiteBalanceOptions{preset: preset, opts: wbOpts}
+	// Stereo pairs are already processed concurrently.
+	wb.opts.Workers = 1
+
 	imageDB, err := lib.NewImageDB()
 	if err != nil {
 		log.Fatal("Could not instantiate image DB:", err)
 	}
 
-	processConcurrently(imageDB)
+	processConcurrently(&imageDB, wb)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+
+	"github.com/mchapman87501/go_mars_2020_img_utils/lib"
+)
+
+// Merge R, G and B color-separation frames into full-color images.  To
+// composite the merged frames of multi-frame sequences, use
+// assemble_composite_images -separations.
+
+const outDir = "color_separations/"
+
+func savePNG(image image.Image, filename string) {
+	if err := lib.SavePNG(image, filename); err != nil {
+		fmt.Printf("Error saving %v: %v\n", filename, err)
+	}
+}
+
+func mergeSeparation(cache lib.ImageCache, sep lib.ColorSeparation, opts lib.MergeOptions) {
+	filename := outDir + sep.CompositeImageInfo().ImageID + ".png"
+	if lib.FileExists(filename) {
+		return
+	}
+	fmt.Println("Merging", sep.Red.ImageID, sep.Green.ImageID, sep.Blue.ImageID)
+	merged, err := sep.Merge(cache, opts)
+	if err != nil {
+		fmt.Println("Error merging", sep.Red.ImageID, "-", err)
+		return
+	}
+	savePNG(merged, filename)
+}
+
+func processCamera(cache lib.ImageCache, store lib.ImageStore, camera string, opts lib.MergeOptions) {
+	separations, err := lib.FindColorSeparations(store, camera)
+	if err != nil {
+		fmt.Println("Error finding color separations for", camera, "-", err)
+		return
+	}
+	for _, sep := range separations {
+		mergeSeparation(cache, sep, opts)
+	}
+}
+
+func main() {
+	normalize := flag.Bool("normalize", false, "normalize the exposure of each color band")
+	percentile := flag.Float64("percentile", lib.DefaultNormalizePercentile, "percentile of each band that -normalize maps to full scale")
+	registrationRadius := flag.Int("register", 0, "if positive, align the red and blue bands with the green, searching up to this many pixels")
+	flag.Parse()
+
+	opts := lib.DefaultMergeOptions()
+	opts.NormalizeExposure = *normalize
+	opts.NormalizePercentile = *percentile
+	opts.RegistrationRadius = *registrationRadius
+
+	err := os.MkdirAll(outDir, 0755)
+	if err != nil {
+		log.Fatal("Could not create output directory", outDir, ":", err)
+	}
+
+	imageDB, err := lib.NewImageDB()
+	if err != nil {
+		log.Fatal("Could not instantiate image DB:", err)
+	}
+	cache, err := lib.NewImageCache(&imageDB)
+	if err != nil {
+		log.Fatal("Could not instantiate image cache:", err)
+	}
+
+	for _, camera := range imageDB.Cameras() {
+		processCamera(cache, &imageDB, camera, opts)
+	}
+}
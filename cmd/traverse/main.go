@@ -0,0 +1,98 @@
+package main
+
+// Reconstruct the rover traverse from the image database.
+// Subcommands:
+//   geojson       write the traverse as GeoJSON
+//   csv           write the traverse as CSV
+//   images S D    list the images taken at site S, drive D
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/mchapman87501/go_mars_2020_img_utils/lib"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [-db PATH] [-o FILE] geojson | csv | images SITE DRIVE\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func openOutput(pathname string) (io.WriteCloser, error) {
+	if pathname == "" || pathname == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(pathname)
+}
+
+func parseInt(s string, name string) int {
+	result, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("Invalid %v %q: %v", name, s, err)
+	}
+	return result
+}
+
+func main() {
+	dbPath := flag.String("db", lib.DefaultDBPathname, "pathname of the image database")
+	outPath := flag.String("o", "", "output file (default: standard output)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() <= 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	imageDB, err := lib.NewImageDBAtPath(*dbPath)
+	if err != nil {
+		log.Fatal("Could not instantiate image DB:", err)
+	}
+
+	outf, err := openOutput(*outPath)
+	if err != nil {
+		log.Fatal("Could not create output file:", err)
+	}
+	defer outf.Close()
+
+	switch subcommand := flag.Arg(0); subcommand {
+	case "geojson", "csv":
+		trav, err := imageDB.Traverse()
+		if err != nil {
+			log.Fatal("Could not build traverse:", err)
+		}
+		if subcommand == "geojson" {
+			err = trav.WriteGeoJSON(outf)
+		} else {
+			err = trav.WriteCSV(outf)
+		}
+		if err != nil {
+			log.Fatal("Error writing traverse:", err)
+		}
+
+	case "images":
+		if flag.NArg() != 3 {
+			usage()
+			os.Exit(2)
+		}
+		site := parseInt(flag.Arg(1), "site")
+		drive := parseInt(flag.Arg(2), "drive")
+		imageIDs, err := imageDB.ImagesAt(site, drive)
+		if err != nil {
+			log.Fatal("Could not retrieve images:", err)
+		}
+		for _, imageID := range imageIDs {
+			fmt.Fprintln(outf, imageID)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown subcommand:", subcommand)
+		usage()
+		os.Exit(2)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 
@@ -8,7 +9,11 @@ import (
 )
 
 func main() {
-	imageDB, err := lib.NewImageDB()
+	dbPath := flag.String("db", lib.DefaultDBPathname, "pathname of the image database")
+	saveRawDir := flag.String("save-raw", "", "archive each raw RSS API response page in this directory")
+	flag.Parse()
+
+	imageDB, err := lib.NewImageDBAtPath(*dbPath)
 	if err != nil {
 		log.Fatal("Could not instantiate image DB:", err)
 	}
@@ -19,8 +24,18 @@ func main() {
 		fmt.Println("Page", page+1)
 
 		params := lib.GetRequestParams(cameras, 100, page, -1, -1)
-		records, err := lib.GetImageMetadata(params)
+		rawJson, err := lib.GetRawImageMetadata(params)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if *saveRawDir != "" {
+			if err = lib.SaveRawPage(*saveRawDir, page, rawJson); err != nil {
+				log.Fatal("Could not save raw page:", err)
+			}
+		}
 
+		records, err := lib.ParseImageMetadata(rawJson)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -2,6 +2,9 @@ module github.com/mchapman87501/go_mars_2020_img_utils
 
 go 1.16
 
-require github.com/mattn/go-sqlite3 v1.14.6
+require (
+	github.com/mattn/go-sqlite3 v1.14.6
+	modernc.org/sqlite v1.10.6
+)
 
 replace github.com/mchapman87501/go_mars_2020_img_utils/lib => ./lib
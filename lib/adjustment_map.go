@@ -3,55 +3,260 @@ package lib
 import (
 	"fmt"
 	"math"
+	"sort"
 
 	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
 )
 
-// Use fixed-point values as map keys.
+// An AdjustmentMap models, for each Lab channel, how a tile's values map to
+// those of previously composited data where they overlap.  Samples are
+// binned by source value.  Complete fits a monotone curve through the bins
+// by isotonic regression, rejecting bins that stray far from the curve,
+// and records the curve as a set of knots.
+
+// Maps knots' source values to target values.
 type chanAdjustmentMap map[float64]float64
-type cntMap map[float64]float64
+
+// Width of sample bins, in Lab units.
+const adjustmentBinWidth = 0.5
+
+// Bins with fewer samples than this are too noisy to use, unless no bin
+// has enough.
+const minBinSamples = 3
+
+// Bins whose mean differs from the fitted curve by more than this many
+// robust standard deviations are rejected as outliers.
+const outlierThreshold = 3.0
+
+// Smallest robust standard deviation used for outlier rejection, so that
+// nearly perfect fits don't reject bins for trivial errors.
+const minResidualScale = 0.25
+
+// Accumulated samples whose source values fall in one bin.
+type adjustmentBin struct {
+	n, sumSrc, sumTarget, sumTargetSq float64
+}
+
+func (b adjustmentBin) src() float64 {
+	return b.sumSrc / b.n
+}
+
+func (b adjustmentBin) target() float64 {
+	return b.sumTarget / b.n
+}
+
+// Bins keyed by index: floor(source value / adjustmentBinWidth).
+type adjustmentBins map[int]adjustmentBin
+
+func (bins adjustmentBins) add(src, target float64) {
+	key := int(math.Floor(src / adjustmentBinWidth))
+	b := bins[key]
+	b.n += 1.0
+	b.sumSrc += src
+	b.sumTarget += target
+	b.sumTargetSq += target * target
+	bins[key] = b
+}
+
+func (bins adjustmentBins) merge(other adjustmentBins) {
+	for key, o := range other {
+		b := bins[key]
+		b.n += o.n
+		b.sumSrc += o.sumSrc
+		b.sumTarget += o.sumTarget
+		b.sumTargetSq += o.sumTargetSq
+		bins[key] = b
+	}
+}
+
+// ChannelFit describes how well a channel's fitted curve matches its
+// samples.
+type ChannelFit struct {
+	// Root-mean-square difference between the curve and the target values
+	// of all samples in retained bins.
+	RMS float64
+	// Largest difference between the curve and a retained bin's mean
+	// target value.
+	MaxBinError float64
+	// Number of bins retained, and number rejected either as outliers or
+	// for having too few samples.
+	Bins, Rejected int
+}
 
 type AdjustmentMap struct {
-	L, A, B          chanAdjustmentMap
-	lCnt, aCnt, bCnt cntMap
+	// Knots of each channel's fitted curve.  Valid after Complete.
+	L, A, B chanAdjustmentMap
+	// Quality of each channel's fit.  Valid after Complete.
+	LFit, AFit, BFit    ChannelFit
+	lBins, aBins, bBins adjustmentBins
 }
 
 func NewAdjustmentMap() *AdjustmentMap {
 	return &AdjustmentMap{
-		L:    make(chanAdjustmentMap),
-		A:    make(chanAdjustmentMap),
-		B:    make(chanAdjustmentMap),
-		lCnt: make(cntMap),
-		aCnt: make(cntMap),
-		bCnt: make(cntMap),
+		L:     make(chanAdjustmentMap),
+		A:     make(chanAdjustmentMap),
+		B:     make(chanAdjustmentMap),
+		lBins: make(adjustmentBins),
+		aBins: make(adjustmentBins),
+		bBins: make(adjustmentBins),
 	}
 }
 
 func (am *AdjustmentMap) AddSample(srcPix, targetPix lib_color.CIELab) {
-	srcL := srcPix.L
-	am.L[srcL] += targetPix.L
-	am.lCnt[srcL] += 1
-
-	srcA := srcPix.A
-	am.A[srcA] += targetPix.A
-	am.aCnt[srcA] += 1
+	am.lBins.add(srcPix.L, targetPix.L)
+	am.aBins.add(srcPix.A, targetPix.A)
+	am.bBins.add(srcPix.B, targetPix.B)
+}
 
-	srcB := srcPix.B
-	am.B[srcB] += targetPix.B
-	am.bCnt[srcB] += 1
+// Add another map's samples to this one.  Neither map may be complete.
+func (am *AdjustmentMap) Merge(other *AdjustmentMap) {
+	am.lBins.merge(other.lBins)
+	am.aBins.merge(other.aBins)
+	am.bBins.merge(other.bBins)
 }
 
 func (am *AdjustmentMap) Complete() {
-	completeChan := func(cam chanAdjustmentMap, counts cntMap, chanName string) {
-		for k := range cam {
-			cam[k] /= counts[k]
+	am.LFit = fitChannel(am.lBins, am.L)
+	am.AFit = fitChannel(am.aBins, am.A)
+	am.BFit = fitChannel(am.bBins, am.B)
+
+	am.addExtrema()
+}
+
+// Fit a monotone curve to bins, storing its knots in cam.
+func fitChannel(bins adjustmentBins, cam chanAdjustmentMap) ChannelFit {
+	keys := make([]int, 0, len(bins))
+	for key := range bins {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	usable := []adjustmentBin{}
+	for _, key := range keys {
+		if bins[key].n >= minBinSamples {
+			usable = append(usable, bins[key])
 		}
 	}
-	completeChan(am.L, am.lCnt, "L")
-	completeChan(am.A, am.aCnt, "a")
-	completeChan(am.B, am.bCnt, "b")
+	if len(usable) == 0 {
+		for _, key := range keys {
+			usable = append(usable, bins[key])
+		}
+	}
+	rejected := len(keys) - len(usable)
+	if len(usable) == 0 {
+		return ChannelFit{}
+	}
 
-	am.addExtrema()
+	xs, ys := isotonicKnots(usable)
+	if retained := rejectOutliers(usable, xs, ys); len(retained) < len(usable) {
+		rejected += len(usable) - len(retained)
+		usable = retained
+		xs, ys = isotonicKnots(usable)
+	}
+
+	for i, x := range xs {
+		cam[x] = ys[i]
+	}
+
+	result := ChannelFit{Bins: len(usable), Rejected: rejected}
+	sumSq := 0.0
+	n := 0.0
+	for _, b := range usable {
+		fitted := interpolateKnots(xs, ys, b.src())
+		err := b.target() - fitted
+		result.MaxBinError = math.Max(result.MaxBinError, math.Abs(err))
+		// Sum of squared differences between each sample and the curve.
+		sumSq += b.sumTargetSq - 2.0*fitted*b.sumTarget + b.n*fitted*fitted
+		n += b.n
+	}
+	result.RMS = math.Sqrt(math.Max(0.0, sumSq/n))
+	return result
+}
+
+// Fit a non-decreasing step function to bins' mean target values, weighted
+// by sample count, using the pool adjacent violators algorithm.  Returns
+// one knot per step, at the step's weighted mean source value.  Bins must
+// be sorted by source value.
+func isotonicKnots(bins []adjustmentBin) (xs, ys []float64) {
+	type block struct {
+		n, sumSrc, sumTarget float64
+	}
+	mean := func(b block) float64 { return b.sumTarget / b.n }
+
+	blocks := []block{}
+	for _, b := range bins {
+		blocks = append(blocks, block{b.n, b.sumSrc, b.sumTarget})
+		for len(blocks) > 1 {
+			last := blocks[len(blocks)-1]
+			prev := blocks[len(blocks)-2]
+			if mean(prev) <= mean(last) {
+				break
+			}
+			blocks = blocks[:len(blocks)-1]
+			blocks[len(blocks)-1] = block{prev.n + last.n, prev.sumSrc + last.sumSrc, prev.sumTarget + last.sumTarget}
+		}
+	}
+
+	xs = make([]float64, len(blocks))
+	ys = make([]float64, len(blocks))
+	for i, b := range blocks {
+		xs[i] = b.sumSrc / b.n
+		ys[i] = mean(b)
+	}
+	return
+}
+
+// Evaluate the piecewise linear curve through knots, extrapolating from
+// its end segments.  xs must be increasing.
+func interpolateKnots(xs, ys []float64, x float64) float64 {
+	if len(xs) == 1 {
+		return ys[0]
+	}
+	i := sort.SearchFloat64s(xs, x)
+	if i < 1 {
+		i = 1
+	} else if i >= len(xs) {
+		i = len(xs) - 1
+	}
+	fract := (x - xs[i-1]) / (xs[i] - xs[i-1])
+	return ys[i-1] + fract*(ys[i]-ys[i-1])
+}
+
+// Get the bins whose mean target values lie within outlierThreshold robust
+// standard deviations of the curve through knots.
+func rejectOutliers(bins []adjustmentBin, xs, ys []float64) []adjustmentBin {
+	type residual struct {
+		n, value float64
+	}
+	residuals := make([]residual, len(bins))
+	total := 0.0
+	for i, b := range bins {
+		residuals[i] = residual{b.n, math.Abs(b.target() - interpolateKnots(xs, ys, b.src()))}
+		total += b.n
+	}
+
+	// Weighted median absolute residual, scaled to estimate a normal
+	// distribution's standard deviation.
+	sorted := append([]residual{}, residuals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].value < sorted[j].value })
+	median := 0.0
+	cumulative := 0.0
+	for _, r := range sorted {
+		cumulative += r.n
+		if cumulative >= total/2.0 {
+			median = r.value
+			break
+		}
+	}
+	scale := math.Max(minResidualScale, 1.4826*median)
+
+	result := []adjustmentBin{}
+	for i, b := range bins {
+		if residuals[i].value <= outlierThreshold*scale {
+			result = append(result, b)
+		}
+	}
+	return result
 }
 
 func (am *AdjustmentMap) addExtrema() {
@@ -98,6 +303,13 @@ func (am *AdjustmentMap) addExtrema() {
 					cam[inVal] = outVal
 				}
 			}
+		} else if len(cam) > 0 {
+			// A single knot says only how much to shift values.
+			for _, inVal := range extrema {
+				if _, ok := cam[inVal]; !ok {
+					cam[inVal] = inVal + (minOut - minIn)
+				}
+			}
 		}
 	}
 
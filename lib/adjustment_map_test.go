@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Get a channel's knots, sorted by source value.
+func sortedKnots(cam chanAdjustmentMap) (xs, ys []float64) {
+	for x := range cam {
+		xs = append(xs, x)
+	}
+	sort.Float64s(xs)
+	for _, x := range xs {
+		ys = append(ys, cam[x])
+	}
+	return
+}
+
+func TestAdjustmentMapIsMonotoneAndCompact(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	am := NewAdjustmentMap()
+	for i := 0; i < 100000; i++ {
+		src := 20.0 + 60.0*rng.Float64()
+		target := 0.8*src + 5.0 + 2.0*rng.NormFloat64()
+		am.AddSample(lib_color.CIELab{L: src}, lib_color.CIELab{L: target})
+	}
+	am.Complete()
+
+	// 60 L units in half-unit bins, plus the extrema.
+	if len(am.L) > 122 {
+		t.Errorf("Expected at most 122 knots, got %v", len(am.L))
+	}
+	xs, ys := sortedKnots(am.L)
+	for i := 1; i < len(ys); i++ {
+		if ys[i] < ys[i-1] {
+			t.Fatalf("Expected a monotone mapping; %v -> %v but %v -> %v", xs[i-1], ys[i-1], xs[i], ys[i])
+		}
+	}
+
+	interp := NewFloat64Interpolator(am.L)
+	for _, src := range []float64{25.0, 50.0, 75.0} {
+		if got, want := interp.Interp(src), 0.8*src+5.0; math.Abs(got-want) > 0.5 {
+			t.Errorf("Expected %v to map to about %v, got %v", src, want, got)
+		}
+	}
+	if math.Abs(am.LFit.RMS-2.0) > 0.1 {
+		t.Errorf("Expected RMS residual of about 2, got %v", am.LFit.RMS)
+	}
+}
+
+func TestAdjustmentMapRejectsOutliers(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	am := NewAdjustmentMap()
+	for i := 0; i < 20000; i++ {
+		src := 30.0 + 40.0*rng.Float64()
+		target := src + 10.0 + 0.2*rng.NormFloat64()
+		// Misregistered highlights, say.
+		if (src > 50.0) && (src < 52.0) {
+			target += 25.0
+		}
+		am.AddSample(lib_color.CIELab{L: src}, lib_color.CIELab{L: target})
+	}
+	am.Complete()
+
+	if am.LFit.Rejected < 4 {
+		t.Errorf("Expected the outlying bins to be rejected, got %+v", am.LFit)
+	}
+	if am.LFit.MaxBinError > 1.0 {
+		t.Errorf("Expected retained bins to fit closely, got %+v", am.LFit)
+	}
+	interp := NewFloat64Interpolator(am.L)
+	for _, src := range []float64{40.0, 51.0, 60.0} {
+		if got := interp.Interp(src); math.Abs(got-(src+10.0)) > 0.5 {
+			t.Errorf("Expected %v to map to about %v, got %v", src, src+10.0, got)
+		}
+	}
+}
+
+func TestAdjustmentMapSparseBins(t *testing.T) {
+	am := NewAdjustmentMap()
+	// Plenty of samples at 40, but a lone sample at 60 that disagrees.
+	for i := 0; i < 10; i++ {
+		am.AddSample(lib_color.CIELab{L: 40.0}, lib_color.CIELab{L: 45.0})
+	}
+	am.AddSample(lib_color.CIELab{L: 60.0}, lib_color.CIELab{L: 20.0})
+	am.Complete()
+
+	if (am.LFit.Bins != 1) || (am.LFit.Rejected != 1) {
+		t.Errorf("Expected the sparse bin to be rejected, got %+v", am.LFit)
+	}
+	// One knot shifts all values.
+	interp := NewFloat64Interpolator(am.L)
+	for _, src := range []float64{40.0, 60.0, 40.1} {
+		if got := interp.Interp(src); math.Abs(got-(src+5.0)) > 1.0e-6 {
+			t.Errorf("Expected %v to map to %v, got %v", src, src+5.0, got)
+		}
+	}
+}
+
+func TestAdjustmentMapMergeMatchesSingleMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	whole := NewAdjustmentMap()
+	parts := []*AdjustmentMap{NewAdjustmentMap(), NewAdjustmentMap()}
+	for i := 0; i < 1000; i++ {
+		src := lib_color.CIELab{L: 100.0 * rng.Float64(), A: 20.0 * rng.NormFloat64(), B: 20.0 * rng.NormFloat64()}
+		target := lib_color.CIELab{L: src.L * 0.9, A: src.A + 1.0, B: src.B - 1.0}
+		whole.AddSample(src, target)
+		parts[i%2].AddSample(src, target)
+	}
+	merged := NewAdjustmentMap()
+	for _, part := range parts {
+		merged.Merge(part)
+	}
+	whole.Complete()
+	merged.Complete()
+
+	for _, pair := range [][2]chanAdjustmentMap{{whole.L, merged.L}, {whole.A, merged.A}, {whole.B, merged.B}} {
+		if len(pair[0]) != len(pair[1]) {
+			t.Fatalf("Expected %v knots, got %v", len(pair[0]), len(pair[1]))
+		}
+		wantXs, wantYs := sortedKnots(pair[0])
+		gotXs, gotYs := sortedKnots(pair[1])
+		for i := range wantXs {
+			if (math.Abs(gotXs[i]-wantXs[i]) > 1.0e-9) || (math.Abs(gotYs[i]-wantYs[i]) > 1.0e-9) {
+				t.Errorf("Knot %v: expected (%v, %v), got (%v, %v)", i, wantXs[i], wantYs[i], gotXs[i], gotYs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Global color balancing.  Sequential matching adjusts each tile to
+// whatever was composited before it, so the result depends on the order in
+// which tiles are added, and errors accumulate across a mosaic.  Global
+// balancing instead gathers statistics of every pairwise overlap first,
+// then solves for per-tile gain and offset corrections, for each of L, a
+// and b, that best equalize all overlaps at once.
+
+// ColorBalanceMode determines how tiles' colors are matched.
+type ColorBalanceMode int
+
+const (
+	// Adjust each tile to match whatever was composited before it.
+	BalanceSequential ColorBalanceMode = iota
+	// Solve for corrections to all tiles at once.  See AddTiles.
+	BalanceGlobal
+)
+
+var colorBalanceModeNames = map[ColorBalanceMode]string{
+	BalanceSequential: "sequential",
+	BalanceGlobal:     "global",
+}
+
+func (mode ColorBalanceMode) String() string {
+	if name, ok := colorBalanceModeNames[mode]; ok {
+		return name
+	}
+	return fmt.Sprintf("ColorBalanceMode(%d)", int(mode))
+}
+
+// Get the ColorBalanceMode with a given name, e.g., "global".
+func ParseColorBalanceMode(name string) (ColorBalanceMode, error) {
+	for mode, modeName := range colorBalanceModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return BalanceSequential, fmt.Errorf("unknown color balance mode %q", name)
+}
+
+// Tile is an image to be composited.
+type Tile struct {
+	Image image.Image
+	// Position in full-sensor coordinates.
+	SubframeRect image.Rectangle
+	// Sensor pixels per image pixel.
+	ScaleFactor int
+	// If Image is nil, Load gets the image each time it is needed, so that
+	// a set of tiles needn't all be held in memory.
+	Load func() (image.Image, error)
+}
+
+func (tile Tile) image() (image.Image, error) {
+	if tile.Image != nil {
+		return tile.Image, nil
+	}
+	return tile.Load()
+}
+
+// ChannelCorrection maps a channel value v to Gain*v + Offset.
+type ChannelCorrection struct {
+	Gain, Offset float64
+}
+
+func (c ChannelCorrection) apply(v float64) float64 {
+	return c.Gain*v + c.Offset
+}
+
+// ColorCorrection holds a tile's corrections for each Lab channel.
+type ColorCorrection struct {
+	L, A, B ChannelCorrection
+}
+
+func IdentityColorCorrection() ColorCorrection {
+	identity := ChannelCorrection{Gain: 1.0}
+	return ColorCorrection{identity, identity, identity}
+}
+
+func (c ColorCorrection) Apply(pix lib_color.CIELab) lib_color.CIELab {
+	return lib_color.CIELab{L: c.L.apply(pix.L), A: c.A.apply(pix.A), B: c.B.apply(pix.B)}
+}
+
+func (c ColorCorrection) applyTo(img *lib_image.CIELab, workers int) {
+	lib_image.ForEachRowBand(img.Bounds(), workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				img.SetCIELab(x, y, c.Apply(img.CIELabAt(x, y)))
+			}
+		}
+	})
+}
+
+// Gains are limited to this range, to keep noisy, low-contrast overlaps
+// from producing wild corrections.
+const minBalanceGain = 0.5
+const maxBalanceGain = 2.0
+
+// Overlaps whose standard deviation is below this, in either tile, say
+// nothing about relative gain.
+const minBalanceStdDev = 1.0e-3
+
+// Weight, in pixels, of each tile's preference for no correction.  This
+// keeps the system solvable when some tiles overlap nothing.
+const balanceRegularization = 1.0
+
+// Moments of one channel of one tile's pixels within an overlap.
+type channelMoments struct {
+	n, sum, sumSq float64
+}
+
+func (m *channelMoments) add(v float64) {
+	m.n += 1.0
+	m.sum += v
+	m.sumSq += v * v
+}
+
+func (m channelMoments) mean() float64 {
+	return m.sum / m.n
+}
+
+func (m channelMoments) stdDev() float64 {
+	mean := m.mean()
+	return math.Sqrt(math.Max(0.0, m.sumSq/m.n-mean*mean))
+}
+
+// Statistics of the overlap between tiles i and j, from each tile's side.
+type overlapStats struct {
+	i, j   int
+	rect   image.Rectangle
+	iSide  [3]channelMoments
+	jSide  [3]channelMoments
+	weight float64
+}
+
+// Gather statistics for every pair of overlapping tiles.  Each tile is
+// prepared once, and only its own pixels are needed for its side of each
+// overlap, so only one tile is held in memory at a time.  Overlaps with
+// tiles that cannot be loaded are omitted; the load errors are returned
+// by tile index.
+func (comp *Compositor) gatherOverlapStats(tiles []Tile) ([]*overlapStats, []error) {
+	rects := make([]image.Rectangle, len(tiles))
+	for i, tile := range tiles {
+		rects[i] = ScaledRect(tile.SubframeRect, comp.OutputScale).Intersect(comp.Bounds)
+	}
+
+	result := []*overlapStats{}
+	byTile := make([][]*overlapStats, len(tiles))
+	for i := range tiles {
+		for j := i + 1; j < len(tiles); j++ {
+			overlap := rects[i].Intersect(rects[j])
+			if overlap.Empty() {
+				continue
+			}
+			stats := &overlapStats{i: i, j: j, rect: overlap, weight: float64(overlap.Dx() * overlap.Dy())}
+			result = append(result, stats)
+			byTile[i] = append(byTile[i], stats)
+			byTile[j] = append(byTile[j], stats)
+		}
+	}
+
+	errs := make([]error, len(tiles))
+	for i, tile := range tiles {
+		if len(byTile[i]) == 0 {
+			continue
+		}
+		img, err := tile.image()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		lab, destRect := comp.prepareTile(img, tile.SubframeRect)
+		offset := destRect.Min.Sub(lab.Bounds().Min)
+		for _, stats := range byTile[i] {
+			side := &stats.iSide
+			if stats.j == i {
+				side = &stats.jSide
+			}
+			rect := stats.rect
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					pix := lab.CIELabAt(x-offset.X, y-offset.Y)
+					side[0].add(pix.L)
+					side[1].add(pix.A)
+					side[2].add(pix.B)
+				}
+			}
+		}
+	}
+
+	loaded := []*overlapStats{}
+	for _, stats := range result {
+		if (errs[stats.i] == nil) && (errs[stats.j] == nil) {
+			loaded = append(loaded, stats)
+		}
+	}
+	return loaded, errs
+}
+
+// A least-squares system whose unknowns are per-tile values x, built from
+// weighted equations x[i] - x[j] = d.  Each unknown is also weakly pulled
+// toward zero, so the solution is anchored: within each connected group of
+// tiles, the unknowns sum to zero.
+type differenceSystem struct {
+	a [][]float64
+	b []float64
+}
+
+func newDifferenceSystem(n int) *differenceSystem {
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n)
+		a[i][i] = balanceRegularization
+	}
+	return &differenceSystem{a, make([]float64, n)}
+}
+
+func (s *differenceSystem) addDifference(i, j int, d, weight float64) {
+	s.a[i][i] += weight
+	s.a[j][j] += weight
+	s.a[i][j] -= weight
+	s.a[j][i] -= weight
+	s.b[i] += weight * d
+	s.b[j] -= weight * d
+}
+
+// Solve by Gaussian elimination with partial pivoting.  The normal
+// equations are symmetric positive definite, so this can't fail.
+func (s *differenceSystem) solve() []float64 {
+	n := len(s.b)
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = append(append([]float64{}, s.a[i]...), s.b[i])
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := col + 1; row < n; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k <= n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+	result := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := a[row][n]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * result[k]
+		}
+		result[row] = sum / a[row][row]
+	}
+	return result
+}
+
+// Solve for one channel's corrections.  Gains equalize each overlap's
+// contrast, in log space so that they are anchored to a geometric mean of
+// 1.  Offsets then equalize each overlap's mean, anchored to a mean of 0.
+func solveChannelBalance(stats []*overlapStats, numTiles, channel int) []ChannelCorrection {
+	logGains := newDifferenceSystem(numTiles)
+	for _, s := range stats {
+		iStdDev := s.iSide[channel].stdDev()
+		jStdDev := s.jSide[channel].stdDev()
+		if (iStdDev >= minBalanceStdDev) && (jStdDev >= minBalanceStdDev) {
+			// gain[i] * iStdDev = gain[j] * jStdDev
+			logGains.addDifference(s.i, s.j, math.Log(jStdDev)-math.Log(iStdDev), s.weight)
+		}
+	}
+	gains := logGains.solve()
+	for i, logGain := range gains {
+		gains[i] = math.Max(minBalanceGain, math.Min(maxBalanceGain, math.Exp(logGain)))
+	}
+
+	offsets := newDifferenceSystem(numTiles)
+	for _, s := range stats {
+		// gain[i] * iMean + offset[i] = gain[j] * jMean + offset[j]
+		d := gains[s.j]*s.jSide[channel].mean() - gains[s.i]*s.iSide[channel].mean()
+		offsets.addDifference(s.i, s.j, d, s.weight)
+	}
+
+	result := make([]ChannelCorrection, numTiles)
+	for i, offset := range offsets.solve() {
+		result[i] = ChannelCorrection{Gain: gains[i], Offset: offset}
+	}
+	return result
+}
+
+// Get per-tile corrections that best equalize the colors of all
+// overlapping tiles, without compositing anything.  The corrections don't
+// depend on the order of tiles.  Tiles are compared at their nominal
+// positions, without registration.  Tiles that cannot be loaded are left
+// uncorrected, and don't affect the others.
+func (comp *Compositor) SolveColorBalance(tiles []Tile) []ColorCorrection {
+	result, _ := comp.solveColorBalance(tiles)
+	return result
+}
+
+func (comp *Compositor) solveColorBalance(tiles []Tile) ([]ColorCorrection, []error) {
+	stats, errs := comp.gatherOverlapStats(tiles)
+	lCorr := solveChannelBalance(stats, len(tiles), 0)
+	aCorr := solveChannelBalance(stats, len(tiles), 1)
+	bCorr := solveChannelBalance(stats, len(tiles), 2)
+
+	result := make([]ColorCorrection, len(tiles))
+	for i := range result {
+		result[i] = ColorCorrection{L: lCorr[i], A: aCorr[i], B: bCorr[i]}
+	}
+	return result, errs
+}
+
+// Add tiles in the order given, matching their colors as directed by
+// ColorBalance.  With BalanceGlobal, corrections are solved for all tiles
+// before any is composited, and the corrections of the tiles added are
+// recorded in ColorCorrections.  Tiles with Load functions are then loaded
+// twice.  Tiles that cannot be loaded are skipped; the load errors are
+// returned by tile index, nil for the tiles added.
+func (comp *Compositor) AddTiles(tiles []Tile) []error {
+	if comp.ColorBalance != BalanceGlobal {
+		errs := make([]error, len(tiles))
+		for i, tile := range tiles {
+			img, err := tile.image()
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			comp.AddScaledImage(img, tile.SubframeRect, tile.ScaleFactor)
+		}
+		return errs
+	}
+
+	corrections, errs := comp.solveColorBalance(tiles)
+	for i, tile := range tiles {
+		if errs[i] != nil {
+			continue
+		}
+		img, err := tile.image()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		comp.addTile(img, tile.SubframeRect, tile.ScaleFactor, &corrections[i])
+		comp.ColorCorrections = append(comp.ColorCorrections, corrections[i])
+	}
+	return errs
+}
@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"errors"
+	"image"
+	"math"
+	"testing"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+func TestParseColorBalanceMode(t *testing.T) {
+	for _, mode := range []ColorBalanceMode{BalanceSequential, BalanceGlobal} {
+		parsed, err := ParseColorBalanceMode(mode.String())
+		if (err != nil) || (parsed != mode) {
+			t.Errorf("Expected %v, got %v (%v)", mode, parsed, err)
+		}
+	}
+	if _, err := ParseColorBalanceMode("bogus"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+	if comp := NewCompositor(image.Rect(0, 0, 8, 8)); comp.ColorBalance != BalanceSequential {
+		t.Errorf("Expected sequential balancing by default, got %v", comp.ColorBalance)
+	}
+}
+
+// The scene's true color at a point.
+func balanceScene(x, y int) lib_color.CIELab {
+	fx := float64(x)
+	fy := float64(y)
+	return lib_color.CIELab{
+		L: 45.0 + 10.0*math.Sin(fx/7.0)*math.Cos(fy/5.0),
+		A: 5.0 + 3.0*math.Sin(fx/11.0),
+		B: 12.0 + 4.0*math.Cos(fy/13.0),
+	}
+}
+
+// A 2 x 2 grid of overlapping tiles, each of which sees the scene through
+// its own distortion.
+func balanceTiles() []Tile {
+	rects := []image.Rectangle{
+		image.Rect(0, 0, 48, 40),
+		image.Rect(32, 0, 80, 40),
+		image.Rect(0, 28, 48, 68),
+		image.Rect(32, 28, 80, 68),
+	}
+	distortions := []ColorCorrection{
+		{L: ChannelCorrection{1.0, 0.0}, A: ChannelCorrection{1.0, 0.0}, B: ChannelCorrection{1.0, 0.0}},
+		{L: ChannelCorrection{1.2, -3.0}, A: ChannelCorrection{0.9, 1.0}, B: ChannelCorrection{1.1, -1.0}},
+		{L: ChannelCorrection{0.85, 6.0}, A: ChannelCorrection{1.1, -0.5}, B: ChannelCorrection{0.9, 2.0}},
+		{L: ChannelCorrection{1.05, 2.0}, A: ChannelCorrection{1.0, 0.5}, B: ChannelCorrection{1.0, 0.0}},
+	}
+	tiles := make([]Tile, len(rects))
+	for i, rect := range rects {
+		img := lib_image.NewCIELab(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		for y := 0; y < rect.Dy(); y++ {
+			for x := 0; x < rect.Dx(); x++ {
+				img.SetCIELab(x, y, distortions[i].Apply(balanceScene(rect.Min.X+x, rect.Min.Y+y)))
+			}
+		}
+		tiles[i] = Tile{Image: img, SubframeRect: rect, ScaleFactor: 1}
+	}
+	return tiles
+}
+
+func TestSolveColorBalanceEqualizesOverlaps(t *testing.T) {
+	tiles := balanceTiles()
+	comp := NewCompositor(image.Rect(0, 0, 80, 68))
+	corrections := comp.SolveColorBalance(tiles)
+
+	// Corrected tiles should agree wherever they overlap.
+	corrected := func(i, x, y int) lib_color.CIELab {
+		rect := tiles[i].SubframeRect
+		pix := lib_color.CIELabModel.Convert(tiles[i].Image.At(x-rect.Min.X, y-rect.Min.Y)).(lib_color.CIELab)
+		return corrections[i].Apply(pix)
+	}
+	const tolerance = 0.2
+	for i := range tiles {
+		for j := i + 1; j < len(tiles); j++ {
+			overlap := tiles[i].SubframeRect.Intersect(tiles[j].SubframeRect)
+			for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+				for x := overlap.Min.X; x < overlap.Max.X; x++ {
+					pi := corrected(i, x, y)
+					pj := corrected(j, x, y)
+					if (math.Abs(pi.L-pj.L) > tolerance) || (math.Abs(pi.A-pj.A) > tolerance) || (math.Abs(pi.B-pj.B) > tolerance) {
+						t.Fatalf("Tiles %v and %v differ at (%v, %v) after correction: %v vs. %v", i, j, x, y, pi, pj)
+					}
+				}
+			}
+		}
+	}
+
+	// Corrections are anchored to the mean.
+	sumLogGain := 0.0
+	sumOffset := 0.0
+	for _, c := range corrections {
+		sumLogGain += math.Log(c.L.Gain)
+		sumOffset += c.L.Offset
+	}
+	if (math.Abs(sumLogGain) > 1.0e-6) || (math.Abs(sumOffset) > 1.0e-6) {
+		t.Errorf("Expected corrections anchored to the mean; sum of log gains %v, of offsets %v", sumLogGain, sumOffset)
+	}
+}
+
+func TestSolveColorBalanceIsOrderIndependent(t *testing.T) {
+	tiles := balanceTiles()
+	comp := NewCompositor(image.Rect(0, 0, 80, 68))
+	forward := comp.SolveColorBalance(tiles)
+
+	n := len(tiles)
+	reversed := make([]Tile, n)
+	for i, tile := range tiles {
+		reversed[n-1-i] = tile
+	}
+	backward := comp.SolveColorBalance(reversed)
+	for i := range forward {
+		f := forward[i]
+		b := backward[n-1-i]
+		for _, pair := range [][2]ChannelCorrection{{f.L, b.L}, {f.A, b.A}, {f.B, b.B}} {
+			if (math.Abs(pair[0].Gain-pair[1].Gain) > 1.0e-9) || (math.Abs(pair[0].Offset-pair[1].Offset) > 1.0e-9) {
+				t.Errorf("Tile %v: correction depends on order: %v vs. %v", i, f, b)
+			}
+		}
+	}
+}
+
+func TestSolveColorBalanceLeavesIsolatedTilesAlone(t *testing.T) {
+	tiles := []Tile{
+		{Image: uniformLabTile(image.Rect(0, 0, 16, 16), 30.0), SubframeRect: image.Rect(0, 0, 16, 16), ScaleFactor: 1},
+		{Image: uniformLabTile(image.Rect(0, 0, 16, 16), 70.0), SubframeRect: image.Rect(40, 0, 56, 16), ScaleFactor: 1},
+	}
+	comp := NewCompositor(image.Rect(0, 0, 56, 16))
+	for i, c := range comp.SolveColorBalance(tiles) {
+		if c != IdentityColorCorrection() {
+			t.Errorf("Expected no correction for isolated tile %v, got %v", i, c)
+		}
+	}
+}
+
+func TestAddTilesGlobalIsOrderIndependent(t *testing.T) {
+	tiles := balanceTiles()
+	composite := func(tiles []Tile) *Compositor {
+		comp := NewCompositor(image.Rect(0, 0, 80, 68))
+		comp.BlendMode = BlendDistanceWeighted
+		comp.ColorBalance = BalanceGlobal
+		comp.AddTiles(tiles)
+		return &comp
+	}
+	forward := composite(tiles)
+	if len(forward.ColorCorrections) != len(tiles) {
+		t.Fatalf("Expected %v corrections, got %v", len(tiles), len(forward.ColorCorrections))
+	}
+	backward := composite([]Tile{tiles[3], tiles[2], tiles[1], tiles[0]})
+
+	rect := forward.Bounds
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			f := forward.Result.CIELabAt(x, y)
+			b := backward.Result.CIELabAt(x, y)
+			if (math.Abs(f.L-b.L) > 1.0e-6) || (math.Abs(f.A-b.A) > 1.0e-6) || (math.Abs(f.B-b.B) > 1.0e-6) {
+				t.Fatalf("Composite depends on tile order at (%v, %v): %v vs. %v", x, y, f, b)
+			}
+		}
+	}
+}
+
+func TestAddTilesGlobalLoadsTiles(t *testing.T) {
+	tiles := balanceTiles()
+	composite := func(tiles []Tile) (*Compositor, []error) {
+		comp := NewCompositor(image.Rect(0, 0, 80, 68))
+		comp.BlendMode = BlendDistanceWeighted
+		comp.ColorBalance = BalanceGlobal
+		errs := comp.AddTiles(tiles)
+		return &comp, errs
+	}
+	want, _ := composite(tiles)
+
+	loads := make([]int, len(tiles))
+	loaded := make([]Tile, len(tiles))
+	for i, tile := range tiles {
+		i, img := i, tile.Image
+		loaded[i] = Tile{SubframeRect: tile.SubframeRect, ScaleFactor: tile.ScaleFactor}
+		loaded[i].Load = func() (image.Image, error) {
+			loads[i]++
+			return img, nil
+		}
+	}
+	got, errs := composite(loaded)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Tile %v: unexpected error %v", i, err)
+		}
+		// Once for overlap statistics, once for compositing.
+		if loads[i] != 2 {
+			t.Errorf("Tile %v: expected 2 loads, got %v", i, loads[i])
+		}
+	}
+	rect := want.Bounds
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if g, w := got.Result.CIELabAt(x, y), want.Result.CIELabAt(x, y); g != w {
+				t.Fatalf("Loaded tiles differ at (%v, %v): %v vs. %v", x, y, g, w)
+			}
+		}
+	}
+
+	// Tiles that cannot be loaded are skipped.
+	loaded[1].Load = func() (image.Image, error) {
+		return nil, errors.New("no such image")
+	}
+	got, errs = composite(loaded)
+	if errs[1] == nil {
+		t.Error("Expected an error for the tile that could not be loaded")
+	}
+	if len(got.ColorCorrections) != len(tiles)-1 {
+		t.Errorf("Expected %v corrections, got %v", len(tiles)-1, len(got.ColorCorrections))
+	}
+	if got.Covered(70, 10) {
+		t.Error("Expected the skipped tile's area to be uncovered")
+	}
+}
@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Color separations: sets of R, G and B frames (see the note about color
+// types in composite_image_set.go) that together make a full-color image.
+
+// ColorSeparation holds the red, green and blue frames of one exposure
+// sequence.  All three have the same sclk, camera, subframe and scale.
+type ColorSeparation struct {
+	Red, Green, Blue CompositeImageInfo
+}
+
+// Get the record of a merged color separation.  It has the red frame's
+// ID, with color type "F", so that it can be composited like any other
+// full-color frame.
+func (sep ColorSeparation) CompositeImageInfo() CompositeImageInfo {
+	result := sep.Red
+	result.ColorType = "F"
+	if len(result.ImageID) >= 3 {
+		result.ImageID = result.ImageID[:2] + "F" + result.ImageID[3:]
+	}
+	return result
+}
+
+type colorSeparationKey struct {
+	camera       string
+	sclk         float64
+	subframeRect image.Rectangle
+	scaleFactor  int
+}
+
+// Find all complete color separations for a camera, ordered by sclk, then
+// by subframe.
+func FindColorSeparations(store ImageStore, camera string) ([]ColorSeparation, error) {
+	result := []ColorSeparation{}
+	records, err := store.Query(ImageQuery{
+		Camera:          camera,
+		SampleType:      "Full",
+		ColorTypes:      []string{"R", "G", "B"},
+		HasSubframeRect: true,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// Frames with unknown sclk can't be matched.
+	groups := map[colorSeparationKey]*ColorSeparation{}
+	for _, record := range records {
+		info := newCompositeImageInfo(record)
+		if math.IsNaN(info.Sclk) {
+			continue
+		}
+		key := colorSeparationKey{info.Camera, info.Sclk, info.SubframeRect, info.ScaleFactor}
+		sep, ok := groups[key]
+		if !ok {
+			sep = &ColorSeparation{}
+			groups[key] = sep
+		}
+		// Records are ordered by image ID, so if a band has several frames
+		// the last one wins, consistently.
+		switch info.ColorType {
+		case "R":
+			sep.Red = info
+		case "G":
+			sep.Green = info
+		case "B":
+			sep.Blue = info
+		}
+	}
+
+	for _, sep := range groups {
+		if (sep.Red.ImageID != "") && (sep.Green.ImageID != "") && (sep.Blue.ImageID != "") {
+			result = append(result, *sep)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ri := result[i].Red
+		rj := result[j].Red
+		if ri.Sclk != rj.Sclk {
+			return ri.Sclk < rj.Sclk
+		}
+		// Then in the order used for compositing.
+		return CompositeImageSet{ri, rj}.Less(0, 1)
+	})
+	return result, nil
+}
+
+// Get composite image sets of merged color separations.  Their records
+// have color type "F"; see ColorSeparation.CompositeImageInfo.
+func ColorSeparationImageSets(seps []ColorSeparation) []CompositeImageSet {
+	records := make([]CompositeImageInfo, len(seps))
+	for i, sep := range seps {
+		records[i] = sep.CompositeImageInfo()
+	}
+	sortCompositeImageInfo(records)
+	return groupCompositeImageSets(records)
+}
+
+// MergeOptions control how color separations are merged.
+type MergeOptions struct {
+	// Scale each band so that its NormalizePercentile'th percentile maps to
+	// full scale.  This compensates for differing exposure times, at the
+	// expense of absolute color balance.
+	NormalizeExposure   bool
+	NormalizePercentile float64
+	// If positive, align the red and blue bands with the green band,
+	// searching up to this many pixels.
+	RegistrationRadius int
+}
+
+const DefaultNormalizePercentile = 99.5
+
+func DefaultMergeOptions() MergeOptions {
+	return MergeOptions{
+		NormalizePercentile: DefaultNormalizePercentile,
+	}
+}
+
+// Get an image's gray values, in the range 0...1.
+func grayPlane(img image.Image) *floatPlane {
+	bounds := img.Bounds()
+	result := newFloatPlane(bounds.Dx(), bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+			result.set(x-bounds.Min.X, y-bounds.Min.Y, float64(gray.Y)/0xffff)
+		}
+	}
+	return result
+}
+
+// Get a percentile, in 0...100, of a plane's values.
+func (p *floatPlane) percentile(pct float64) float64 {
+	if len(p.pix) <= 0 {
+		return 0.0
+	}
+	sorted := make([]float64, len(p.pix))
+	copy(sorted, p.pix)
+	sort.Float64s(sorted)
+	i := int(math.Round(pct / 100.0 * float64(len(sorted)-1)))
+	return sorted[clampInt(i, 0, len(sorted)-1)]
+}
+
+func (p *floatPlane) normalizeExposure(pct float64) {
+	level := p.percentile(pct)
+	if level <= 0.0 {
+		return
+	}
+	for i, v := range p.pix {
+		p.pix[i] = v / level
+	}
+}
+
+func planeValue16(v float64) uint16 {
+	return uint16(math.Round(math.Max(0.0, math.Min(1.0, v)) * 0xffff))
+}
+
+// Merge red, green and blue color separation frames into one full-color
+// image.  The frames must have the same dimensions.  Each frame's gray
+// value is taken as its band's value.
+func MergeColorSeparations(red, green, blue image.Image, opts MergeOptions) (*image.RGBA64, error) {
+	bounds := green.Bounds()
+	if !red.Bounds().Size().Eq(bounds.Size()) || !blue.Bounds().Size().Eq(bounds.Size()) {
+		return nil, fmt.Errorf(
+			"color separation frames differ in size: red %v, green %v, blue %v",
+			red.Bounds().Size(), bounds.Size(), blue.Bounds().Size())
+	}
+
+	bands := []*floatPlane{grayPlane(red), grayPlane(green), grayPlane(blue)}
+	if opts.NormalizeExposure {
+		for _, band := range bands {
+			band.normalizeExposure(opts.NormalizePercentile)
+		}
+	}
+	if opts.RegistrationRadius > 0 {
+		for _, i := range []int{0, 2} {
+			dx, dy, _, ok := planeOffset(bands[1], bands[i], image.Point{}, opts.RegistrationRadius)
+			if ok && ((dx != 0.0) || (dy != 0.0)) {
+				bands[i] = bands[i].translated(dx, dy)
+			}
+		}
+	}
+
+	result := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			result.SetRGBA64(x, y, color.RGBA64{
+				R: planeValue16(bands[0].at(x, y)),
+				G: planeValue16(bands[1].at(x, y)),
+				B: planeValue16(bands[2].at(x, y)),
+				A: 0xffff,
+			})
+		}
+	}
+	return result, nil
+}
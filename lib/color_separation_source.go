@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// Merge a color separation's red, green and blue frames, retrieved from
+// cache.
+func (sep ColorSeparation) Merge(cache ImageCache, opts MergeOptions) (*image.RGBA64, error) {
+	bands := []image.Image{}
+	for _, record := range []CompositeImageInfo{sep.Red, sep.Green, sep.Blue} {
+		band, err := cache.FullSize(record.ImageID)
+		if err != nil {
+			return nil, fmt.Errorf("can't retrieve image %v: %v", record.ImageID, err)
+		}
+		bands = append(bands, band)
+	}
+	return MergeColorSeparations(bands[0], bands[1], bands[2], opts)
+}
+
+// ColorSeparationSource is an ImageStore that presents each complete
+// color separation in another store as an F frame, so that merged
+// separations can be grouped and composited like any other full-color
+// frames.  Its FullSize merges a separation's frames on demand.  Frames
+// that already exist in the underlying store take precedence over merged
+// frames with the same ID.
+type ColorSeparationSource struct {
+	ImageStore
+	cache ImageCache
+	opts  MergeOptions
+	// Merged records, and their separations, keyed by image ID
+	records     map[string]ImageInfo
+	separations map[string]ColorSeparation
+}
+
+// Find the color separations of every camera in store.  cache retrieves
+// their frames, which are merged as opts directs.
+func NewColorSeparationSource(store ImageStore, cache ImageCache, opts MergeOptions) (*ColorSeparationSource, error) {
+	result := &ColorSeparationSource{
+		ImageStore:  store,
+		cache:       cache,
+		opts:        opts,
+		records:     map[string]ImageInfo{},
+		separations: map[string]ColorSeparation{},
+	}
+	for _, camera := range store.Cameras() {
+		seps, err := FindColorSeparations(store, camera)
+		if err != nil {
+			return result, err
+		}
+		for _, sep := range seps {
+			imageID := sep.CompositeImageInfo().ImageID
+			if _, err := store.Image(imageID); err == nil {
+				continue
+			}
+			record, err := store.Image(sep.Red.ImageID)
+			if err != nil {
+				return result, err
+			}
+			record.ImageID = imageID
+			result.records[imageID] = record
+			result.separations[imageID] = sep
+		}
+	}
+	return result, nil
+}
+
+// Get the color separation merged to make an image, if any.
+func (src *ColorSeparationSource) Separation(imageID string) (ColorSeparation, bool) {
+	sep, ok := src.separations[imageID]
+	return sep, ok
+}
+
+func (src *ColorSeparationSource) Image(imageID string) (ImageInfo, error) {
+	if record, ok := src.records[imageID]; ok {
+		return record, nil
+	}
+	return src.ImageStore.Image(imageID)
+}
+
+func (src *ColorSeparationSource) Query(query ImageQuery) ([]ImageInfo, error) {
+	result, err := src.ImageStore.Query(query)
+	if err != nil {
+		return result, err
+	}
+	for _, record := range src.records {
+		if query.Matches(record) {
+			result = append(result, record)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ImageID < result[j].ImageID
+	})
+	return result, nil
+}
+
+// Get the full-size image for an image ID, merging it if it is a color
+// separation.
+func (src *ColorSeparationSource) FullSize(imageID string) (image.Image, error) {
+	if sep, ok := src.separations[imageID]; ok {
+		return sep.Merge(src.cache, src.opts)
+	}
+	return src.cache.FullSize(imageID)
+}
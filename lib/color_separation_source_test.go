@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+func TestColorSeparationSource(t *testing.T) {
+	store := newSeparationStore(t)
+	cache, err := NewImageCacheAtPath(store, filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal("Error creating image cache:", err)
+	}
+	rect := image.Rect(0, 0, 64, 48)
+	frames := map[string]*image.Gray16{
+		"ZLR_0100_0001_A": separationFrame(rect, 1.0, 0.0, 0.0),
+		"ZLG_0100_0001_A": separationFrame(rect, 0.5, 0.0, 0.0),
+		"ZLB_0100_0001_A": separationFrame(rect, 0.25, 0.0, 0.0),
+	}
+	for imageID, frame := range frames {
+		if err := SavePNG(frame, cache.FullSizePath(imageID)); err != nil {
+			t.Fatal("Error saving frame:", err)
+		}
+	}
+
+	src, err := NewColorSeparationSource(store, cache, DefaultMergeOptions())
+	if err != nil {
+		t.Fatal("Error finding color separations:", err)
+	}
+	records, err := src.Query(ImageQuery{Camera: "MCZ_LEFT", ColorTypes: []string{"F"}})
+	if err != nil {
+		t.Fatal("Error querying records:", err)
+	}
+	want := []string{"ZLF_0100_0001_A", "ZLF_0100_0001_B", "ZLF_0100_0003_A"}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %v records, got %v", len(want), len(records))
+	}
+	for i, record := range records {
+		if record.ImageID != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], record.ImageID)
+		}
+	}
+	if _, ok := src.Separation("ZLF_0100_0003_A"); ok {
+		t.Error("Expected a stored F frame not to be a color separation")
+	}
+
+	// Merged frames are grouped like any other F frames.
+	query := CompositeSetQuery{ColorTypes: []string{"F"}, SampleType: "Full"}
+	imageSets, _, err := QueryGroupedImageSets(src, "MCZ_LEFT", query, DefaultGroupingOptions())
+	if err != nil {
+		t.Fatal("Error grouping image sets:", err)
+	}
+	if (len(imageSets) != 1) || (len(imageSets[0]) != 2) {
+		t.Fatalf("Expected one set of 2 merged frames, got %v", imageSets)
+	}
+
+	merged, err := src.FullSize("ZLF_0100_0001_A")
+	if err != nil {
+		t.Fatal("Error merging frames:", err)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := merged.At(x, y).RGBA()
+			if (uint16(r) != frames["ZLR_0100_0001_A"].Gray16At(x, y).Y) ||
+				(uint16(g) != frames["ZLG_0100_0001_A"].Gray16At(x, y).Y) ||
+				(uint16(b) != frames["ZLB_0100_0001_A"].Gray16At(x, y).Y) {
+				t.Fatalf("Unexpected merged pixel %v at (%v, %v)", merged.At(x, y), x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func separationRecord(imageID string, sclk float64, x int) ImageInfo {
+	result := ImageInfo{ImageID: imageID, SampleType: "Full"}
+	result.Camera.Instrument = "MCZ_LEFT"
+	result.Extended.Sclk = optFloat(sclk)
+	result.Extended.ScaleFactor = 1.0
+	result.Extended.SubframeRect = Rect{Origin{x, 1}, Size{64, 48}}
+	return result
+}
+
+func newSeparationStore(t *testing.T) *MemImageStore {
+	store := NewMemImageStore()
+	records := []ImageInfo{
+		separationRecord("ZLR_0100_0001_A", 1000.0, 1),
+		separationRecord("ZLG_0100_0001_A", 1000.0, 1),
+		separationRecord("ZLB_0100_0001_A", 1000.0, 1),
+		separationRecord("ZLR_0100_0001_B", 1000.0, 65),
+		separationRecord("ZLG_0100_0001_B", 1000.0, 65),
+		separationRecord("ZLB_0100_0001_B", 1000.0, 65),
+		// Incomplete: no blue frame.
+		separationRecord("ZLR_0100_0002_A", 2000.0, 1),
+		separationRecord("ZLG_0100_0002_A", 2000.0, 1),
+		// Not a color separation.
+		separationRecord("ZLF_0100_0003_A", 3000.0, 1),
+	}
+	if err := store.AddOrUpdate(records); err != nil {
+		t.Fatal("Error adding records:", err)
+	}
+	return store
+}
+
+func TestFindColorSeparations(t *testing.T) {
+	seps, err := FindColorSeparations(newSeparationStore(t), "MCZ_LEFT")
+	if err != nil {
+		t.Fatal("Error finding color separations:", err)
+	}
+	if len(seps) != 2 {
+		t.Fatalf("Expected 2 color separations, got %v", len(seps))
+	}
+	for i, suffix := range []string{"A", "B"} {
+		sep := seps[i]
+		if (sep.Red.ImageID != "ZLR_0100_0001_"+suffix) ||
+			(sep.Green.ImageID != "ZLG_0100_0001_"+suffix) ||
+			(sep.Blue.ImageID != "ZLB_0100_0001_"+suffix) {
+			t.Errorf("Unexpected color separation %v: %#v", i, sep)
+		}
+	}
+
+	info := seps[0].CompositeImageInfo()
+	if (info.ImageID != "ZLF_0100_0001_A") || (info.ColorType != "F") {
+		t.Errorf("Unexpected merged record %#v", info)
+	}
+
+	imageSets := ColorSeparationImageSets(seps)
+	if len(imageSets) != 1 {
+		t.Fatalf("Expected 1 image set, got %v", len(imageSets))
+	}
+	if len(imageSets[0]) != 2 {
+		t.Errorf("Expected 2 images in set, got %v", imageSets[0])
+	}
+}
+
+// Render a textured gray frame, with its content shifted by (dx, dy).
+func separationFrame(rect image.Rectangle, gain, dx, dy float64) *image.Gray16 {
+	result := image.NewGray16(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			v := sceneL(float64(x)-dx, float64(y)-dy) / 100.0 * gain
+			result.SetGray16(x, y, color.Gray16{planeValue16(v)})
+		}
+	}
+	return result
+}
+
+func TestMergeColorSeparations(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 48)
+	red := separationFrame(rect, 1.0, 0.0, 0.0)
+	green := separationFrame(rect, 0.5, 0.0, 0.0)
+	blue := separationFrame(rect, 0.25, 0.0, 0.0)
+
+	merged, err := MergeColorSeparations(red, green, blue, DefaultMergeOptions())
+	if err != nil {
+		t.Fatal("Error merging color separations:", err)
+	}
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			pix := merged.RGBA64At(x, y)
+			if (pix.R != red.Gray16At(x, y).Y) || (pix.G != green.Gray16At(x, y).Y) || (pix.B != blue.Gray16At(x, y).Y) {
+				t.Fatalf("Unexpected merged pixel %v at (%v, %v)", pix, x, y)
+			}
+		}
+	}
+
+	// With normalization, the bands should have similar levels.
+	opts := DefaultMergeOptions()
+	opts.NormalizeExposure = true
+	merged, err = MergeColorSeparations(red, green, blue, opts)
+	if err != nil {
+		t.Fatal("Error merging color separations:", err)
+	}
+	pix := merged.RGBA64At(32, 24)
+	if (math.Abs(float64(pix.R)-float64(pix.G)) > 256) || (math.Abs(float64(pix.R)-float64(pix.B)) > 256) {
+		t.Errorf("Expected normalized bands to match, got %v", pix)
+	}
+}
+
+func TestMergeColorSeparationsRegisters(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 48)
+	red := separationFrame(rect, 1.0, 1.5, -1.0)
+	green := separationFrame(rect, 1.0, 0.0, 0.0)
+	blue := separationFrame(rect, 1.0, -2.0, 0.5)
+
+	opts := DefaultMergeOptions()
+	opts.RegistrationRadius = 3
+	merged, err := MergeColorSeparations(red, green, blue, opts)
+	if err != nil {
+		t.Fatal("Error merging color separations:", err)
+	}
+	// Away from the edges, the registered bands should agree.
+	for y := 8; y < 40; y++ {
+		for x := 8; x < 56; x++ {
+			pix := merged.RGBA64At(x, y)
+			if (math.Abs(float64(pix.R)-float64(pix.G)) > 700) || (math.Abs(float64(pix.B)-float64(pix.G)) > 700) {
+				t.Fatalf("Expected registered bands to agree at (%v, %v), got %v", x, y, pix)
+			}
+		}
+	}
+}
+
+func TestMergeColorSeparationsSizeMismatch(t *testing.T) {
+	frame := image.NewGray(image.Rect(0, 0, 8, 8))
+	other := image.NewGray(image.Rect(0, 0, 8, 4))
+	if _, err := MergeColorSeparations(frame, frame, other, DefaultMergeOptions()); err == nil {
+		t.Error("Expected an error for mismatched frame sizes")
+	}
+}
@@ -0,0 +1,232 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Grouping of composite image records into image sets, with validation.
+// GetCompositeImageSets groups by exact sclk.  GroupCompositeImageSets
+// tolerates small sclk differences, can keep separate the interleaved
+// frames of different command sequences, and says why it rejects a set.
+
+// Get the command sequence ID from an image ID, or "" if there is none.
+// E.g., "NLF_0024_0669080250_161ECM_N0030792NCAM00194_01_290J01" has
+// sequence ID "NCAM00194".  The preceding characters of that field are the
+// venue and the rover motion counter.
+func SequenceID(imageID string) string {
+	fields := strings.Split(imageID, "_")
+	if len(fields) < 5 {
+		return ""
+	}
+	const rmcLength = 8
+	field := fields[4]
+	if len(field) <= rmcLength {
+		return ""
+	}
+	return field[rmcLength:]
+}
+
+// GroupingOptions control how records are grouped into image sets, and
+// how sets are validated.
+type GroupingOptions struct {
+	// Records whose sclk values differ by at most this many seconds, from
+	// one record to the next, belong to the same set.
+	SclkTolerance float64
+	// Records must have the same sequence ID to belong to the same set.
+	MatchSequenceID bool
+
+	// Accept sets whose tiles have different scale factors.
+	AllowMixedScales bool
+	// Reject sets whose union extent exceeds this size, in sensor pixels.
+	// Zero means no limit.
+	MaxExtent image.Point
+}
+
+// Typical sensors are at most 5120 x 3840.
+var DefaultMaxExtent = image.Pt(8192, 8192)
+
+func DefaultGroupingOptions() GroupingOptions {
+	return GroupingOptions{
+		MaxExtent: DefaultMaxExtent,
+	}
+}
+
+// Get the query with which assemble_composite_images selects tiles by
+// default: full-size E and F tiles.
+func DefaultAssemblyQuery() CompositeSetQuery {
+	return CompositeSetQuery{
+		ColorTypes: []string{"E", "F"},
+		SampleType: "Full",
+	}
+}
+
+// Get the options with which assemble_composite_images groups tiles by
+// default.  The compositor gives priority to higher-resolution tiles, so
+// sets may mix scales.
+func DefaultAssemblyGroupingOptions() GroupingOptions {
+	result := DefaultGroupingOptions()
+	result.AllowMixedScales = true
+	return result
+}
+
+// RejectedSet is a candidate image set that failed validation.
+type RejectedSet struct {
+	Set    CompositeImageSet
+	Reason string
+}
+
+type groupingKey struct {
+	camera     string
+	sequenceID string
+}
+
+// Group records into image sets and validate them.
+func GroupCompositeImageSets(records []CompositeImageInfo, opts GroupingOptions) ([]CompositeImageSet, []RejectedSet) {
+	// Partition by camera, and optionally by sequence ID, so that frames of
+	// unrelated sequences don't join a set just because they're interleaved
+	// in time.
+	partitions := map[groupingKey][]CompositeImageInfo{}
+	keys := []groupingKey{}
+	for _, record := range records {
+		key := groupingKey{camera: record.Camera}
+		if opts.MatchSequenceID {
+			key.sequenceID = SequenceID(record.ImageID)
+		}
+		if _, ok := partitions[key]; !ok {
+			keys = append(keys, key)
+		}
+		partitions[key] = append(partitions[key], record)
+	}
+
+	candidates := []CompositeImageSet{}
+	for _, key := range keys {
+		partition := partitions[key]
+		sort.SliceStable(partition, func(i, j int) bool {
+			return lessNaNFirst(partition[i].Sclk, partition[j].Sclk)
+		})
+
+		currImages := CompositeImageSet{}
+		for _, record := range partition {
+			if len(currImages) > 0 {
+				prevSclk := currImages[len(currImages)-1].Sclk
+				// NaN sclks never match.
+				if !(math.Abs(record.Sclk-prevSclk) <= opts.SclkTolerance) {
+					candidates = append(candidates, currImages)
+					currImages = CompositeImageSet{}
+				}
+			}
+			currImages = append(currImages, record)
+		}
+		if len(currImages) > 0 {
+			candidates = append(candidates, currImages)
+		}
+	}
+
+	// Order sets as GetCompositeImageSets does.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return lessCompositeImageInfo(candidates[i][0], candidates[j][0])
+	})
+
+	accepted := []CompositeImageSet{}
+	rejected := []RejectedSet{}
+	for _, candidate := range candidates {
+		if err := ValidateCompositeImageSet(candidate, opts); err != nil {
+			rejected = append(rejected, RejectedSet{candidate, err.Error()})
+		} else {
+			accepted = append(accepted, candidate)
+		}
+	}
+	return accepted, rejected
+}
+
+// Check whether an image set can be composited.  The returned error says
+// why not.
+func ValidateCompositeImageSet(imageSet CompositeImageSet, opts GroupingOptions) error {
+	if len(imageSet) < 2 {
+		return fmt.Errorf("set has %d image(s); need at least 2", len(imageSet))
+	}
+
+	first := imageSet[0]
+	extent := image.Rectangle{}
+	for _, record := range imageSet {
+		if record.Camera != first.Camera {
+			return fmt.Errorf("mixed cameras: %v and %v", first.Camera, record.Camera)
+		}
+		if !opts.AllowMixedScales && (record.ScaleFactor != first.ScaleFactor) {
+			return fmt.Errorf("mixed scale factors: %v and %v", first.ScaleFactor, record.ScaleFactor)
+		}
+		if record.SubframeRect.Empty() {
+			return fmt.Errorf("image %v has an empty subframe", record.ImageID)
+		}
+		extent = extent.Union(record.SubframeRect)
+	}
+
+	if ((opts.MaxExtent.X > 0) && (extent.Dx() > opts.MaxExtent.X)) ||
+		((opts.MaxExtent.Y > 0) && (extent.Dy() > opts.MaxExtent.Y)) {
+		return fmt.Errorf("extent %v exceeds maximum %v", extent.Size(), opts.MaxExtent)
+	}
+
+	if n := numConnectedTileGroups(imageSet); n > 1 {
+		return fmt.Errorf("tiles form %d disjoint groups that neither overlap nor abut", n)
+	}
+	return nil
+}
+
+// Do two rectangles overlap or share an edge?
+func overlapOrAbut(a, b image.Rectangle) bool {
+	return a.Inset(-1).Overlaps(b) && !cornersOnly(a, b)
+}
+
+// Do two rectangles touch only at a corner?
+func cornersOnly(a, b image.Rectangle) bool {
+	xTouch := (a.Max.X == b.Min.X) || (b.Max.X == a.Min.X)
+	yTouch := (a.Max.Y == b.Min.Y) || (b.Max.Y == a.Min.Y)
+	return xTouch && yTouch
+}
+
+// Count the groups of tiles connected by overlapping or abutting.
+func numConnectedTileGroups(imageSet CompositeImageSet) int {
+	n := len(imageSet)
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	result := n
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if overlapOrAbut(imageSet[i].SubframeRect, imageSet[j].SubframeRect) {
+				ri := find(i)
+				rj := find(j)
+				if ri != rj {
+					parent[ri] = rj
+					result -= 1
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Get validated image sets for a camera.
+func QueryGroupedImageSets(
+	store ImageStore, camera string, query CompositeSetQuery, opts GroupingOptions,
+) ([]CompositeImageSet, []RejectedSet, error) {
+	records, err := QueryCompositeImageInfoRecords(store, camera, query)
+	if err != nil {
+		return []CompositeImageSet{}, []RejectedSet{}, err
+	}
+	accepted, rejected := GroupCompositeImageSets(records, opts)
+	return accepted, rejected, nil
+}
@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestSequenceID(t *testing.T) {
+	tests := []struct {
+		imageID string
+		want    string
+	}{
+		{"NLF_0024_0669080250_161ECM_N0030792NCAM00194_01_290J01", "NCAM00194"},
+		{"SI0_0024_0669080907_106ECM_N0030792SRLC07015_0000LUJ", "SRLC07015"},
+		{"ZLR_0100_0001_A", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := SequenceID(test.imageID); got != test.want {
+			t.Errorf("SequenceID(%q): expected %q, got %q", test.imageID, test.want, got)
+		}
+	}
+}
+
+func groupingRecord(imageID string, sclk float64, x int) CompositeImageInfo {
+	return CompositeImageInfo{
+		ImageID:      imageID,
+		Sclk:         sclk,
+		SubframeRect: image.Rect(x, 0, x+64, 48),
+		ScaleFactor:  1,
+		Camera:       "NAVCAM_LEFT",
+		ColorType:    "E",
+	}
+}
+
+func setIDs(imageSet CompositeImageSet) []string {
+	result := []string{}
+	for _, record := range imageSet {
+		result = append(result, record.ImageID)
+	}
+	return result
+}
+
+func TestGroupCompositeImageSetsSclkTolerance(t *testing.T) {
+	records := []CompositeImageInfo{
+		groupingRecord("NLE_0001_0000001000_000ECM_N0000000NCAM00001_01_000J01", 1000.0, 0),
+		groupingRecord("NLE_0001_0000001000_500ECM_N0000000NCAM00001_01_000J01", 1000.5, 64),
+		groupingRecord("NLE_0001_0000001001_000ECM_N0000000NCAM00001_01_000J01", 1001.0, 128),
+		groupingRecord("NLE_0001_0000002000_000ECM_N0000000NCAM00001_01_000J01", 2000.0, 0),
+	}
+
+	opts := DefaultGroupingOptions()
+	accepted, rejected := GroupCompositeImageSets(records, opts)
+	if len(accepted) != 0 {
+		t.Errorf("Expected no sets with exact sclk matching, got %v", accepted)
+	}
+	if len(rejected) != 4 {
+		t.Errorf("Expected 4 rejected singletons, got %v", rejected)
+	}
+
+	opts.SclkTolerance = 0.6
+	accepted, rejected = GroupCompositeImageSets(records, opts)
+	if (len(accepted) != 1) || (len(accepted[0]) != 3) {
+		t.Fatalf("Expected one set of 3 images, got %v", accepted)
+	}
+	if len(rejected) != 1 {
+		t.Errorf("Expected 1 rejected set, got %v", rejected)
+	}
+}
+
+func TestGroupCompositeImageSetsSequenceID(t *testing.T) {
+	// Two sequences, interleaved at the same sclk.
+	records := []CompositeImageInfo{
+		groupingRecord("NLE_0001_0000001000_000ECM_N0000000NCAM00001_01_000J01", 1000.0, 0),
+		groupingRecord("NLE_0001_0000001000_001ECM_N0000000NCAM00002_01_000J01", 1000.0, 1000),
+		groupingRecord("NLE_0001_0000001000_002ECM_N0000000NCAM00001_01_000J01", 1000.0, 64),
+		groupingRecord("NLE_0001_0000001000_003ECM_N0000000NCAM00002_01_000J01", 1000.0, 1064),
+	}
+
+	opts := DefaultGroupingOptions()
+	accepted, rejected := GroupCompositeImageSets(records, opts)
+	if len(accepted) != 0 {
+		t.Errorf("Expected merged sequences to be rejected, got %v", accepted)
+	}
+	if (len(rejected) != 1) || !strings.Contains(rejected[0].Reason, "disjoint") {
+		t.Errorf("Expected a set rejected for disjoint tiles, got %v", rejected)
+	}
+
+	opts.MatchSequenceID = true
+	accepted, rejected = GroupCompositeImageSets(records, opts)
+	if len(accepted) != 2 {
+		t.Fatalf("Expected 2 sets, got %v (rejected %v)", accepted, rejected)
+	}
+	for _, imageSet := range accepted {
+		seqID := SequenceID(imageSet[0].ImageID)
+		for _, id := range setIDs(imageSet) {
+			if SequenceID(id) != seqID {
+				t.Errorf("Expected one sequence per set, got %v", setIDs(imageSet))
+			}
+		}
+	}
+}
+
+func TestValidateCompositeImageSet(t *testing.T) {
+	base := func() CompositeImageSet {
+		return CompositeImageSet{
+			groupingRecord("a", 1000.0, 0),
+			groupingRecord("b", 1000.0, 64),
+		}
+	}
+	opts := DefaultGroupingOptions()
+	if err := ValidateCompositeImageSet(base(), opts); err != nil {
+		t.Error("Expected abutting tiles to be valid:", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(CompositeImageSet) CompositeImageSet
+		reason string
+	}{
+		{"singleton", func(s CompositeImageSet) CompositeImageSet { return s[:1] }, "at least 2"},
+		{"camera", func(s CompositeImageSet) CompositeImageSet { s[1].Camera = "NAVCAM_RIGHT"; return s }, "mixed cameras"},
+		{"scale", func(s CompositeImageSet) CompositeImageSet { s[1].ScaleFactor = 2; return s }, "mixed scale"},
+		{"empty", func(s CompositeImageSet) CompositeImageSet { s[1].SubframeRect = image.Rectangle{}; return s }, "empty subframe"},
+		{"gap", func(s CompositeImageSet) CompositeImageSet { s[1].SubframeRect = image.Rect(65, 0, 129, 48); return s }, "disjoint"},
+		{"corner", func(s CompositeImageSet) CompositeImageSet { s[1].SubframeRect = image.Rect(64, 48, 128, 96); return s }, "disjoint"},
+		{"extent", func(s CompositeImageSet) CompositeImageSet {
+			s[1].SubframeRect = image.Rect(0, 0, 10000, 48)
+			return s
+		}, "extent"},
+	}
+	for _, test := range tests {
+		err := ValidateCompositeImageSet(test.modify(base()), opts)
+		if (err == nil) || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%v: expected rejection containing %q, got %v", test.name, test.reason, err)
+		}
+	}
+
+	mixed := base()
+	mixed[1].ScaleFactor = 2
+	opts.AllowMixedScales = true
+	if err := ValidateCompositeImageSet(mixed, opts); err != nil {
+		t.Error("Expected mixed scales to be allowed:", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"image"
 	"math"
+	"sort"
 )
 
 // Note about ColorType:
@@ -24,7 +25,10 @@ type CompositeImageInfo struct {
 	Drive   int
 	Sclk    float64
 
+	// SubframeRect is in full-sensor coordinates.  Each image pixel covers
+	// ScaleFactor x ScaleFactor sensor pixels.
 	SubframeRect image.Rectangle
+	ScaleFactor  int
 
 	Camera    string
 	ColorType string
@@ -37,66 +41,139 @@ func valOrNan(fval sql.NullFloat64) float64 {
 	return math.NaN()
 }
 
-func GetCompositeImageInfoRecords(idb ImageDB, camera string) ([]CompositeImageInfo, error) {
+// Get the sensor coordinates, counting from 0, of the image's first pixel.
+// Subframe rectangles count from 1.
+func (info CompositeImageInfo) SensorOrigin() image.Point {
+	return info.SubframeRect.Min.Sub(image.Pt(1, 1))
+}
+
+func newCompositeImageInfo(record ImageInfo) CompositeImageInfo {
+	sfr := record.Extended.SubframeRect
+	x := sfr.Origin.X
+	y := sfr.Origin.Y
+	return CompositeImageInfo{
+		ImageID:      record.ImageID,
+		Site:         int(record.Site),
+		Drive:        int(record.Drive),
+		Sclk:         float64(record.Extended.Sclk),
+		SubframeRect: image.Rect(x, y, x+sfr.Size.Width, y+sfr.Size.Height),
+		ScaleFactor:  int(record.Extended.ScaleFactor),
+		Camera:       record.Camera.Instrument,
+		ColorType:    record.ColorType(),
+	}
+}
+
+// CompositeSetQuery selects the images from which composite image sets are
+// formed.
+type CompositeSetQuery struct {
+	// Match any of these color types.  A set may contain tiles of
+	// different color types.
+	ColorTypes []string
+	SampleType string
+}
+
+// Get the query used by GetCompositeImageSets: full-size, Bayer-mosaiced
+// tiles.
+func DefaultCompositeSetQuery() CompositeSetQuery {
+	return CompositeSetQuery{
+		ColorTypes: []string{"E"},
+		SampleType: "Full",
+	}
+}
+
+func GetCompositeImageInfoRecords(store ImageStore, camera string) ([]CompositeImageInfo, error) {
+	return QueryCompositeImageInfoRecords(store, camera, DefaultCompositeSetQuery())
+}
+
+func QueryCompositeImageInfoRecords(store ImageStore, camera string, query CompositeSetQuery) ([]CompositeImageInfo, error) {
 	result := []CompositeImageInfo{}
-	rows, err := retrieveImageSets(idb, camera)
+	records, err := retrieveImageSets(store, camera, query)
 	if err != nil {
 		return result, err
 	}
-	defer rows.Close()
-
-	irow := 0
-	for rows.Next() {
-		record := CompositeImageInfo{}
-		x := 0
-		y := 0
-		width := 0
-		height := 0
-
-		// Hm... SQLite3 typically stores NaN as NULL?
-		// So hints StackOverflow
-		sclkValue := sql.NullFloat64{}
-		colorTypeStr := "U"
-
-		err := rows.Scan(
-			&record.ImageID, &record.Site, &record.Drive, &sclkValue,
-			&colorTypeStr, &x, &y, &width, &height, &record.Camera)
-		if err != nil {
-			err = fmt.Errorf("error extracting row %v: %v", irow, err)
-			return result, err
+
+	for _, record := range records {
+		// Tiles of unknown scale can't be placed.
+		scaleFactor := float64(record.Extended.ScaleFactor)
+		if math.IsNaN(scaleFactor) || (scaleFactor < 1.0) {
+			continue
 		}
-		record.Sclk = valOrNan(sclkValue)
-		record.SubframeRect = image.Rect(x, y, x+width, y+height)
-		record.ColorType = colorTypeStr
-		result = append(result, record)
+		result = append(result, newCompositeImageInfo(record))
 	}
-
+	result = removeDuplicateTiles(result)
+	sortCompositeImageInfo(result)
 	return result, nil
 }
 
-func retrieveImageSets(idb ImageDB, camera string) (*sql.Rows, error) {
-	query := `SELECT
-			image_id, site, drive, ext_sclk, color_type,
-			ext_sf_left x, ext_sf_top y,
-			ext_sf_width w, ext_sf_height h,
-			cam_instrument
-		FROM Images
-		WHERE cam_instrument = ?
-			AND sample_type = 'Full'
-			AND color_type = 'E'
-			AND ext_scale_factor = 1
-			AND x NOT NULL
-			AND y NOT NULL
-			AND w NOT NULL
-			and h NOT NULL
-		ORDER BY site, drive, ext_sclk, color_type, image_id`
+// Tiles of different color types that share a camera, sclk, scale and
+// subframe are versions of the same frame.
+type tileKey struct {
+	camera       string
+	sclk         float64
+	subframeRect image.Rectangle
+	scaleFactor  int
+}
+
+// Keep one version of each frame, preferring color types that need less
+// processing: F tiles are already demosaiced.
+func removeDuplicateTiles(records []CompositeImageInfo) []CompositeImageInfo {
+	preference := func(colorType string) int {
+		if colorType == "F" {
+			return 0
+		}
+		return 1
+	}
+	kept := map[tileKey]int{}
+	result := []CompositeImageInfo{}
+	for _, record := range records {
+		key := tileKey{record.Camera, record.Sclk, record.SubframeRect, record.ScaleFactor}
+		i, ok := kept[key]
+		if !ok {
+			kept[key] = len(result)
+			result = append(result, record)
+		} else if preference(record.ColorType) < preference(result[i].ColorType) {
+			result[i] = record
+		}
+	}
+	return result
+}
+
+// Order records so that the constituents of each composite are adjacent.
+func sortCompositeImageInfo(result []CompositeImageInfo) {
+	sort.SliceStable(result, func(i, j int) bool {
+		return lessCompositeImageInfo(result[i], result[j])
+	})
+}
+
+func lessCompositeImageInfo(ri, rj CompositeImageInfo) bool {
+	if ri.Site != rj.Site {
+		return ri.Site < rj.Site
+	}
+	if ri.Drive != rj.Drive {
+		return ri.Drive < rj.Drive
+	}
+	if ri.Sclk != rj.Sclk {
+		return lessNaNFirst(ri.Sclk, rj.Sclk)
+	}
+	if ri.ColorType != rj.ColorType {
+		return ri.ColorType < rj.ColorType
+	}
+	return ri.ImageID < rj.ImageID
+}
 
-	return idb.DB.Query(query, camera)
+func retrieveImageSets(store ImageStore, camera string, query CompositeSetQuery) ([]ImageInfo, error) {
+	return store.Query(ImageQuery{
+		Camera:          camera,
+		SampleType:      query.SampleType,
+		ColorTypes:      query.ColorTypes,
+		HasSubframeRect: true,
+	})
 }
 
 type CompositeImageSet []CompositeImageInfo
 
-// Implement sort.Interface to order composite images by their subframe rectangles
+// Implement sort.Interface to order composite images from coarsest to finest
+// scale, then by their subframe rectangles.
 func (cid CompositeImageSet) Len() int {
 	return len(cid)
 }
@@ -106,6 +183,9 @@ func (cid CompositeImageSet) Swap(i, j int) {
 }
 
 func (cid CompositeImageSet) Less(i, j int) bool {
+	if cid[i].ScaleFactor != cid[j].ScaleFactor {
+		return cid[i].ScaleFactor > cid[j].ScaleFactor
+	}
 	imin := cid[i].SubframeRect.Min
 	jmin := cid[j].SubframeRect.Min
 	if imin.X < jmin.X {
@@ -117,6 +197,17 @@ func (cid CompositeImageSet) Less(i, j int) bool {
 	return false
 }
 
+// Get the smallest scale factor of any image in the set.
+func (imageSet CompositeImageSet) FinestScale() int {
+	result := 0
+	for _, record := range imageSet {
+		if (result <= 0) || (record.ScaleFactor < result) {
+			result = record.ScaleFactor
+		}
+	}
+	return result
+}
+
 func (imageSet CompositeImageSet) Name() string {
 	if len(imageSet) <= 0 {
 		return "empty_image_set"
@@ -125,28 +216,34 @@ func (imageSet CompositeImageSet) Name() string {
 	return fmt.Sprintf("%v_%v_%.0f_%v_%v", firstImage.Camera, firstImage.ColorType, firstImage.Sclk, firstImage.Site, firstImage.Drive)
 }
 
-func GetCompositeImageSets(idb ImageDB, camera string) ([]CompositeImageSet, error) {
+func GetCompositeImageSets(store ImageStore, camera string) ([]CompositeImageSet, error) {
+	return QueryCompositeImageSets(store, camera, DefaultCompositeSetQuery())
+}
+
+func QueryCompositeImageSets(store ImageStore, camera string, query CompositeSetQuery) ([]CompositeImageSet, error) {
 	result := []CompositeImageSet{}
 
-	records, err := GetCompositeImageInfoRecords(idb, camera)
+	records, err := QueryCompositeImageInfoRecords(store, camera, query)
 	if err != nil {
 		return result, err
 	}
+	return groupCompositeImageSets(records), nil
+}
 
+// Split sorted records into composite image sets.
+func groupCompositeImageSets(records []CompositeImageInfo) []CompositeImageSet {
+	result := []CompositeImageSet{}
 	prevSclk := -1.0
-	prevColorType := "U"
 	currImages := []CompositeImageInfo{}
 	for _, record := range records {
-		// Images constituting a single composite have the same sclk and the
-		// same color type.
-		if (record.Sclk != prevSclk) || (record.ColorType != prevColorType) {
+		// Images constituting a single composite have the same sclk.
+		if record.Sclk != prevSclk {
 			// Composite image sets must have more than one constituent image.
 			if len(currImages) > 1 {
 				result = append(result, currImages)
 			}
 			currImages = []CompositeImageInfo{}
 			prevSclk = record.Sclk
-			prevColorType = record.ColorType
 		}
 		currImages = append(currImages, record)
 	}
@@ -154,5 +251,37 @@ func GetCompositeImageSets(idb ImageDB, camera string) ([]CompositeImageSet, err
 	if len(currImages) > 1 {
 		result = append(result, currImages)
 	}
-	return result, nil
+	return result
+}
+
+// Get a tile image ready for compositing: demosaic E tiles, and use F
+// tiles as they are.
+func CompositeTileImage(tile image.Image, colorType string) (image.Image, error) {
+	return CompositeTileImageWithOptions(tile, colorType, image.Point{}, DefaultDemosaicOptions())
+}
+
+// Get a tile image ready for compositing, demosaicing E tiles as opts
+// directs.  sensorOrigin gives the sensor coordinates of the tile's first
+// pixel; see CompositeImageInfo.SensorOrigin.
+func CompositeTileImageWithOptions(
+	tile image.Image, colorType string, sensorOrigin image.Point, opts DemosaicOptions,
+) (image.Image, error) {
+	switch colorType {
+	case "E":
+		return DemosaicTile(tile, sensorOrigin, opts)
+	case "F":
+		return tile, nil
+	}
+	return tile, fmt.Errorf("can't composite tiles of color type %q", colorType)
+}
+
+// Get a record's tile image ready for compositing.  E tiles are demosaiced
+// using the Bayer phase given by the record's SensorOrigin.  Scaled E tiles
+// are refused: each of their pixels spans several sensor pixels, so they
+// have no Bayer pattern to demosaic.
+func (info CompositeImageInfo) CompositeTileImage(tile image.Image, opts DemosaicOptions) (image.Image, error) {
+	if (info.ColorType == "E") && (info.ScaleFactor > 1) {
+		return tile, fmt.Errorf("can't demosaic %v: scale factor %d", info.ImageID, info.ScaleFactor)
+	}
+	return CompositeTileImageWithOptions(tile, info.ColorType, info.SensorOrigin(), opts)
 }
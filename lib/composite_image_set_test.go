@@ -2,14 +2,15 @@ package lib
 
 import (
 	"fmt"
+	"image"
 	"io/ioutil"
+	"reflect"
+	"sort"
 	"testing"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // TODO extract these to a test helper file.
-func loadDB(idb ImageDB, jsonPath string, t *testing.T) {
+func loadDB(store ImageStore, jsonPath string, t *testing.T) {
 	data, err := ioutil.ReadFile(jsonPath)
 	if err != nil || len(data) <= 0 {
 		t.Fatal("Failed to read test JSON file:", err)
@@ -21,17 +22,17 @@ func loadDB(idb ImageDB, jsonPath string, t *testing.T) {
 	}
 
 	fmt.Println("Record count:", len(records))
-	if err = idb.AddOrUpdate(records); err != nil {
+	if err = store.AddOrUpdate(records); err != nil {
 		t.Fatal("Error adding/updating DB records:", err)
 	}
 }
 
-func loadFakeData(idb ImageDB, t *testing.T) {
-	loadDB(idb, "test_data/with_fake_pano_set.json", t)
+func loadFakeData(store ImageStore, t *testing.T) {
+	loadDB(store, "test_data/with_fake_pano_set.json", t)
 }
 
-func loadSampleData(idb ImageDB, t *testing.T) {
-	loadDB(idb, "test_data/sample_rss_response.json", t)
+func loadSampleData(store ImageStore, t *testing.T) {
+	loadDB(store, "test_data/sample_rss_response.json", t)
 }
 
 func recreateInMemDB(t *testing.T) ImageDB {
@@ -40,13 +41,13 @@ func recreateInMemDB(t *testing.T) ImageDB {
 		t.Fatal("Could not create in-memory database:", err)
 	}
 
-	loadFakeData(idb, t)
+	loadFakeData(&idb, t)
 	return idb
 }
 
 func getCIRecords(camera string, t *testing.T) []CompositeImageInfo {
 	idb := recreateInMemDB(t)
-	records, err := GetCompositeImageInfoRecords(idb, camera)
+	records, err := GetCompositeImageInfoRecords(&idb, camera)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -67,7 +68,7 @@ func TestGetCompositeImageInfoRecords(t *testing.T) {
 
 func getCISets(camera string, t *testing.T) []CompositeImageSet {
 	idb := recreateInMemDB(t)
-	imageSets, err := GetCompositeImageSets(idb, camera)
+	imageSets, err := GetCompositeImageSets(&idb, camera)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -104,3 +105,168 @@ func TestGetCompositeImageSets(t *testing.T) {
 // TODO add sorting test.
 
 // TODO verify that all sets returned by GetCompositeImageSets have multiple images.
+
+func TestCompositeImageSetSortsCoarsestFirst(t *testing.T) {
+	imageSet := CompositeImageSet{
+		{ImageID: "fine_right", SubframeRect: image.Rect(64, 0, 128, 64), ScaleFactor: 1},
+		{ImageID: "fine_left", SubframeRect: image.Rect(0, 0, 64, 64), ScaleFactor: 1},
+		{ImageID: "coarse", SubframeRect: image.Rect(0, 0, 128, 64), ScaleFactor: 2},
+	}
+	sort.Sort(imageSet)
+	want := []string{"coarse", "fine_left", "fine_right"}
+	for i, record := range imageSet {
+		if record.ImageID != want[i] {
+			t.Fatalf("Expected order %v, got %v", want, imageSet)
+		}
+	}
+	if got := imageSet.FinestScale(); got != 1 {
+		t.Errorf("Expected finest scale 1, got %v", got)
+	}
+}
+
+func TestQueryCompositeImageSetsMixesColorTypes(t *testing.T) {
+	store := NewMemImageStore()
+	records := []ImageInfo{
+		separationRecord("ZLE_0100_0001_A", 1000.0, 1),
+		separationRecord("ZLF_0100_0001_B", 1000.0, 65),
+		separationRecord("ZLF_0100_0001_C", 1000.0, 129),
+		separationRecord("ZLF_0100_0002_A", 2000.0, 1),
+		separationRecord("ZLF_0100_0002_B", 2000.0, 65),
+	}
+	if err := store.AddOrUpdate(records); err != nil {
+		t.Fatal("Error adding records:", err)
+	}
+
+	imageSets, err := GetCompositeImageSets(store, "MCZ_LEFT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imageSets) != 0 {
+		t.Errorf("Expected no E-only image sets, got %v", imageSets)
+	}
+
+	query := DefaultCompositeSetQuery()
+	query.ColorTypes = []string{"E", "F"}
+	imageSets, err = QueryCompositeImageSets(store, "MCZ_LEFT", query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imageSets) != 2 {
+		t.Fatalf("Expected 2 image sets, got %v", imageSets)
+	}
+	if len(imageSets[0]) != 3 {
+		t.Errorf("Expected E and F tiles in one set, got %v", imageSets[0])
+	}
+	if len(imageSets[1]) != 2 {
+		t.Errorf("Expected 2 F tiles in second set, got %v", imageSets[1])
+	}
+
+	query.SampleType = "Thumbnail"
+	imageSets, err = QueryCompositeImageSets(store, "MCZ_LEFT", query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imageSets) != 0 {
+		t.Errorf("Expected no thumbnail image sets, got %v", imageSets)
+	}
+}
+
+func TestQueryCompositeImageSetsPrefersFTiles(t *testing.T) {
+	store := NewMemImageStore()
+	records := []ImageInfo{
+		// E and F versions of the same frame
+		separationRecord("ZLE_0100_0001_A", 1000.0, 1),
+		separationRecord("ZLF_0100_0001_A", 1000.0, 1),
+		separationRecord("ZLE_0100_0001_B", 1000.0, 65),
+		// Only an E version
+		separationRecord("ZLE_0100_0001_C", 1000.0, 129),
+	}
+	if err := store.AddOrUpdate(records); err != nil {
+		t.Fatal("Error adding records:", err)
+	}
+
+	query := DefaultCompositeSetQuery()
+	query.ColorTypes = []string{"E", "F"}
+	imageSets, rejected, err := QueryGroupedImageSets(store, "MCZ_LEFT", query, DefaultGroupingOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(imageSets) != 1) || (len(rejected) != 0) {
+		t.Fatalf("Expected 1 image set, got %v (rejected %v)", imageSets, rejected)
+	}
+	got := []string{}
+	for _, record := range imageSets[0] {
+		got = append(got, record.ImageID)
+	}
+	sort.Strings(got)
+	want := []string{"ZLE_0100_0001_B", "ZLE_0100_0001_C", "ZLF_0100_0001_A"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestCompositeTileImage(t *testing.T) {
+	tile := image.NewRGBA(image.Rect(0, 0, 8, 8))
+
+	got, err := CompositeTileImage(tile, "F")
+	if err != nil {
+		t.Fatal("Error preparing F tile:", err)
+	}
+	if got != image.Image(tile) {
+		t.Error("Expected F tile to be used as is")
+	}
+
+	got, err = CompositeTileImage(tile, "E")
+	if err != nil {
+		t.Fatal("Error preparing E tile:", err)
+	}
+	if !got.Bounds().Eq(tile.Bounds()) || (got == image.Image(tile)) {
+		t.Error("Expected E tile to be demosaiced")
+	}
+
+	if _, err = CompositeTileImage(image.NewGray(tile.Bounds()), "E"); err != nil {
+		t.Error("Error preparing grayscale E tile:", err)
+	}
+
+	if _, err = CompositeTileImage(tile, "R"); err == nil {
+		t.Error("Expected an error for a color separation tile")
+	}
+}
+
+func TestCompositeImageInfoSensorOrigin(t *testing.T) {
+	info := CompositeImageInfo{SubframeRect: image.Rect(1, 1, 1649, 1201)}
+	if got := info.SensorOrigin(); got != image.Pt(0, 0) {
+		t.Errorf("Expected a full frame to start at the sensor origin, got %v", got)
+	}
+	info.SubframeRect = image.Rect(642, 321, 1154, 833)
+	if got := info.SensorOrigin(); got != image.Pt(641, 320) {
+		t.Errorf("Expected (641, 320), got %v", got)
+	}
+}
+
+func TestCompositeImageInfoCompositeTileImage(t *testing.T) {
+	tile := image.NewGray(image.Rect(0, 0, 8, 8))
+	info := CompositeImageInfo{
+		ImageID: "ZLE_0100_0001_A", SubframeRect: image.Rect(2, 1, 10, 9), ScaleFactor: 1, ColorType: "E",
+	}
+	got, err := info.CompositeTileImage(tile, DefaultDemosaicOptions())
+	if err != nil {
+		t.Fatal("Error preparing E tile:", err)
+	}
+	want, err := DemosaicTile(tile, image.Pt(1, 0), DefaultDemosaicOptions())
+	if err != nil {
+		t.Fatal("Error demosaicing tile:", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("Expected the tile to be demosaiced at its sensor origin")
+	}
+
+	info.ScaleFactor = 2
+	if _, err := info.CompositeTileImage(tile, DefaultDemosaicOptions()); err == nil {
+		t.Error("Expected an error for a scaled E tile")
+	}
+	info.ColorType = "F"
+	if got, err := info.CompositeTileImage(tile, DefaultDemosaicOptions()); (err != nil) || (got != image.Image(tile)) {
+		t.Errorf("Expected a scaled F tile to be used as is, got %v", err)
+	}
+}
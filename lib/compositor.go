@@ -1,48 +1,391 @@
 package lib
 
 import (
+	"fmt"
 	"image"
-	"image/draw"
 	"math"
 
 	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
 	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
 )
 
+// BlendMode determines how a tile is combined with previously composited
+// tiles where they overlap.
+type BlendMode int
+
+const (
+	// Each tile replaces whatever lies beneath it.
+	BlendOverwrite BlendMode = iota
+	// Cross-fade linearly from previously composited data to the new tile,
+	// over FeatherWidth pixels inward from the new tile's edges.
+	BlendFeather
+	// Average all tiles covering a pixel, weighting each tile by the pixel's
+	// distance from the tile's nearest edge.
+	BlendDistanceWeighted
+	// Blend separately in each band of a Laplacian pyramid, so that low
+	// frequencies are blended over wide areas and high frequencies over
+	// narrow ones.
+	BlendMultiBand
+	// Cut along the path of least difference through each overlap, taking
+	// pixels on either side from the tile on that side.
+	BlendSeam
+)
+
+const DefaultFeatherWidth = 32
+const DefaultPyramidLevels = 6
+
+var blendModeNames = map[BlendMode]string{
+	BlendOverwrite:        "overwrite",
+	BlendFeather:          "feather",
+	BlendDistanceWeighted: "distance",
+	BlendMultiBand:        "multiband",
+	BlendSeam:             "seam",
+}
+
+func (mode BlendMode) String() string {
+	if name, ok := blendModeNames[mode]; ok {
+		return name
+	}
+	return fmt.Sprintf("BlendMode(%d)", int(mode))
+}
+
+// Get the BlendMode with a given name, e.g., "feather".
+func ParseBlendMode(name string) (BlendMode, error) {
+	for mode, modeName := range blendModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return BlendOverwrite, fmt.Errorf("unknown blend mode %q", name)
+}
+
 // Compositor builds a composite image from constituent tile
 // images.
 type Compositor struct {
-	Bounds     image.Rectangle
+	Bounds       image.Rectangle
+	BlendMode    BlendMode
+	FeatherWidth int
+	// Maximum number of pyramid levels used by BlendMultiBand.
+	PyramidLevels int
+	// If positive, register each tile against previously composited data,
+	// searching up to this many pixels from its nominal position.
+	RegistrationRadius int
+	// Corrections applied by registration, one per added tile.
+	Corrections []TileCorrection
+	// Sensor pixels per composite pixel.  Bounds is in composite pixels.
+	OutputScale int
+	// How tiles are resized when their scale differs from OutputScale.
+	ResampleFilter ResampleFilter
+	// How tiles' colors are matched to one another.
+	ColorBalance ColorBalanceMode
+	// Corrections applied by BalanceGlobal, one per tile passed to AddTiles.
+	ColorCorrections []ColorCorrection
+	// Number of goroutines used for per-pixel operations.  Zero means one
+	// per CPU.
+	Workers    int
 	addedAreas []image.Rectangle
-	Result     *lib_image.CIELab
+	Result     *lib_image.TiledCIELab
+	// Per-pixel bookkeeping, with the same bounds and blocks as Result.
+	// See the state* channels.
+	state *lib_image.BlockStore
 }
 
+// Channels of Compositor.state.
+const (
+	// Accumulated blend weight.  Zero where no tile has yet been added.
+	stateWeight = iota
+	// Scale factor of the finest tile covering the pixel.  Zero where no
+	// tile has yet been added.
+	stateScale
+	// 1 + the index of the tile that supplied the pixel, or zero where no
+	// tile has yet been added.  Where tiles are mixed, the tile with the
+	// greatest share supplied the pixel.
+	stateSource
+	// 1 + the largest distance from the pixel to the nearest edge of the
+	// registered content of any tile covering it, or zero where no tile
+	// has yet been added.
+	stateEdgeDistance
+	numStateChannels
+)
+
 func NewCompositor(rect image.Rectangle) Compositor {
+	return NewScaledCompositor(rect, 1)
+}
+
+// Create a compositor covering sensorRect, in full-sensor coordinates,
+// with outputScale sensor pixels per composite pixel.
+func NewScaledCompositor(sensorRect image.Rectangle, outputScale int) Compositor {
+	// Without a memory budget, storage is never paged and can't fail.
+	result, _ := NewCompositorWithStorage(sensorRect, outputScale, lib_image.DefaultStorageOptions())
+	return result
+}
+
+// Create a compositor whose result and bookkeeping are stored in blocks
+// as directed by storage.  If storage has a memory budget, the compositor
+// uses at most about that much memory for the composite, paging the rest
+// to a scratch file.  Close the compositor to remove the scratch file.
+func NewCompositorWithStorage(
+	sensorRect image.Rectangle, outputScale int, storage lib_image.StorageOptions,
+) (Compositor, error) {
+	if outputScale < 1 {
+		outputScale = 1
+	}
+	rect := ScaledRect(sensorRect, outputScale)
+
+	// Split the budget between Result and state by their numbers of
+	// channels.
+	budget := storage.MemoryBudget
+	storage.MemoryBudget = budget * 3 / (3 + numStateChannels)
+	result, err := lib_image.NewTiledCIELab(rect, storage)
+	if err != nil {
+		return Compositor{}, err
+	}
+	storage.MemoryBudget = budget - storage.MemoryBudget
+	state, err := lib_image.NewBlockStore(rect, numStateChannels, storage)
+	if err != nil {
+		result.Close()
+		return Compositor{}, err
+	}
 	return Compositor{
-		rect,
-		[]image.Rectangle{},
-		lib_image.NewCIELab(rect),
+		Bounds:         rect,
+		BlendMode:      BlendOverwrite,
+		FeatherWidth:   DefaultFeatherWidth,
+		PyramidLevels:  DefaultPyramidLevels,
+		Corrections:    []TileCorrection{},
+		OutputScale:    outputScale,
+		ResampleFilter: ResampleBilinear,
+		addedAreas:     []image.Rectangle{},
+		Result:         result,
+		state:          state,
+	}, nil
+}
+
+// Get the first error encountered while paging the composite to its
+// scratch files, if any.
+func (comp *Compositor) Err() error {
+	if err := comp.Result.Err(); err != nil {
+		return err
 	}
+	return comp.state.Err()
+}
+
+// Release the compositor's scratch files, if any.  Result must not be used
+// afterward.
+func (comp *Compositor) Close() error {
+	err := comp.Result.Close()
+	if stateErr := comp.state.Close(); err == nil {
+		err = stateErr
+	}
+	return err
 }
 
 // Add a new image.  Adjust its colors as necessary to match
 // any overlapping image data that has already been composited.
+// If RegistrationRadius is positive, first align the image with that data.
 func (comp *Compositor) AddImage(image image.Image, subframeRect image.Rectangle) {
-	adjustedImage := comp.matchColors(image, subframeRect)
-	srcPoint := adjustedImage.Bounds().Min
-	draw.Src.Draw(comp.Result, subframeRect, adjustedImage, srcPoint)
-	comp.addedAreas = append(comp.addedAreas, subframeRect)
+	comp.AddScaledImage(image, subframeRect, 1)
+}
+
+// Add a new image whose pixels are binned by scaleFactor.  subframeRect is
+// in full-sensor coordinates.  The image is resampled to the composite's
+// OutputScale.
+//
+// Where the image has finer resolution than previously composited data, it
+// replaces that data.  Add tiles coarsest first, as CompositeImageSet's sort
+// order does, so that the highest-resolution data takes priority.
+func (comp *Compositor) AddScaledImage(image image.Image, subframeRect image.Rectangle, scaleFactor int) {
+	comp.addTile(image, subframeRect, scaleFactor, nil)
+}
+
+// Convert an image to Lab at the composite's scale.  Returns the tile and
+// its position in the composite.
+func (comp *Compositor) prepareTile(img image.Image, subframeRect image.Rectangle) (*lib_image.CIELab, image.Rectangle) {
+	destRect := ScaledRect(subframeRect, comp.OutputScale)
+	tile := lib_image.CIELabFromImageParallel(img, comp.Workers)
+	if !tile.Bounds().Size().Eq(destRect.Size()) {
+		tile = ResampleLab(tile, destRect.Dx(), destRect.Dy(), comp.ResampleFilter)
+	}
+	return tile, destRect
+}
+
+// Add a tile.  If correction is nil, match its colors to previously
+// composited data; otherwise apply correction instead.
+func (comp *Compositor) addTile(
+	img image.Image, subframeRect image.Rectangle, scaleFactor int, correction *ColorCorrection,
+) {
+	if scaleFactor < 1 {
+		scaleFactor = 1
+	}
+	tile, destRect := comp.prepareTile(img, subframeRect)
+
+	coverRect := destRect
+	if comp.RegistrationRadius > 0 {
+		tile = comp.register(tile, destRect)
+		coverRect = comp.Corrections[len(comp.Corrections)-1].registeredRect()
+	}
+	if correction != nil {
+		correction.applyTo(tile, comp.Workers)
+	} else {
+		comp.matchColors(tile, destRect)
+	}
+	comp.yieldToFinerScale(destRect, scaleFactor)
+	comp.blend(tile, destRect)
+	comp.recordScale(destRect, scaleFactor)
+	comp.recordArea(destRect, coverRect)
 }
 
-func (comp *Compositor) matchColors(tileImage image.Image, destRect image.Rectangle) image.Image {
-	result := lib_image.CIELabFromImage(tileImage)
+// Mark pixels covered only by coarser data than scaleFactor as uncovered,
+// so that a new tile replaces them outright.
+func (comp *Compositor) yieldToFinerScale(destRect image.Rectangle, scaleFactor int) {
+	rect := destRect.Intersect(comp.Bounds)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if comp.scale(x, y) > scaleFactor {
+				comp.setWeight(x, y, 0.0)
+			}
+		}
+	}
+}
 
-	adjustments := comp.makeValueAdjustmentMap(result, destRect)
-	adjustColors(result, adjustments)
+func (comp *Compositor) recordScale(destRect image.Rectangle, scaleFactor int) {
+	rect := destRect.Intersect(comp.Bounds)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			scale := comp.scale(x, y)
+			if (comp.weight(x, y) > 0.0) && ((scale == 0) || (scale > scaleFactor)) {
+				comp.state.SetValue(x, y, stateScale, float64(scaleFactor))
+			}
+		}
+	}
+}
 
+func (comp *Compositor) matchColors(tile *lib_image.CIELab, destRect image.Rectangle) {
+	adjustments := comp.makeValueAdjustmentMap(tile, destRect)
+	adjustColors(tile, adjustments, comp.Workers)
+}
+
+func (comp *Compositor) weight(x, y int) float64 {
+	return comp.state.Value(x, y, stateWeight)
+}
+
+func (comp *Compositor) setWeight(x, y int, weight float64) {
+	comp.state.SetValue(x, y, stateWeight, weight)
+}
+
+func (comp *Compositor) scale(x, y int) int {
+	return int(comp.state.Value(x, y, stateScale))
+}
+
+func (comp *Compositor) source(x, y int) int {
+	return int(comp.state.Value(x, y, stateSource))
+}
+
+// Record that the tile being added supplied the pixel at x, y.
+func (comp *Compositor) setSource(x, y int) {
+	comp.state.SetValue(x, y, stateSource, float64(len(comp.addedAreas)+1))
+}
+
+// Get the distance from a pixel to the nearest edge of a tile.  Tile edges
+// that lie on the composite's boundary are ignored, since there is nothing
+// beyond them to blend with.
+func (comp *Compositor) edgeDistance(x, y int, tileRect image.Rectangle) int {
+	result := -1
+	consider := func(d int, onBoundary bool) {
+		if !onBoundary && ((result < 0) || (d < result)) {
+			result = d
+		}
+	}
+	consider(x-tileRect.Min.X, tileRect.Min.X <= comp.Bounds.Min.X)
+	consider(tileRect.Max.X-1-x, tileRect.Max.X >= comp.Bounds.Max.X)
+	consider(y-tileRect.Min.Y, tileRect.Min.Y <= comp.Bounds.Min.Y)
+	consider(tileRect.Max.Y-1-y, tileRect.Max.Y >= comp.Bounds.Max.Y)
+	if result < 0 {
+		// The tile covers the whole composite.
+		result = tileRect.Dx() + tileRect.Dy()
+	}
 	return result
 }
 
+// Get the weight of a tile pixel relative to previously composited data.
+func (comp *Compositor) tileWeight(x, y int, tileRect image.Rectangle) float64 {
+	d := float64(comp.edgeDistance(x, y, tileRect))
+	switch comp.BlendMode {
+	case BlendFeather:
+		width := float64(comp.FeatherWidth)
+		if width <= 0.0 {
+			return 1.0
+		}
+		// Ramp across no more than the overlap with previous tiles, so the
+		// tile is opaque by the time the overlap ends.
+		if covered := comp.coveredEdgeDistance(x, y); covered >= 0 {
+			width = math.Min(width, d+float64(covered)+1.0)
+		}
+		return math.Min(1.0, (d+1.0)/width)
+	case BlendDistanceWeighted:
+		return d + 1.0
+	}
+	return 1.0
+}
+
+func mixLab(prev, curr lib_color.CIELab, currFract float64) lib_color.CIELab {
+	prevFract := 1.0 - currFract
+	return lib_color.CIELab{
+		L: prev.L*prevFract + curr.L*currFract,
+		A: prev.A*prevFract + curr.A*currFract,
+		B: prev.B*prevFract + curr.B*currFract,
+	}
+}
+
+// Blend a color-matched tile into the composite.
+func (comp *Compositor) blend(tile *lib_image.CIELab, destRect image.Rectangle) {
+	switch comp.BlendMode {
+	case BlendMultiBand:
+		comp.blendMultiBand(tile, destRect)
+		return
+	case BlendSeam:
+		comp.blendSeam(tile, destRect)
+		return
+	}
+
+	tileOffset := destRect.Min.Sub(tile.Bounds().Min)
+	rect := destRect.Intersect(comp.Bounds)
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			src := tile.CIELabAt(x-tileOffset.X, y-tileOffset.Y)
+			prevWeight := comp.weight(x, y)
+			if (comp.BlendMode == BlendOverwrite) || (prevWeight <= 0.0) {
+				comp.Result.SetCIELab(x, y, src)
+				comp.setWeight(x, y, comp.tileWeight(x, y, destRect))
+				comp.setSource(x, y)
+				continue
+			}
+
+			prev := comp.Result.CIELabAt(x, y)
+			weight := comp.tileWeight(x, y, destRect)
+			switch comp.BlendMode {
+			case BlendFeather:
+				// weight is the opacity of the new tile.
+				comp.Result.SetCIELab(x, y, mixLab(prev, src, weight))
+				comp.setWeight(x, y, 1.0)
+				if weight >= 0.5 {
+					comp.setSource(x, y)
+				}
+			case BlendDistanceWeighted:
+				total := prevWeight + weight
+				comp.Result.SetCIELab(x, y, mixLab(prev, src, weight/total))
+				comp.setWeight(x, y, total)
+				if weight >= prevWeight {
+					comp.setSource(x, y)
+				}
+			}
+		}
+	}
+}
+
+// Height of the row bands in which overlaps are sampled concurrently.
+const adjustmentSampleRows = 16
+
 func (comp *Compositor) makeValueAdjustmentMap(tileImage *lib_image.CIELab, destRect image.Rectangle) *AdjustmentMap {
 	// What is the tile image's origin in composite image coordinates?
 	tileOrigin := tileImage.Bounds().Min
@@ -56,32 +399,157 @@ func (comp *Compositor) makeValueAdjustmentMap(tileImage *lib_image.CIELab, dest
 
 	for _, rect := range comp.addedAreas {
 		overlap := rect.Intersect(destRect)
-		if !overlap.Empty() {
-			for x := overlap.Min.X; x < overlap.Max.X; x++ {
-				for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+		if overlap.Empty() {
+			continue
+		}
+		// Sample row bands concurrently, then merge in band order.  The
+		// bands don't depend on the number of workers, so neither does
+		// the floating point sum of the samples.
+		bands := lib_image.RowBands(overlap, (overlap.Dy()+adjustmentSampleRows-1)/adjustmentSampleRows)
+		bandMaps := make([]*AdjustmentMap, len(bands))
+		lib_image.ForEachRect(bands, comp.Workers, func(i int, band image.Rectangle) {
+			bandMap := NewAdjustmentMap()
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				for x := band.Min.X; x < band.Max.X; x++ {
+					if !comp.Covered(x, y) {
+						continue
+					}
 					srcPix := tileImage.CIELabAt(x-tileOffset.X, y-tileOffset.Y)
 					targetPix := comp.Result.CIELabAt(x, y)
-					result.AddSample(srcPix, targetPix)
+					bandMap.AddSample(srcPix, targetPix)
 				}
 			}
+			bandMaps[i] = bandMap
+		})
+		for _, bandMap := range bandMaps {
+			result.Merge(bandMap)
 		}
 	}
 	result.Complete()
 	return result
 }
 
-func adjustColors(image *lib_image.CIELab, adjustments *AdjustmentMap) {
-	lInterp := NewFloat64Interpolator(adjustments.L)
-	aInterp := NewFloat64Interpolator(adjustments.A)
-	bInterp := NewFloat64Interpolator(adjustments.B)
+// Entries in the lookup tables used to adjust colors, per Lab unit.
+const adjustmentLUTDensity = 16
+
+func adjustColors(img *lib_image.CIELab, adjustments *AdjustmentMap, workers int) {
+	lInterp := NewFloat64Interpolator(adjustments.L).WithLUT(0.0, 100.0, 100*adjustmentLUTDensity+1)
+	aInterp := NewFloat64Interpolator(adjustments.A).WithLUT(-128.0, 127.0, 255*adjustmentLUTDensity+1)
+	bInterp := NewFloat64Interpolator(adjustments.B).WithLUT(-128.0, 127.0, 255*adjustmentLUTDensity+1)
+
+	lib_image.ForEachRowBand(img.Bounds(), workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				pix := img.CIELabAt(x, y)
+				pix.L = lInterp.Interp(pix.L)
+				pix.A = aInterp.Interp(pix.A)
+				pix.B = bInterp.Interp(pix.B)
+				img.SetCIELab(x, y, pix)
+			}
+		}
+	})
+}
 
-	for y := image.Bounds().Min.Y; y < image.Bounds().Max.Y; y++ {
-		for x := image.Bounds().Min.X; x < image.Bounds().Max.X; x++ {
-			pix := image.CIELabAt(x, y)
-			pix.L = lInterp.Interp(pix.L)
-			pix.A = aInterp.Interp(pix.A)
-			pix.B = bInterp.Interp(pix.B)
-			image.SetCIELab(x, y, pix)
+// Record that a tile has been added at destRect, with its registered
+// content covering coverRect.
+func (comp *Compositor) recordArea(destRect, coverRect image.Rectangle) {
+	rect := destRect.Intersect(comp.Bounds)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			// Pixels outside the registered content are at its edge.
+			d := 0
+			if (image.Point{x, y}).In(coverRect) {
+				d = comp.edgeDistance(x, y, coverRect)
+			}
+			if d > comp.coveredEdgeDistance(x, y) {
+				comp.state.SetValue(x, y, stateEdgeDistance, float64(d+1))
+			}
+		}
+	}
+	comp.addedAreas = append(comp.addedAreas, destRect)
+}
+
+// Get the largest distance from a pixel to the nearest edge of any previously
+// added tile that covers it, or -1 if no such tile covers the pixel.
+func (comp *Compositor) coveredEdgeDistance(x, y int) int {
+	return int(comp.state.Value(x, y, stateEdgeDistance)) - 1
+}
+
+// Blend a tile using Laplacian pyramids.  Only the region under the new
+// tile is decomposed, so memory use is proportional to tile size rather
+// than composite size.
+func (comp *Compositor) blendMultiBand(tile *lib_image.CIELab, destRect image.Rectangle) {
+	tileOffset := destRect.Min.Sub(tile.Bounds().Min)
+	rect := destRect.Intersect(comp.Bounds)
+	if rect.Empty() {
+		return
+	}
+	width := rect.Dx()
+	height := rect.Dy()
+
+	// The mask is 1 wherever the new tile should supply the pixel: where
+	// nothing has been composited yet, and where the pixel is farther from
+	// the new tile's edges than from those of the tiles already covering it.
+	mask := newFloatPlane(width, height)
+	anyCovered := false
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			m := 1.0
+			if comp.weight(x, y) > 0.0 {
+				anyCovered = true
+				if comp.edgeDistance(x, y, destRect) <= comp.coveredEdgeDistance(x, y) {
+					m = 0.0
+				}
+			}
+			mask.set(x-rect.Min.X, y-rect.Min.Y, m)
+		}
+	}
+
+	levels := pyramidLevels(width, height, comp.PyramidLevels)
+	maskPyramid := gaussianPyramid(mask, levels)
+
+	// Process one Lab channel at a time, to limit memory use.
+	for channel := 0; channel < 3; channel++ {
+		prev := newFloatPlane(width, height)
+		curr := newFloatPlane(width, height)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				tileValue := tile.Pix[tile.PixOffset(x-tileOffset.X, y-tileOffset.Y)+channel]
+				prevValue := tileValue
+				if anyCovered && (comp.weight(x, y) > 0.0) {
+					prevValue = comp.Result.Channel(x, y, channel)
+				}
+				// Where nothing has been composited, prev matches the new
+				// tile, so that empty areas don't bleed into the result.
+				prev.set(x-rect.Min.X, y-rect.Min.Y, prevValue)
+				curr.set(x-rect.Min.X, y-rect.Min.Y, tileValue)
+			}
+		}
+
+		prevPyramid := laplacianPyramid(prev, levels)
+		blendedPyramid := laplacianPyramid(curr, levels)
+		for level, blended := range blendedPyramid {
+			levelMask := maskPyramid[level]
+			prevLevel := prevPyramid[level]
+			for i, m := range levelMask.pix {
+				blended.pix[i] = m*blended.pix[i] + (1.0-m)*prevLevel.pix[i]
+			}
+		}
+
+		result := collapsePyramid(blendedPyramid)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				comp.Result.SetChannel(x, y, channel, result.at(x-rect.Min.X, y-rect.Min.Y))
+			}
+		}
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			comp.setWeight(x, y, 1.0)
+			if mask.at(x-rect.Min.X, y-rect.Min.Y) >= 0.5 {
+				comp.setSource(x, y)
+			}
 		}
 	}
 }
@@ -96,37 +564,60 @@ type LabBounds struct {
 	Max lib_color.CIELab
 }
 
+// Compress the dynamic range of covered pixels to fit the sRGB gamut.
 func (comp *Compositor) CompressDynamicRange() {
-	compressAsNeeded(getDynamicRange(comp.Result), comp.Result)
+	imageRange := getDynamicRange(comp.Result, comp.Covered, comp.Workers)
+	compressAsNeeded(imageRange, comp.Result, comp.Covered, comp.Workers)
 }
 
-func getDynamicRange(image *lib_image.CIELab) LabBounds {
+// Get the dynamic range of the pixels for which include returns true.
+// A nil include function includes all pixels.
+func getDynamicRange(img lib_image.LabImage, include func(x, y int) bool, workers int) LabBounds {
 	min := lib_color.CIELab{L: 100.0, A: 127.0, B: 127.0}
 	max := lib_color.CIELab{L: 0.0, A: -128.0, B: -128.0}
 
-	for y := image.Bounds().Min.Y; y < image.Bounds().Max.Y; y++ {
-		for x := image.Bounds().Min.X; x < image.Bounds().Max.X; x++ {
-			pix := image.CIELabAt(x, y)
+	bandMins := make([]lib_color.CIELab, lib_image.NumWorkers(workers))
+	bandMaxes := make([]lib_color.CIELab, len(bandMins))
+	for i := range bandMins {
+		bandMins[i] = min
+		bandMaxes[i] = max
+	}
+	lib_image.ForEachImagePart(img, workers, func(i int, band image.Rectangle) {
+		bandMin := &bandMins[i]
+		bandMax := &bandMaxes[i]
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				if (include != nil) && !include(x, y) {
+					continue
+				}
+				pix := img.CIELabAt(x, y)
 
-			if pix.A > max.A {
-				max.A = pix.A
-			}
-			if pix.A < min.A {
-				min.A = pix.A
-			}
+				if pix.A > bandMax.A {
+					bandMax.A = pix.A
+				}
+				if pix.A < bandMin.A {
+					bandMin.A = pix.A
+				}
 
-			if pix.B > max.B {
-				max.B = pix.B
-			}
-			if pix.B < min.B {
-				min.B = pix.B
+				if pix.B > bandMax.B {
+					bandMax.B = pix.B
+				}
+				if pix.B < bandMin.B {
+					bandMin.B = pix.B
+				}
 			}
 		}
+	})
+	for i := range bandMins {
+		min.A = math.Min(min.A, bandMins[i].A)
+		min.B = math.Min(min.B, bandMins[i].B)
+		max.A = math.Max(max.A, bandMaxes[i].A)
+		max.B = math.Max(max.B, bandMaxes[i].B)
 	}
 
 	// Special case for Lab L:  Adjust exposure so that some large fraction
 	// of pixels are within gamut.
-	exposure := NewImageExposure(image)
+	exposure := newMaskedImageExposure(img, include, workers)
 	min.L = exposure.cdf(0.0)
 	max.L = exposure.cdf(0.95)
 	return LabBounds{Min: min, Max: max}
@@ -176,7 +667,7 @@ func (s *scaler) isIdentity() bool {
 // NOTE that this naive implementation is sensitive to outliers.
 // Probably better to use a method, for L channel at least,
 // that ensures some fraction f of pixels are unclipped.
-func compressAsNeeded(imageRange LabBounds, image *lib_image.CIELab) {
+func compressAsNeeded(imageRange LabBounds, image lib_image.LabImage, include func(x, y int) bool, workers int) {
 	// {'labL': [0.0, 99.99998453333127], 'laba': [-86.1829494051608, 98.23532017664644], 'labb': [-107.86546414496824, 94.47731817969378]}
 	minRGB := lib_color.CIELab{L: 0.0, A: -86.0, B: -107.0}
 	maxRGB := lib_color.CIELab{L: 100.0, A: 98.0, B: 94.0}
@@ -203,19 +694,25 @@ func compressAsNeeded(imageRange LabBounds, image *lib_image.CIELab) {
 	if !bScaler.isIdentity() {
 		scalers = append(scalers, bScaler)
 	}
-	compressChannels(image, scalers)
+	compressChannels(image, scalers, include, workers)
 }
 
-func compressChannels(image *lib_image.CIELab, scalers []scaler) {
-	if len(scalers) > 0 {
-		for y := image.Bounds().Min.Y; y < image.Bounds().Max.Y; y++ {
-			for x := image.Bounds().Min.X; x < image.Bounds().Max.X; x++ {
-				pix := image.CIELabAt(x, y)
+func compressChannels(img lib_image.LabImage, scalers []scaler, include func(x, y int) bool, workers int) {
+	if len(scalers) <= 0 {
+		return
+	}
+	lib_image.ForEachImagePart(img, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				if (include != nil) && !include(x, y) {
+					continue
+				}
+				pix := img.CIELabAt(x, y)
 				for _, s := range scalers {
 					s.UpdatePix(&pix)
 				}
-				image.SetCIELab(x, y, pix)
+				img.SetCIELab(x, y, pix)
 			}
 		}
-	}
+	})
 }
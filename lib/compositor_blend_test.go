@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+func uniformLabTile(rect image.Rectangle, labL float64) *lib_image.CIELab {
+	result := lib_image.NewCIELab(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			result.SetCIELab(x, y, lib_color.CIELab{L: labL, A: 0.0, B: 0.0})
+		}
+	}
+	return result
+}
+
+// Blend two uniform tiles, side by side, that overlap by 16 pixels.
+// Return the composite's L values along its middle row.
+func blendTwoTiles(mode BlendMode, featherWidth int) []float64 {
+	return blendOverlappingTiles(mode, featherWidth, 16)
+}
+
+// Blend two uniform 64-pixel-wide tiles, side by side, that overlap by
+// overlap pixels.  Return the composite's L values along its middle row.
+func blendOverlappingTiles(mode BlendMode, featherWidth int, overlap int) []float64 {
+	leftRect := image.Rect(0, 0, 64, 32)
+	rightRect := image.Rect(64-overlap, 0, 128-overlap, 32)
+	comp := NewCompositor(leftRect.Union(rightRect))
+	comp.BlendMode = mode
+	comp.FeatherWidth = featherWidth
+
+	// Bypass color matching, so tiles keep their distinct values.
+	comp.blend(uniformLabTile(image.Rect(0, 0, 64, 32), 20.0), leftRect)
+	comp.recordArea(leftRect, leftRect)
+	comp.blend(uniformLabTile(image.Rect(0, 0, 64, 32), 80.0), rightRect)
+	comp.recordArea(rightRect, rightRect)
+
+	result := []float64{}
+	for x := comp.Bounds.Min.X; x < comp.Bounds.Max.X; x++ {
+		result = append(result, comp.Result.CIELabAt(x, 16).L)
+	}
+	return result
+}
+
+func TestBlendOverwrite(t *testing.T) {
+	row := blendTwoTiles(BlendOverwrite, 0)
+	for x, labL := range row {
+		want := 20.0
+		if x >= 48 {
+			want = 80.0
+		}
+		if labL != want {
+			t.Fatalf("x = %v: want L %v, got %v", x, want, labL)
+		}
+	}
+}
+
+func TestBlendFeather(t *testing.T) {
+	row := blendTwoTiles(BlendFeather, 16)
+	if row[0] != 20.0 || row[len(row)-1] != 80.0 {
+		t.Errorf("Expected unblended ends, got %v ... %v", row[0], row[len(row)-1])
+	}
+	// Across the overlap, values should ramp monotonically from the left
+	// tile's value to the right tile's value.
+	for x := 48; x < 64; x++ {
+		if row[x] < row[x-1] {
+			t.Errorf("Feathered values are not monotonic at x = %v: %v", x, row[40:70])
+			break
+		}
+	}
+	if !(row[48] > 20.0 && row[48] < 30.0) {
+		t.Errorf("Expected feathering to start near the left tile's value; got %v", row[48])
+	}
+	if row[63] != 80.0 {
+		t.Errorf("Expected the right tile to be opaque %v pixels from its edge; got %v", 16, row[63])
+	}
+}
+
+// Feathering must finish within an overlap narrower than the feather
+// width, rather than leaving a seam where the overlap ends.
+func TestBlendFeatherNarrowOverlap(t *testing.T) {
+	overlap := 8
+	row := blendOverlappingTiles(BlendFeather, 32, overlap)
+	// Each step across the overlap should be a fraction of the difference
+	// between the tiles.
+	maxStep := (80.0 - 20.0) / float64(overlap)
+	for x := 1; x < len(row); x++ {
+		step := row[x] - row[x-1]
+		if (step < 0.0) || (step > maxStep+1.0e-9) {
+			t.Fatalf("Expected a gradual ramp, got %v -> %v at x = %v: %v", row[x-1], row[x], x, row[50:70])
+		}
+	}
+	if row[63] != 80.0 {
+		t.Errorf("Expected the right tile to be opaque where the overlap ends; got %v", row[63])
+	}
+}
+
+func TestBlendDistanceWeighted(t *testing.T) {
+	row := blendTwoTiles(BlendDistanceWeighted, 0)
+	for x := 48; x < 64; x++ {
+		if row[x] < row[x-1] {
+			t.Errorf("Distance-weighted values are not monotonic at x = %v: %v", x, row[40:70])
+			break
+		}
+	}
+	// The middle of the overlap is equidistant from both tiles' inner edges.
+	mid := (row[55] + row[56]) / 2.0
+	if math.Abs(mid-50.0) > 2.0 {
+		t.Errorf("Expected the middle of the overlap to be about 50, got %v", mid)
+	}
+}
+
+func TestBlendMultiBand(t *testing.T) {
+	row := blendTwoTiles(BlendMultiBand, 0)
+	if math.Abs(row[0]-20.0) > 0.5 || math.Abs(row[len(row)-1]-80.0) > 0.5 {
+		t.Errorf("Expected ends near the original tile values, got %v ... %v", row[0], row[len(row)-1])
+	}
+	for x := 1; x < len(row); x++ {
+		if row[x] < row[x-1]-1.0e-6 {
+			t.Errorf("Multi-band values are not monotonic at x = %v: %v", x, row)
+			break
+		}
+	}
+	// The transition should be smooth: no single-pixel jump from one tile's
+	// value to the other's.
+	for x := 1; x < len(row); x++ {
+		if row[x]-row[x-1] > 30.0 {
+			t.Errorf("Abrupt seam at x = %v: %v -> %v", x, row[x-1], row[x])
+		}
+	}
+}
+
+func TestBlendMultiBandIdenticalTiles(t *testing.T) {
+	leftRect := image.Rect(0, 0, 40, 40)
+	rightRect := image.Rect(24, 8, 64, 48)
+	comp := NewCompositor(leftRect.Union(rightRect))
+	comp.BlendMode = BlendMultiBand
+	comp.blend(uniformLabTile(image.Rect(0, 0, 40, 40), 55.0), leftRect)
+	comp.recordArea(leftRect, leftRect)
+	comp.blend(uniformLabTile(image.Rect(0, 0, 40, 40), 55.0), rightRect)
+
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			p := image.Point{x, y}
+			if p.In(leftRect) || p.In(rightRect) {
+				if got := comp.Result.CIELabAt(x, y).L; math.Abs(got-55.0) > 1.0e-6 {
+					t.Fatalf("Expected blending identical tiles to be lossless; got L %v at %v", got, p)
+				}
+			}
+		}
+	}
+}
+
+func TestParseBlendMode(t *testing.T) {
+	for _, mode := range []BlendMode{BlendOverwrite, BlendFeather, BlendDistanceWeighted, BlendMultiBand, BlendSeam} {
+		got, err := ParseBlendMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf("ParseBlendMode(%q): want %v, got %v (%v)", mode.String(), mode, got, err)
+		}
+	}
+	if _, err := ParseBlendMode("no such mode"); err == nil {
+		t.Error("Expected an error for an unknown blend mode.")
+	}
+}
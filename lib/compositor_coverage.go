@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+)
+
+// Has any tile supplied the pixel at x, y?
+func (comp *Compositor) Covered(x, y int) bool {
+	if !(image.Point{x, y}).In(comp.Bounds) {
+		return false
+	}
+	return comp.weight(x, y) > 0.0
+}
+
+// Get a mask that is opaque wherever a tile has supplied a pixel.
+func (comp *Compositor) CoverageMask() *image.Alpha {
+	result := image.NewAlpha(comp.Bounds)
+	for y := comp.Bounds.Min.Y; y < comp.Bounds.Max.Y; y++ {
+		for x := comp.Bounds.Min.X; x < comp.Bounds.Max.X; x++ {
+			if comp.Covered(x, y) {
+				result.SetAlpha(x, y, color.Alpha{0xff})
+			}
+		}
+	}
+	return result
+}
+
+// Get the composite as an sRGB image that is transparent wherever no tile
+// supplied a pixel.
+func (comp *Compositor) NRGBA() *image.NRGBA {
+	view := nrgbaView{comp}
+	result := image.NewNRGBA(comp.Bounds)
+	for y := comp.Bounds.Min.Y; y < comp.Bounds.Max.Y; y++ {
+		for x := comp.Bounds.Min.X; x < comp.Bounds.Max.X; x++ {
+			result.SetNRGBA(x, y, view.nrgbaAt(x, y))
+		}
+	}
+	return result
+}
+
+// Get a view of the composite that looks like NRGBA's result, but whose
+// pixels are computed on demand.  Encoders can stream it, without holding
+// the whole image in memory.
+func (comp *Compositor) NRGBAView() image.Image {
+	return nrgbaView{comp}
+}
+
+type nrgbaView struct {
+	comp *Compositor
+}
+
+func (v nrgbaView) ColorModel() color.Model { return color.NRGBAModel }
+
+func (v nrgbaView) Bounds() image.Rectangle { return v.comp.Bounds }
+
+func (v nrgbaView) At(x, y int) color.Color { return v.nrgbaAt(x, y) }
+
+func (v nrgbaView) nrgbaAt(x, y int) color.NRGBA {
+	if !v.comp.Covered(x, y) {
+		return color.NRGBA{}
+	}
+	r, g, b, _ := v.comp.Result.CIELabAt(x, y).RGBA()
+	return color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+}
+
+// Get a map of which tile supplied each pixel.  Each pixel's value is
+// 1 + the index of the tile, in the order tiles were added, or zero if no
+// tile supplied the pixel.
+func (comp *Compositor) TileIndexMap() *image.Gray16 {
+	result := image.NewGray16(comp.Bounds)
+	for y := comp.Bounds.Min.Y; y < comp.Bounds.Max.Y; y++ {
+		for x := comp.Bounds.Min.X; x < comp.Bounds.Max.X; x++ {
+			if comp.weight(x, y) > 0.0 {
+				result.SetGray16(x, y, color.Gray16{uint16(comp.source(x, y))})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Composite two uniform tiles that touch only at a corner, leaving the
+// other two quadrants uncovered.  A third tile overlaps the first.
+func diagonalComposite(mode BlendMode) Compositor {
+	rects := []image.Rectangle{
+		image.Rect(0, 0, 32, 32),
+		image.Rect(32, 32, 64, 64),
+		image.Rect(16, 0, 32, 16),
+	}
+	comp := NewCompositor(image.Rect(0, 0, 64, 64))
+	comp.BlendMode = mode
+	for i, rect := range rects {
+		tile := uniformLabTile(image.Rect(0, 0, rect.Dx(), rect.Dy()), 40.0+10.0*float64(i))
+		comp.blend(tile, rect)
+		comp.recordArea(rect, rect)
+	}
+	return comp
+}
+
+func TestCoverageMask(t *testing.T) {
+	comp := diagonalComposite(BlendOverwrite)
+	mask := comp.CoverageMask()
+	rgba := comp.NRGBA()
+	tests := []struct {
+		pt      image.Point
+		covered bool
+	}{
+		{image.Pt(8, 8), true},
+		{image.Pt(40, 40), true},
+		{image.Pt(40, 8), false},
+		{image.Pt(8, 40), false},
+	}
+	for _, test := range tests {
+		x, y := test.pt.X, test.pt.Y
+		if comp.Covered(x, y) != test.covered {
+			t.Errorf("Expected Covered(%v) = %v", test.pt, test.covered)
+		}
+		wantAlpha := uint8(0)
+		if test.covered {
+			wantAlpha = 0xff
+		}
+		if got := mask.AlphaAt(x, y).A; got != wantAlpha {
+			t.Errorf("Expected mask alpha %v at %v, got %v", wantAlpha, test.pt, got)
+		}
+		if got := rgba.NRGBAAt(x, y).A; got != wantAlpha {
+			t.Errorf("Expected NRGBA alpha %v at %v, got %v", wantAlpha, test.pt, got)
+		}
+	}
+	if comp.Covered(-1, 0) || comp.Covered(64, 0) {
+		t.Error("Expected pixels outside the composite to be uncovered")
+	}
+}
+
+func TestTileIndexMap(t *testing.T) {
+	for _, mode := range []BlendMode{BlendOverwrite, BlendFeather, BlendDistanceWeighted, BlendMultiBand, BlendSeam} {
+		comp := diagonalComposite(mode)
+		indices := comp.TileIndexMap()
+		tests := []struct {
+			pt   image.Point
+			want uint16
+		}{
+			{image.Pt(4, 28), 1},
+			{image.Pt(40, 40), 2},
+			{image.Pt(40, 8), 0},
+		}
+		for _, test := range tests {
+			if got := indices.Gray16At(test.pt.X, test.pt.Y).Y; got != test.want {
+				t.Errorf("%v: expected tile index %v at %v, got %v", mode, test.want, test.pt, got)
+			}
+		}
+	}
+
+	comp := diagonalComposite(BlendOverwrite)
+	if got := comp.TileIndexMap().Gray16At(24, 8).Y; got != 3 {
+		t.Errorf("Expected the overwriting tile's index, got %v", got)
+	}
+}
+
+func TestDynamicRangeSkipsUncovered(t *testing.T) {
+	comp := diagonalComposite(BlendOverwrite)
+	covered := getDynamicRange(comp.Result, comp.Covered, 0)
+	if math.Abs(covered.Min.L-40.0) > 0.01 {
+		t.Errorf("Expected covered minimum L of 40, got %v", covered.Min.L)
+	}
+	all := getDynamicRange(comp.Result, nil, 0)
+	if all.Min.L > 0.01 {
+		t.Errorf("Expected minimum L of 0 including uncovered pixels, got %v", all.Min.L)
+	}
+
+	comp.Result.SetCIELab(40, 8, lib_color.CIELab{L: 0.0, A: 0.0, B: 0.0})
+	comp.CompressDynamicRange()
+	if got := comp.Result.CIELabAt(40, 8); got != (lib_color.CIELab{}) {
+		t.Errorf("Expected uncovered pixels to be left alone, got %v", got)
+	}
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+)
+
+// Hide an image's concrete type, to force generic conversion.
+type opaqueImage struct {
+	image.Image
+}
+
+func randomRGBA(rect image.Rectangle, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	result := image.NewRGBA(rect)
+	rng.Read(result.Pix)
+	for i := 3; i < len(result.Pix); i += 4 {
+		result.Pix[i] = 0xff
+	}
+	return result
+}
+
+func randomNRGBA(rect image.Rectangle, seed int64) *image.NRGBA {
+	rng := rand.New(rand.NewSource(seed))
+	result := image.NewNRGBA(rect)
+	rng.Read(result.Pix)
+	return result
+}
+
+func TestCIELabFromImageFastPaths(t *testing.T) {
+	rect := image.Rect(3, 5, 67, 53)
+	for _, src := range []image.Image{randomRGBA(rect, 1), randomNRGBA(rect, 2)} {
+		want := lib_image.CIELabFromImageParallel(opaqueImage{src}, 1)
+		for _, workers := range []int{1, 3, 0} {
+			got := lib_image.CIELabFromImageParallel(src, workers)
+			if !got.Bounds().Eq(rect) {
+				t.Fatalf("Expected bounds %v, got %v", rect, got.Bounds())
+			}
+			for i, v := range want.Pix {
+				if got.Pix[i] != v {
+					t.Fatalf("%T, %v workers: fast path differs at %v: %v vs. %v", src, workers, i, got.Pix[i], v)
+				}
+			}
+		}
+	}
+}
+
+var texturedLeftRect = image.Rect(0, 0, 96, 64)
+var texturedRightRect = image.Rect(64, 16, 160, 80)
+
+// Composite two overlapping, textured tiles.
+func compositeTexturedTiles(workers int) *Compositor {
+	comp := NewCompositor(texturedLeftRect.Union(texturedRightRect))
+	comp.Workers = workers
+	addTexturedTiles(&comp)
+	return &comp
+}
+
+func addTexturedTiles(comp *Compositor) {
+	leftRect := texturedLeftRect
+	rightRect := texturedRightRect
+	comp.AddImage(randomRGBA(image.Rect(0, 0, 96, 64), 3), leftRect)
+	right := randomRGBA(image.Rect(0, 0, 96, 64), 4)
+	for i := 0; i < len(right.Pix); i += 4 {
+		// Make the right tile brighter, so that it needs adjustment.
+		right.Pix[i] = uint8(math.Min(255.0, float64(right.Pix[i])*1.2+10.0))
+	}
+	comp.AddImage(right, rightRect)
+	comp.CompressDynamicRange()
+}
+
+func TestParallelCompositingMatchesSerial(t *testing.T) {
+	serial := compositeTexturedTiles(1)
+	for _, workers := range []int{2, 5, 0} {
+		parallel := compositeTexturedTiles(workers)
+		rect := serial.Bounds
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				want := serial.Result.CIELabAt(x, y)
+				got := parallel.Result.CIELabAt(x, y)
+				if (math.Abs(got.L-want.L) > 1.0e-9) || (math.Abs(got.A-want.A) > 1.0e-9) || (math.Abs(got.B-want.B) > 1.0e-9) {
+					t.Fatalf("%v workers: result differs at (%v, %v): %v vs. %v", workers, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRowBands(t *testing.T) {
+	rect := image.Rect(0, 10, 8, 17)
+	for _, n := range []int{1, 2, 3, 7, 20} {
+		bands := lib_image.RowBands(rect, n)
+		if (len(bands) > n) || (len(bands) > rect.Dy()) {
+			t.Errorf("Expected at most %v bands, got %v", n, len(bands))
+		}
+		y := rect.Min.Y
+		for _, band := range bands {
+			if (band.Min.Y != y) || (band.Min.X != rect.Min.X) || (band.Max.X != rect.Max.X) || band.Empty() {
+				t.Errorf("Unexpected band %v of %v in %v", band, bands, rect)
+			}
+			y = band.Max.Y
+		}
+		if y != rect.Max.Y {
+			t.Errorf("Expected bands to cover %v, got %v", rect, bands)
+		}
+	}
+}
+
+func benchmarkWorkerCounts() []int {
+	result := []int{1}
+	if runtime.NumCPU() > 1 {
+		result = append(result, runtime.NumCPU())
+	}
+	return result
+}
+
+func BenchmarkCIELabFromImage(b *testing.B) {
+	src := randomRGBA(image.Rect(0, 0, 1280, 960), 5)
+	b.Run("generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lib_image.CIELabFromImageParallel(opaqueImage{src}, 1)
+		}
+	})
+	for _, workers := range benchmarkWorkerCounts() {
+		b.Run(fmt.Sprintf("rgba-workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lib_image.CIELabFromImageParallel(src, workers)
+			}
+		})
+	}
+}
+
+func BenchmarkAddImage(b *testing.B) {
+	leftRect := image.Rect(0, 0, 1280, 960)
+	rightRect := image.Rect(1024, 0, 2304, 960)
+	left := randomRGBA(image.Rect(0, 0, 1280, 960), 6)
+	right := randomRGBA(image.Rect(0, 0, 1280, 960), 7)
+	for _, workers := range benchmarkWorkerCounts() {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				comp := NewCompositor(leftRect.Union(rightRect))
+				comp.Workers = workers
+				comp.AddImage(left, leftRect)
+				comp.AddImage(right, rightRect)
+			}
+		})
+	}
+}
+
+func BenchmarkCompressDynamicRange(b *testing.B) {
+	rect := image.Rect(0, 0, 1280, 960)
+	src := randomRGBA(rect, 8)
+	for _, workers := range benchmarkWorkerCounts() {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			comp := NewCompositor(rect)
+			comp.Workers = workers
+			comp.AddImage(src, rect)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				comp.CompressDynamicRange()
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+)
+
+func TestBlockStorePaging(t *testing.T) {
+	rect := image.Rect(-5, 3, 70, 50)
+	dir := t.TempDir()
+	// Room for only 7 of 25 blocks; the minimum for 5 blocks across is 6.
+	blockBytes := int64(16 * 16 * 2 * 8)
+	store, err := lib_image.NewBlockStore(rect, 2, lib_image.StorageOptions{
+		BlockSize: 16, MemoryBudget: 7 * blockBytes, ScratchDir: dir,
+	})
+	if err != nil {
+		t.Fatal("Could not create block store:", err)
+	}
+	if !store.Paged() {
+		t.Fatal("Expected store to be paged")
+	}
+
+	value := func(x, y, c int) float64 {
+		return float64(x*1000+y) + 0.5*float64(c)
+	}
+	// Write by columns and read by rows, so that blocks are evicted and
+	// reloaded many times.
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			store.SetPixel(x, y, []float64{value(x, y, 0), value(x, y, 1)})
+		}
+	}
+	pix := make([]float64, 2)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			store.Pixel(x, y, pix)
+			if (pix[0] != value(x, y, 0)) || (store.Value(x, y, 1) != value(x, y, 1)) {
+				t.Fatalf("Expected %v, %v at (%v, %v), got %v", value(x, y, 0), value(x, y, 1), x, y, pix)
+			}
+		}
+	}
+	if got := store.Value(rect.Max.X, rect.Min.Y, 0); got != 0.0 {
+		t.Errorf("Expected zero outside the store, got %v", got)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal("Error closing store:", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected scratch file to be removed, found %v", entries)
+	}
+}
+
+func TestConcurrentBlockStorePaging(t *testing.T) {
+	rect := image.Rect(0, 0, 96, 64)
+	// Room for only 8 of 24 blocks, with 4 workers.
+	blockBytes := int64(16 * 16 * 8)
+	store, err := lib_image.NewBlockStore(rect, 1, lib_image.StorageOptions{
+		BlockSize: 16, MemoryBudget: 8 * blockBytes, ScratchDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal("Could not create block store:", err)
+	}
+	defer store.Close()
+
+	value := func(x, y int) float64 { return float64(x*1000 + y) }
+	blocks := lib_image.Blocks(rect, store.BlockSize())
+	lib_image.ForEachRect(blocks, 4, func(_ int, part image.Rectangle) {
+		for y := part.Min.Y; y < part.Max.Y; y++ {
+			for x := part.Min.X; x < part.Max.X; x++ {
+				store.SetValue(x, y, 0, value(x, y))
+			}
+		}
+	})
+	mismatches := make([]int, len(blocks))
+	lib_image.ForEachRect(blocks, 4, func(i int, part image.Rectangle) {
+		for y := part.Min.Y; y < part.Max.Y; y++ {
+			for x := part.Min.X; x < part.Max.X; x++ {
+				if store.Value(x, y, 0) != value(x, y) {
+					mismatches[i] += 1
+				}
+			}
+		}
+	})
+	for i, n := range mismatches {
+		if n != 0 {
+			t.Errorf("Expected block %v to read back as written, got %v mismatches", blocks[i], n)
+		}
+	}
+	if err := store.Err(); err != nil {
+		t.Error("Paging error:", err)
+	}
+}
+
+func TestUnpagedBlockStore(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 40)
+	store, err := lib_image.NewBlockStore(rect, 3, lib_image.StorageOptions{
+		BlockSize: 16, MemoryBudget: 1 << 30,
+	})
+	if err != nil {
+		t.Fatal("Could not create block store:", err)
+	}
+	if store.Paged() {
+		t.Error("Expected a store within budget not to be paged")
+	}
+	store.SetValue(39, 39, 2, 7.0)
+	if got := store.Value(39, 39, 2); got != 7.0 {
+		t.Errorf("Expected 7, got %v", got)
+	}
+}
+
+func TestPagedCompositingMatchesInMemory(t *testing.T) {
+	for _, mode := range []BlendMode{BlendFeather, BlendMultiBand, BlendSeam} {
+		want := NewCompositor(texturedLeftRect.Union(texturedRightRect))
+		want.BlendMode = mode
+		want.RegistrationRadius = 1
+		addTexturedTiles(&want)
+
+		// 160 x 80 pixels is 10 x 5 blocks.  Allow 12 blocks of Result,
+		// and 12 of the compositor's 4-channel state.
+		blockBytes := int64(16 * 16 * 8)
+		storage := lib_image.StorageOptions{BlockSize: 16, MemoryBudget: 12 * (3 + numStateChannels) * blockBytes, ScratchDir: t.TempDir()}
+		got, err := NewCompositorWithStorage(texturedLeftRect.Union(texturedRightRect), 1, storage)
+		if err != nil {
+			t.Fatal("Could not create paged compositor:", err)
+		}
+		got.BlendMode = mode
+		got.RegistrationRadius = 1
+		got.Workers = 3
+		addTexturedTiles(&got)
+		if err := got.Err(); err != nil {
+			t.Fatal("Paging error:", err)
+		}
+
+		rect := want.Bounds
+		gotTiles := got.TileIndexMap()
+		wantTiles := want.TileIndexMap()
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if g, w := got.Result.CIELabAt(x, y), want.Result.CIELabAt(x, y); g != w {
+					t.Fatalf("%v: paged result differs at (%v, %v): %v vs. %v", mode, x, y, g, w)
+				}
+				if g, w := gotTiles.Gray16At(x, y), wantTiles.Gray16At(x, y); g != w {
+					t.Fatalf("%v: paged tile index differs at (%v, %v): %v vs. %v", mode, x, y, g, w)
+				}
+			}
+		}
+		if err := got.Close(); err != nil {
+			t.Error("Error closing paged compositor:", err)
+		}
+	}
+}
+
+// Decode just enough of a TIFF written by WriteTIFF to get its pixels.
+func readTestTIFF(t *testing.T, data []byte) (width, height, samples int, pix []byte) {
+	order := binary.LittleEndian
+	if !bytes.HasPrefix(data, []byte{'I', 'I', 42, 0}) {
+		t.Fatalf("Bad TIFF header %v", data[:4])
+	}
+	ifd := data[order.Uint32(data[4:]):]
+	tags := map[uint16][]uint32{}
+	for i := 0; i < int(order.Uint16(ifd)); i++ {
+		entry := ifd[2+12*i:]
+		tag := order.Uint16(entry)
+		fieldType := order.Uint16(entry[2:])
+		count := int(order.Uint32(entry[4:]))
+		size := 4
+		if fieldType == tiffShort {
+			size = 2
+		}
+		value := entry[8:]
+		if size*count > 4 {
+			value = data[order.Uint32(entry[8:]):]
+		}
+		values := make([]uint32, count)
+		for j := range values {
+			if size == 2 {
+				values[j] = uint32(order.Uint16(value[2*j:]))
+			} else {
+				values[j] = order.Uint32(value[4*j:])
+			}
+		}
+		tags[tag] = values
+	}
+	width = int(tags[tagImageWidth][0])
+	height = int(tags[tagImageLength][0])
+	samples = int(tags[tagSamplesPerPixel][0])
+	for i, offset := range tags[tagStripOffsets] {
+		pix = append(pix, data[offset:offset+tags[tagStripByteCounts][i]]...)
+	}
+	return
+}
+
+func TestWriteTIFF(t *testing.T) {
+	rect := image.Rect(2, 3, 2+300, 3+40)
+	tests := []struct {
+		img     image.Image
+		samples int
+	}{
+		{randomRGBA(rect, 9), 3},
+		{randomNRGBA(rect, 10), 4},
+	}
+	for _, test := range tests {
+		buf := bytes.Buffer{}
+		if err := WriteTIFF(&buf, test.img); err != nil {
+			t.Fatal("Error writing TIFF:", err)
+		}
+		width, height, samples, pix := readTestTIFF(t, buf.Bytes())
+		if (width != rect.Dx()) || (height != rect.Dy()) || (samples != test.samples) {
+			t.Fatalf("%T: expected %v x %v x %v, got %v x %v x %v",
+				test.img, rect.Dx(), rect.Dy(), test.samples, width, height, samples)
+		}
+		if len(pix) != width*height*samples {
+			t.Fatalf("%T: expected %v bytes of pixels, got %v", test.img, width*height*samples, len(pix))
+		}
+		i := 0
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				want := color.NRGBAModel.Convert(test.img.At(x, y)).(color.NRGBA)
+				got := color.NRGBA{pix[i], pix[i+1], pix[i+2], 0xff}
+				if samples == 4 {
+					got.A = pix[i+3]
+				}
+				if got != want {
+					t.Fatalf("%T: expected %v at (%v, %v), got %v", test.img, want, x, y, got)
+				}
+				i += samples
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 	"image/png"
 	"log"
+	"math"
 	"os"
 	"sort"
 	"testing"
@@ -76,6 +77,9 @@ func TestTroublesomeTiles(t *testing.T) {
 		labTile := lib_image.CIELabFromImage(tile)
 		am := compositor.makeValueAdjustmentMap(labTile, imageRects[i])
 		printVAM(am, i)
+		if i > 0 {
+			checkTroublesomeAdjustments(am, i, t)
+		}
 		printTargLVals(&compositor, i, imageRects[i], t)
 		compositor.AddImage(tile, imageRects[i])
 	}
@@ -88,6 +92,33 @@ func TestTroublesomeTiles(t *testing.T) {
 	fmt.Println("Also run", pythonScript)
 }
 
+// Tiles that overlap earlier tiles should get monotone adjustments which
+// fit most of their samples closely.
+func checkTroublesomeAdjustments(am *AdjustmentMap, index int, t *testing.T) {
+	for _, cam := range []chanAdjustmentMap{am.L, am.A, am.B} {
+		xs, ys := sortedKnots(cam)
+		for i := 1; i < len(xs); i++ {
+			if ys[i] < ys[i-1] {
+				t.Errorf("Tile %d: expected a monotone mapping; %v -> %v but %v -> %v", index, xs[i-1], ys[i-1], xs[i], ys[i])
+				break
+			}
+		}
+	}
+
+	fit := am.LFit
+	if fit.Bins <= 0 {
+		t.Fatalf("Tile %d: expected L bins, got %+v", index, fit)
+	}
+	if fit.Rejected*2 >= fit.Bins {
+		t.Errorf("Tile %d: expected to retain most L bins, got %+v", index, fit)
+	}
+	// The tiles are neighboring frames of one exposure, so their
+	// overlaps should agree to within a couple of L units.
+	if math.IsNaN(fit.RMS) || fit.RMS > 2.0 || fit.MaxBinError > 2.0 {
+		t.Errorf("Tile %d: expected a close L fit, got %+v", index, fit)
+	}
+}
+
 func printTargLVals(comp *Compositor, index int, r image.Rectangle, t *testing.T) {
 	if len(comp.addedAreas) <= 0 {
 		return
@@ -157,6 +188,7 @@ func printVAM(am *AdjustmentMap, index int) {
 	pythonln("")
 	pythonln("import matplotlib.pyplot as plt")
 	pythonln("import numpy as np")
+	pythonf("# Tile %d L fit: %+v\n", index, am.LFit)
 	pythonf("adj_%d = np.array([", index)
 	sortedSrc := make([]float64, 0, len(am.L))
 	for src := range am.L {
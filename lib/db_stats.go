@@ -0,0 +1,308 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// DBStats summarizes the contents of an image database.
+type DBStats struct {
+	NumImages int
+
+	ByCamera      map[string]int
+	BySol         map[int]int
+	ByColorType   map[string]int
+	BySampleType  map[string]int
+	ByScaleFactor map[string]int
+
+	FirstSol int
+	LastSol  int
+	// Ranges of sols, between FirstSol and LastSol, that have no images.
+	SolGaps []SolRange
+
+	FirstDate string // YYYY-MM-DD, UTC
+	LastDate  string
+	// Ranges of UTC dates, between FirstDate and LastDate, that have no
+	// images.
+	DateGaps []DateRange
+
+	// Number of records for which a column's value is unknown ("UNK"
+	// in the RSS feed), keyed by column name.
+	UnknownFields map[string]int
+
+	// Counts of what the current grouping logic would produce, with the
+	// default query and grouping options of assemble_composite_images.
+	NumCompositeSets         int
+	NumRejectedCompositeSets int
+	NumStereoPairs           int
+}
+
+type SolRange struct {
+	First, Last int
+}
+
+type DateRange struct {
+	First, Last string
+}
+
+// Columns whose "UNK" values are stored as NULL.
+var unknowableColumns = []string{
+	"cam_pos_x",
+	"sol",
+	"ext_mast_azimuth",
+	"ext_mast_elevation",
+	"ext_sclk",
+	"ext_scale_factor",
+	"ext_x",
+	"ext_sf_left",
+	"ext_width",
+}
+
+// Summarize the contents of the database.
+func (idb *ImageDB) Stats() (DBStats, error) {
+	result := DBStats{
+		UnknownFields: map[string]int{},
+	}
+
+	row := idb.DB.QueryRow("SELECT COUNT(*) FROM Images")
+	if err := row.Scan(&result.NumImages); err != nil {
+		return result, err
+	}
+
+	var err error
+	if result.ByCamera, err = idb.countByString("cam_instrument"); err != nil {
+		return result, err
+	}
+	if result.ByColorType, err = idb.countByString("color_type"); err != nil {
+		return result, err
+	}
+	if result.BySampleType, err = idb.countByString("sample_type"); err != nil {
+		return result, err
+	}
+	if result.ByScaleFactor, err = idb.countByString("ext_scale_factor"); err != nil {
+		return result, err
+	}
+	if result.BySol, err = idb.countBySol(); err != nil {
+		return result, err
+	}
+	result.FirstSol, result.LastSol, result.SolGaps = solGaps(result.BySol)
+
+	dates, err := idb.imageDates()
+	if err != nil {
+		return result, err
+	}
+	result.FirstDate, result.LastDate, result.DateGaps = dateGaps(dates)
+
+	for _, col := range unknowableColumns {
+		count := 0
+		query := fmt.Sprintf("SELECT COUNT(*) FROM Images WHERE %v IS NULL", col)
+		if err = idb.DB.QueryRow(query).Scan(&count); err != nil {
+			return result, err
+		}
+		result.UnknownFields[col] = count
+	}
+	// Unknown attitudes are stored as empty tuples.
+	count := 0
+	if err = idb.DB.QueryRow("SELECT COUNT(*) FROM Images WHERE attitude = '[]'").Scan(&count); err != nil {
+		return result, err
+	}
+	result.UnknownFields["attitude"] = count
+
+	for _, camera := range idb.Cameras() {
+		imageSets, rejected, err := QueryGroupedImageSets(
+			idb, camera, DefaultAssemblyQuery(), DefaultAssemblyGroupingOptions())
+		if err != nil {
+			return result, err
+		}
+		result.NumCompositeSets += len(imageSets)
+		result.NumRejectedCompositeSets += len(rejected)
+	}
+	pairs, err := FindStereoPairs(idb)
+	if err != nil {
+		return result, err
+	}
+	result.NumStereoPairs = len(pairs)
+
+	return result, nil
+}
+
+// Count images by distinct values of a column.  NULL values are counted as
+// "UNK".
+func (idb *ImageDB) countByString(col string) (map[string]int, error) {
+	result := map[string]int{}
+	query := fmt.Sprintf("SELECT %v, COUNT(*) FROM Images GROUP BY %v", col, col)
+	rows, err := idb.DB.Query(query)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		value := sql.NullString{}
+		count := 0
+		if err = rows.Scan(&value, &count); err != nil {
+			return result, err
+		}
+		key := "UNK"
+		if value.Valid {
+			key = value.String
+		}
+		result[key] += count
+	}
+	return result, rows.Err()
+}
+
+func (idb *ImageDB) countBySol() (map[int]int, error) {
+	result := map[int]int{}
+	rows, err := idb.DB.Query("SELECT sol, COUNT(*) FROM Images WHERE sol NOT NULL GROUP BY sol")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sol := 0
+		count := 0
+		if err = rows.Scan(&sol, &count); err != nil {
+			return result, err
+		}
+		result[sol] = count
+	}
+	return result, rows.Err()
+}
+
+// Get the distinct UTC dates on which images were taken.
+func (idb *ImageDB) imageDates() ([]string, error) {
+	result := []string{}
+	rows, err := idb.DB.Query(
+		"SELECT DISTINCT substr(date_taken_utc, 1, 10) d FROM Images ORDER BY d")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		date := ""
+		if err = rows.Scan(&date); err != nil {
+			return result, err
+		}
+		result = append(result, date)
+	}
+	return result, rows.Err()
+}
+
+func solGaps(bySol map[int]int) (first, last int, gaps []SolRange) {
+	gaps = []SolRange{}
+	sols := make([]int, 0, len(bySol))
+	for sol := range bySol {
+		sols = append(sols, sol)
+	}
+	if len(sols) <= 0 {
+		return
+	}
+	sort.Ints(sols)
+	first = sols[0]
+	last = sols[len(sols)-1]
+	for i := 1; i < len(sols); i++ {
+		if sols[i] > sols[i-1]+1 {
+			gaps = append(gaps, SolRange{sols[i-1] + 1, sols[i] - 1})
+		}
+	}
+	return
+}
+
+const dateLayout = "2006-01-02"
+
+// dates must be sorted.  Dates that can't be parsed are ignored.
+func dateGaps(dates []string) (first, last string, gaps []DateRange) {
+	gaps = []DateRange{}
+	var prev time.Time
+	havePrev := false
+	for _, date := range dates {
+		curr, err := time.Parse(dateLayout, date)
+		if err != nil {
+			continue
+		}
+		if !havePrev {
+			first = date
+		} else {
+			gapStart := prev.AddDate(0, 0, 1)
+			if curr.After(gapStart) {
+				gapEnd := curr.AddDate(0, 0, -1)
+				gaps = append(gaps, DateRange{gapStart.Format(dateLayout), gapEnd.Format(dateLayout)})
+			}
+		}
+		last = date
+		prev = curr
+		havePrev = true
+	}
+	return
+}
+
+func sortedKeys(counts map[string]int) []string {
+	result := make([]string, 0, len(counts))
+	for k := range counts {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func writeCounts(w io.Writer, title string, counts map[string]int) {
+	fmt.Fprintf(w, "%v:\n", title)
+	for _, k := range sortedKeys(counts) {
+		fmt.Fprintf(w, "  %-24v %8d\n", k, counts[k])
+	}
+}
+
+// Write a human-readable report.
+func (stats DBStats) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Images: %d\n", stats.NumImages)
+	writeCounts(w, "By camera", stats.ByCamera)
+	writeCounts(w, "By color type", stats.ByColorType)
+	writeCounts(w, "By sample type", stats.BySampleType)
+	writeCounts(w, "By scale factor", stats.ByScaleFactor)
+
+	fmt.Fprintln(w, "By sol:")
+	sols := make([]int, 0, len(stats.BySol))
+	for sol := range stats.BySol {
+		sols = append(sols, sol)
+	}
+	sort.Ints(sols)
+	for _, sol := range sols {
+		fmt.Fprintf(w, "  %-24d %8d\n", sol, stats.BySol[sol])
+	}
+
+	fmt.Fprintf(w, "Sols %d - %d, gaps:", stats.FirstSol, stats.LastSol)
+	if len(stats.SolGaps) <= 0 {
+		fmt.Fprint(w, " none")
+	}
+	for _, gap := range stats.SolGaps {
+		if gap.First == gap.Last {
+			fmt.Fprintf(w, " %d", gap.First)
+		} else {
+			fmt.Fprintf(w, " %d-%d", gap.First, gap.Last)
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "Dates %v - %v, gaps:", stats.FirstDate, stats.LastDate)
+	if len(stats.DateGaps) <= 0 {
+		fmt.Fprint(w, " none")
+	}
+	for _, gap := range stats.DateGaps {
+		if gap.First == gap.Last {
+			fmt.Fprintf(w, " %v", gap.First)
+		} else {
+			fmt.Fprintf(w, " %v..%v", gap.First, gap.Last)
+		}
+	}
+	fmt.Fprintln(w)
+
+	writeCounts(w, "Unknown (UNK) values", stats.UnknownFields)
+	fmt.Fprintf(w, "Composite image sets: %d (%d rejected)\n", stats.NumCompositeSets, stats.NumRejectedCompositeSets)
+	fmt.Fprintf(w, "Stereo pairs: %d\n", stats.NumStereoPairs)
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	idb := recreateInMemDB(t)
+	stats, err := idb.Stats()
+	if err != nil {
+		t.Fatal("Error computing stats:", err)
+	}
+
+	if stats.NumImages != 101 {
+		t.Errorf("Expected 101 images, got %v", stats.NumImages)
+	}
+
+	total := 0
+	for _, count := range stats.ByCamera {
+		total += count
+	}
+	if total != stats.NumImages {
+		t.Errorf("Camera counts %v do not sum to %v", stats.ByCamera, stats.NumImages)
+	}
+
+	if (stats.FirstSol != 23) || (stats.LastSol != 24) || (len(stats.SolGaps) != 0) {
+		t.Errorf("Unexpected sol coverage %v - %v, gaps %v", stats.FirstSol, stats.LastSol, stats.SolGaps)
+	}
+	if stats.UnknownFields["ext_x"] != 80 {
+		t.Errorf("Expected 80 records with unknown XYZ, got %v", stats.UnknownFields["ext_x"])
+	}
+	if stats.NumCompositeSets != 1 {
+		t.Errorf("Expected 1 composite image set, got %v", stats.NumCompositeSets)
+	}
+	if stats.NumRejectedCompositeSets != 1 {
+		t.Errorf("Expected 1 rejected composite image set, got %v", stats.NumRejectedCompositeSets)
+	}
+
+	var buf bytes.Buffer
+	stats.WriteText(&buf)
+	if !strings.Contains(buf.String(), "Composite image sets: 1 (1 rejected)") {
+		t.Errorf("Unexpected text report:\n%v", buf.String())
+	}
+}
+
+func TestStatsCountsUnknownSols(t *testing.T) {
+	idb := recreateInMemDB(t)
+	records, err := idb.Query(ImageQuery{})
+	if err != nil || len(records) == 0 {
+		t.Fatal("Error retrieving records:", err)
+	}
+	record := records[0]
+	record.ImageID = "NLF_UNKNOWN_SOL"
+	if err := json.Unmarshal([]byte(`"UNK"`), &record.Sol); err != nil {
+		t.Fatal("Error unmarshaling sol:", err)
+	}
+	if err := idb.AddOrUpdate([]ImageInfo{record}); err != nil {
+		t.Fatal("Error adding record:", err)
+	}
+
+	stats, err := idb.Stats()
+	if err != nil {
+		t.Fatal("Error computing stats:", err)
+	}
+	if stats.UnknownFields["sol"] != 1 {
+		t.Errorf("Expected 1 record with unknown sol, got %v", stats.UnknownFields["sol"])
+	}
+	if (stats.FirstSol != 23) || (stats.LastSol != 24) {
+		t.Errorf("Unexpected sol coverage %v - %v", stats.FirstSol, stats.LastSol)
+	}
+
+	got, err := idb.Image(record.ImageID)
+	if err != nil {
+		t.Fatal("Error retrieving record:", err)
+	}
+	if got.Sol != unknownSol {
+		t.Errorf("Expected unknown sol, got %v", got.Sol)
+	}
+}
+
+func TestSolGaps(t *testing.T) {
+	first, last, gaps := solGaps(map[int]int{3: 1, 4: 2, 7: 1, 9: 5})
+	if (first != 3) || (last != 9) {
+		t.Errorf("Expected sols 3 - 9, got %v - %v", first, last)
+	}
+	want := []SolRange{{5, 6}, {8, 8}}
+	if len(gaps) != len(want) {
+		t.Fatalf("Expected gaps %v, got %v", want, gaps)
+	}
+	for i := range want {
+		if gaps[i] != want[i] {
+			t.Errorf("Expected gaps %v, got %v", want, gaps)
+		}
+	}
+}
+
+func TestDateGaps(t *testing.T) {
+	dates := []string{"2021-02-27", "2021-02-28", "2021-03-03", "2021-03-05"}
+	first, last, gaps := dateGaps(dates)
+	if (first != "2021-02-27") || (last != "2021-03-05") {
+		t.Errorf("Unexpected date coverage %v - %v", first, last)
+	}
+	want := []DateRange{{"2021-03-01", "2021-03-02"}, {"2021-03-04", "2021-03-04"}}
+	if len(gaps) != len(want) {
+		t.Fatalf("Expected gaps %v, got %v", want, gaps)
+	}
+	for i := range want {
+		if gaps[i] != want[i] {
+			t.Errorf("Expected gaps %v, got %v", want, gaps)
+		}
+	}
+}
@@ -1,9 +1,8 @@
 package lib
 
 import (
-	"errors"
+	"fmt"
 	"image"
-	"image/color"
 )
 
 // Offsets of channels within RGBA pixels:
@@ -11,88 +10,78 @@ const r = 0
 const g = 1
 const b = 2
 
-// filter pattern, addressable as [y][x]
-var pattern = [][]int{
-	{r, g},
-	{g, b},
-}
+// CFAPattern names the filters of a sensor's 2x2 color filter array cell,
+// in the order top left, top right, bottom left, bottom right.
+type CFAPattern int
 
-// Simple-minded, I am.  Generate indices of "neighbors" of a value at a given index.
-func getIndices(index int, minIndex int, maxIndex int) []int {
-	result := []int{}
+const (
+	CFARGGB CFAPattern = iota
+	CFABGGR
+	CFAGRBG
+	CFAGBRG
+)
 
-	for currIndex := index - 1; currIndex <= (index + 1); currIndex++ {
-		if (minIndex <= currIndex) && (currIndex < maxIndex) {
-			result = append(result, currIndex)
-		}
-	}
-	return result
+var cfaPatternNames = map[CFAPattern]string{
+	CFARGGB: "RGGB",
+	CFABGGR: "BGGR",
+	CFAGRBG: "GRBG",
+	CFAGBRG: "GBRG",
 }
 
-// Given a bayer RGGB filter pattern overlaid on a sensor,
-// what filter color (channel) lies over a given pixel?
-func getChannel(x int, y int) int {
-	return pattern[y%2][x%2]
+// Filter channels of each pattern's cell, addressable as [y][x]
+var cfaPatternCells = map[CFAPattern][2][2]int{
+	CFARGGB: {{r, g}, {g, b}},
+	CFABGGR: {{b, g}, {g, r}},
+	CFAGRBG: {{g, r}, {b, g}},
+	CFAGBRG: {{g, b}, {r, g}},
 }
 
-func pixCompAvg(sum int, count int) uint8 {
-	divisor := 1
-	if count > 1 {
-		divisor = count
+func (p CFAPattern) String() string {
+	if name, ok := cfaPatternNames[p]; ok {
+		return name
 	}
-	return uint8(sum / divisor)
+	return fmt.Sprintf("CFAPattern(%d)", int(p))
 }
 
-// Demosaic an RGGB bayer image.
-func Demosaic(bayerImage *image.Gray) (image.Image, error) {
-	// The most obvious way to do this is via convolution kernels,
-	// but I'm finding it tedious to declare those.
-	bounds := bayerImage.Bounds()
-	result := image.NewRGBA(bounds)
-
-	for xImage := bounds.Min.X; xImage < bounds.Max.X; xImage++ {
-		xIndices := getIndices(xImage, bounds.Min.X, bounds.Max.X)
-		for yImage := bounds.Min.Y; yImage < bounds.Max.Y; yImage++ {
-			yIndices := getIndices(yImage, bounds.Min.Y, bounds.Max.Y)
-
-			sums := []int{0, 0, 0}
-			count := []int{0, 0, 0}
-			for _, x := range xIndices {
-				for _, y := range yIndices {
-					channel := getChannel(x-bounds.Min.X, y-bounds.Min.Y)
-					sums[channel] += int(bayerImage.GrayAt(x, y).Y)
-					count[channel] += 1
-				}
-			}
-
-			result.Set(xImage, yImage, color.RGBA{
-				// Avoid divide-by-zero:
-				pixCompAvg(sums[r], count[r]),
-				pixCompAvg(sums[g], count[g]),
-				pixCompAvg(sums[b], count[b]),
-				0xff,
-			})
+// Get the CFAPattern with a given name, e.g., "RGGB".
+func ParseCFAPattern(name string) (CFAPattern, error) {
+	for p, patternName := range cfaPatternNames {
+		if patternName == name {
+			return p, nil
 		}
 	}
-	return result, nil
+	return CFARGGB, fmt.Errorf("unknown CFA pattern %q", name)
 }
 
-// Demosaic a grayscale image that was stored as RGBA.
-func DemosaicRGBGray(bayerImage image.Image) (image.Image, error) {
+// Get the filter channel over sensor pixel (x, y).
+func (p CFAPattern) channel(x, y int) int {
+	return cfaPatternCells[p][y&1][x&1]
+}
 
-	rgbaImage, ok := (bayerImage).(*image.RGBA)
-	if !ok {
-		return bayerImage, errors.New("bayerImage must be an RGBA image")
+// Get the pattern seen by an image whose first pixel lies at sensor
+// coordinates origin.
+func (p CFAPattern) shifted(origin image.Point) CFAPattern {
+	for q, cell := range cfaPatternCells {
+		if (cell[0][0] == p.channel(origin.X, origin.Y)) &&
+			(cell[0][1] == p.channel(origin.X+1, origin.Y)) &&
+			(cell[1][0] == p.channel(origin.X, origin.Y+1)) {
+			return q
+		}
 	}
+	return p
+}
+
+// Demosaic an RGGB bayer image.  See DemosaicWithOptions.
+func Demosaic(bayerImage image.Image) (image.Image, error) {
+	return DemosaicWithOptions(bayerImage, DefaultDemosaicOptions())
+}
 
-	grayBayer := image.NewGray(rgbaImage.Bounds())
+// Demosaic a grayscale image that was stored as RGBA.  Demosaic now
+// accepts any image; this remains for existing callers.
+func DemosaicRGBGray(bayerImage image.Image) (image.Image, error) {
+	return Demosaic(bayerImage)
+}
 
-	// It should suffice to copy out any channel - all channels should
-	// have the same values.
-	iDest := 0
-	for iSrc := 0; iSrc < len(rgbaImage.Pix); iSrc += 4 {
-		grayBayer.Pix[iDest] = rgbaImage.Pix[iSrc]
-		iDest += 1
-	}
-	return Demosaic(grayBayer)
+func DemosaicRGBGrayWithOptions(bayerImage image.Image, opts DemosaicOptions) (image.Image, error) {
+	return DemosaicWithOptions(bayerImage, opts)
 }
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"math"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Adaptive Homogeneity-Directed demosaicing.  Interpolate green along rows
+// and, separately, along columns; fill in red and blue from each green
+// estimate; then, for each pixel, choose whichever of the two results is
+// more homogeneous in CIE Lab over the pixel's neighborhood.
+
+const (
+	ahdHorizontal = iota
+	ahdVertical
+	numAHDDirections
+)
+
+// A float64 plane the size of a cfaImage.
+type ahdPlane struct {
+	width, height int
+	pix           []float64
+}
+
+func newAHDPlane(width, height int) *ahdPlane {
+	return &ahdPlane{width, height, make([]float64, width*height)}
+}
+
+func (p *ahdPlane) at(x, y int) float64 {
+	return p.pix[mirror(y, p.height)*p.width+mirror(x, p.width)]
+}
+
+// Interpolate green along direction (dx, dy), correcting by the Laplacian
+// of the sampled channel.  Estimates are limited to the range of the
+// neighboring green samples, to avoid overshoot.
+func (m *cfaImage) ahdGreen(dx, dy int, workers int) *ahdPlane {
+	result := newAHDPlane(m.width, m.height)
+	m.forEachRow(workers, func(y int) {
+		for x := 0; x < m.width; x++ {
+			v := m.at(x, y)
+			if m.channel(x, y) != g {
+				g0 := m.at(x-dx, y-dy)
+				g1 := m.at(x+dx, y+dy)
+				estimate := (g0+g1)/2.0 + (2.0*v-m.at(x-2*dx, y-2*dy)-m.at(x+2*dx, y+2*dy))/4.0
+				v = math.Max(math.Min(g0, g1), math.Min(math.Max(g0, g1), estimate))
+			}
+			result.pix[y*m.width+x] = v
+		}
+	})
+	return result
+}
+
+// Fill in red and blue from a green estimate by interpolating the
+// differences between each channel and green.
+func (m *cfaImage) ahdFromGreen(green *ahdPlane, workers int) *rgbPlanes {
+	result := newRGBPlanes(m.width, m.height)
+	diff := func(x, y int) float64 { return m.at(x, y) - green.at(x, y) }
+	m.forEachRow(workers, func(y int) {
+		row := result.row(y)
+		for x := 0; x < m.width; x++ {
+			rgb := row[3*x : 3*x+3]
+			channel := m.channel(x, y)
+			gv := green.at(x, y)
+			rgb[g] = gv
+			rgb[channel] = m.at(x, y)
+			if channel == g {
+				rgb[m.channel(x+1, y)] = gv + (diff(x-1, y)+diff(x+1, y))/2.0
+				rgb[m.channel(x, y+1)] = gv + (diff(x, y-1)+diff(x, y+1))/2.0
+			} else {
+				rgb[r+b-channel] = gv + (diff(x-1, y-1)+diff(x+1, y-1)+diff(x-1, y+1)+diff(x+1, y+1))/4.0
+			}
+		}
+	})
+	return result
+}
+
+// Convert demosaiced values to CIE Lab, for measuring homogeneity.
+func (m *cfaImage) ahdLab(planes *rgbPlanes, workers int) []lib_color.CIELab {
+	result := make([]lib_color.CIELab, m.width*m.height)
+	toUint16 := func(v float64) uint32 {
+		return uint32(math.Max(0.0, math.Min(1.0, v/m.maxValue)) * 0xffff)
+	}
+	m.forEachRow(workers, func(y int) {
+		for i := y * m.width; i < (y+1)*m.width; i++ {
+			labL, labA, labB := lib_color.RGBToCIELab(
+				toUint16(planes.pix[3*i]), toUint16(planes.pix[3*i+1]), toUint16(planes.pix[3*i+2]))
+			result[i] = lib_color.CIELab{L: labL, A: labA, B: labB}
+		}
+	})
+	return result
+}
+
+var ahdNeighbors = [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+
+// Count, for each pixel of each direction's result, how many of its 4
+// neighbors are close to it in both lightness and chrominance.  "Close"
+// adapts to the local image content: it is the smaller of the horizontal
+// result's largest horizontal difference and the vertical result's largest
+// vertical difference.
+func (m *cfaImage) ahdHomogeneity(labs [numAHDDirections][]lib_color.CIELab, workers int) [numAHDDirections][]float64 {
+	result := [numAHDDirections][]float64{}
+	for d := range result {
+		result[d] = make([]float64, m.width*m.height)
+	}
+	m.forEachRow(workers, func(y int) {
+		for x := 0; x < m.width; x++ {
+			i := y*m.width + x
+			lDiffs := [numAHDDirections][4]float64{}
+			abDiffs := [numAHDDirections][4]float64{}
+			for d := range labs {
+				center := labs[d][i]
+				for n, offset := range ahdNeighbors {
+					nx := mirror(x+offset[0], m.width)
+					ny := mirror(y+offset[1], m.height)
+					neighbor := labs[d][ny*m.width+nx]
+					lDiffs[d][n] = math.Abs(center.L - neighbor.L)
+					dA := center.A - neighbor.A
+					dB := center.B - neighbor.B
+					abDiffs[d][n] = dA*dA + dB*dB
+				}
+			}
+			lEpsilon := math.Min(
+				math.Max(lDiffs[ahdHorizontal][0], lDiffs[ahdHorizontal][1]),
+				math.Max(lDiffs[ahdVertical][2], lDiffs[ahdVertical][3]))
+			abEpsilon := math.Min(
+				math.Max(abDiffs[ahdHorizontal][0], abDiffs[ahdHorizontal][1]),
+				math.Max(abDiffs[ahdVertical][2], abDiffs[ahdVertical][3]))
+			for d := range result {
+				for n := range ahdNeighbors {
+					if (lDiffs[d][n] <= lEpsilon) && (abDiffs[d][n] <= abEpsilon) {
+						result[d][i] += 1.0
+					}
+				}
+			}
+		}
+	})
+	return result
+}
+
+// Interpolate both directions and measure their homogeneity, then get a
+// function that fills each row with the more homogeneous direction's
+// values.
+func (m *cfaImage) ahdRows(workers int) demosaicRowFunc {
+	candidates := [numAHDDirections]*rgbPlanes{
+		m.ahdFromGreen(m.ahdGreen(1, 0, workers), workers),
+		m.ahdFromGreen(m.ahdGreen(0, 1, workers), workers),
+	}
+	labs := [numAHDDirections][]lib_color.CIELab{}
+	for d, candidate := range candidates {
+		labs[d] = m.ahdLab(candidate, workers)
+	}
+	homogeneity := m.ahdHomogeneity(labs, workers)
+
+	return func(y int, row []float64) {
+		for x := 0; x < m.width; x++ {
+			// Total homogeneity over the 3x3 neighborhood
+			scores := [numAHDDirections]float64{}
+			for d := range scores {
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx := mirror(x+dx, m.width)
+						ny := mirror(y+dy, m.height)
+						scores[d] += homogeneity[d][ny*m.width+nx]
+					}
+				}
+			}
+
+			horizontal := candidates[ahdHorizontal].at(x, y)
+			vertical := candidates[ahdVertical].at(x, y)
+			for channel := 0; channel < 3; channel++ {
+				if scores[ahdHorizontal] > scores[ahdVertical] {
+					row[3*x+channel] = horizontal[channel]
+				} else if scores[ahdVertical] > scores[ahdHorizontal] {
+					row[3*x+channel] = vertical[channel]
+				} else {
+					row[3*x+channel] = (horizontal[channel] + vertical[channel]) / 2.0
+				}
+			}
+		}
+	}
+}
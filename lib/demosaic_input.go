@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+)
+
+// Get the samples of a bayer image, which may be stored as any kind of
+// image.  Images with several channels should hold the same value in each;
+// the red channel is used.  16-bit images keep their precision.  Rows are
+// copied by up to workers goroutines.
+func newCFAImage(bayerImage image.Image, pattern CFAPattern, workers int) *cfaImage {
+	bounds := bayerImage.Bounds()
+	width := bounds.Dx()
+	result := &cfaImage{
+		width:    width,
+		height:   bounds.Dy(),
+		pix:      make([]float64, bounds.Dx()*bounds.Dy()),
+		maxValue: 255.0,
+		pattern:  cfaPatternCells[pattern],
+	}
+
+	// Copy samples from each row of an image with Pix and stride bytes per
+	// pixel, given the sample at a byte offset into Pix.
+	copyRows := func(pixOffset func(x, y int) int, stride int, sample func(i int) float64) {
+		result.forEachRow(workers, func(y int) {
+			offset := pixOffset(bounds.Min.X, bounds.Min.Y+y)
+			dest := result.pix[y*width : (y+1)*width]
+			for x := range dest {
+				dest[x] = sample(offset + stride*x)
+			}
+		})
+	}
+	sample16 := func(pix []uint8, i int) float64 {
+		return float64(uint16(pix[i])<<8 | uint16(pix[i+1]))
+	}
+
+	switch src := bayerImage.(type) {
+	case *image.Gray:
+		copyRows(src.PixOffset, 1, func(i int) float64 { return float64(src.Pix[i]) })
+	case *image.RGBA:
+		copyRows(src.PixOffset, 4, func(i int) float64 { return float64(src.Pix[i]) })
+	case *image.NRGBA:
+		copyRows(src.PixOffset, 4, func(i int) float64 { return float64(src.Pix[i]) })
+	case *image.Paletted:
+		// Indices beyond the palette read as black, as image.Paletted's At
+		// does.
+		palette := make([]float64, 256)
+		for i, c := range src.Palette {
+			palette[i] = float64(color.NRGBAModel.Convert(c).(color.NRGBA).R)
+		}
+		copyRows(src.PixOffset, 1, func(i int) float64 { return palette[src.Pix[i]] })
+	case *image.Gray16:
+		result.maxValue = 65535.0
+		copyRows(src.PixOffset, 2, func(i int) float64 { return sample16(src.Pix, i) })
+	case *image.RGBA64:
+		result.maxValue = 65535.0
+		copyRows(src.PixOffset, 8, func(i int) float64 { return sample16(src.Pix, i) })
+	case *image.NRGBA64:
+		result.maxValue = 65535.0
+		copyRows(src.PixOffset, 8, func(i int) float64 { return sample16(src.Pix, i) })
+	default:
+		// Treat other images as 8-bit, keeping any extra precision.
+		result.forEachRow(workers, func(y int) {
+			for x := 0; x < width; x++ {
+				gray := color.Gray16Model.Convert(bayerImage.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16)
+				result.pix[y*width+x] = float64(gray.Y) / 257.0
+			}
+		})
+	}
+	return result
+}
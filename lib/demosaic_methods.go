@@ -0,0 +1,352 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+)
+
+// DemosaicMethod selects a demosaicing algorithm.
+type DemosaicMethod int
+
+const (
+	// Average each channel over a 3x3 neighborhood.  This is what Demosaic
+	// does.
+	DemosaicBox DemosaicMethod = iota
+	// Interpolate each missing channel bilinearly from its nearest samples.
+	DemosaicBilinear
+	// Bilinear interpolation corrected by the gradient of the sampled
+	// channel.  See Malvar, He and Cutler, "High-Quality Linear
+	// Interpolation for Demosaicing of Bayer-Patterned Color Images",
+	// ICASSP 2004.
+	DemosaicMHC
+	// Variable Number of Gradients: average over only the directions in
+	// which the image is smooth.  See Chang, Cheung and Pang, "Color Filter
+	// Array Recovery Using a Threshold-based Variable Number of Gradients",
+	// SPIE 1999.
+	DemosaicVNG
+	// Adaptive Homogeneity-Directed: interpolate both horizontally and
+	// vertically, then choose, per pixel, whichever result is more
+	// homogeneous in CIE Lab.  See Hirakawa and Parks, "Adaptive
+	// Homogeneity-Directed Demosaicing Algorithm", IEEE Transactions on
+	// Image Processing, 2005.
+	DemosaicAHD
+)
+
+var demosaicMethodNames = map[DemosaicMethod]string{
+	DemosaicBox:      "box",
+	DemosaicBilinear: "bilinear",
+	DemosaicMHC:      "mhc",
+	DemosaicVNG:      "vng",
+	DemosaicAHD:      "ahd",
+}
+
+func (method DemosaicMethod) String() string {
+	if name, ok := demosaicMethodNames[method]; ok {
+		return name
+	}
+	return fmt.Sprintf("DemosaicMethod(%d)", int(method))
+}
+
+// Get the DemosaicMethod with a given name, e.g., "mhc".
+func ParseDemosaicMethod(name string) (DemosaicMethod, error) {
+	for method, methodName := range demosaicMethodNames {
+		if methodName == name {
+			return method, nil
+		}
+	}
+	return DemosaicBox, fmt.Errorf("unknown demosaic method %q", name)
+}
+
+type DemosaicOptions struct {
+	Method DemosaicMethod
+	// The sensor's filter pattern, starting at its first pixel.
+	Pattern CFAPattern
+	// Number of goroutines used for per-pixel operations.  Zero means one
+	// per CPU.
+	Workers int
+}
+
+func DefaultDemosaicOptions() DemosaicOptions {
+	return DemosaicOptions{Method: DemosaicBox, Pattern: CFARGGB}
+}
+
+// cfaImage is a raw color filter array image: one sample per pixel, taken
+// through the filter given by pattern.
+type cfaImage struct {
+	width, height int
+	pix           []float64
+	// Largest possible sample value.
+	maxValue float64
+	// Channel of the filter at each position, indexed [y&1][x&1].
+	pattern [2][2]int
+}
+
+// Reflect a coordinate beyond [0, n) back inside, about the edge pixels.
+// This preserves the coordinate's parity, and thus the filter pattern.
+func mirror(i, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	for (i < 0) || (i >= n) {
+		if i < 0 {
+			i = -i
+		} else {
+			i = 2*(n-1) - i
+		}
+	}
+	return i
+}
+
+func (m *cfaImage) at(x, y int) float64 {
+	if (x < 0) || (x >= m.width) || (y < 0) || (y >= m.height) {
+		x = mirror(x, m.width)
+		y = mirror(y, m.height)
+	}
+	return m.pix[y*m.width+x]
+}
+
+func (m *cfaImage) channel(x, y int) int {
+	return m.pattern[y&1][x&1]
+}
+
+// Call fn for each row of m, in up to workers concurrent bands.
+func (m *cfaImage) forEachRow(workers int, fn func(y int)) {
+	lib_image.ForEachRowBand(image.Rect(0, 0, m.width, m.height), workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			fn(y)
+		}
+	})
+}
+
+// A demosaicRowFunc fills row with the demosaiced values of row y of a
+// cfaImage, 3 per pixel.  It must be safe for concurrent use.
+type demosaicRowFunc func(y int, row []float64)
+
+// rgbPlanes holds demosaiced float64 values, 3 per pixel.
+type rgbPlanes struct {
+	width, height int
+	pix           []float64
+}
+
+func newRGBPlanes(width, height int) *rgbPlanes {
+	return &rgbPlanes{width, height, make([]float64, 3*width*height)}
+}
+
+func (p *rgbPlanes) row(y int) []float64 {
+	return p.pix[3*y*p.width : 3*(y+1)*p.width]
+}
+
+func (p *rgbPlanes) at(x, y int) [3]float64 {
+	i := 3 * (y*p.width + x)
+	return [3]float64{p.pix[i], p.pix[i+1], p.pix[i+2]}
+}
+
+func clampToUint8(v float64) uint8 {
+	return uint8(math.Max(0.0, math.Min(255.0, math.Round(v))))
+}
+
+func clampToUint16(v float64) uint16 {
+	return uint16(math.Max(0.0, math.Min(65535.0, math.Round(v))))
+}
+
+// Demosaic m row by row, writing straight into the Pix of an *image.RGBA,
+// or of an *image.RGBA64 if m has 16-bit samples.
+func (m *cfaImage) render(bounds image.Rectangle, workers int, fill demosaicRowFunc) image.Image {
+	var writeRow func(y int, src []float64)
+	var result image.Image
+	if m.maxValue > 255.0 {
+		rgba64 := image.NewRGBA64(bounds)
+		result = rgba64
+		writeRow = func(y int, src []float64) {
+			dest := rgba64.Pix[rgba64.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			for x := 0; x < m.width; x++ {
+				for channel := 0; channel < 3; channel++ {
+					v := clampToUint16(src[3*x+channel])
+					dest[8*x+2*channel] = uint8(v >> 8)
+					dest[8*x+2*channel+1] = uint8(v)
+				}
+				dest[8*x+6] = 0xff
+				dest[8*x+7] = 0xff
+			}
+		}
+	} else {
+		rgba := image.NewRGBA(bounds)
+		result = rgba
+		writeRow = func(y int, src []float64) {
+			dest := rgba.Pix[rgba.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			for x := 0; x < m.width; x++ {
+				dest[4*x] = clampToUint8(src[3*x])
+				dest[4*x+1] = clampToUint8(src[3*x+1])
+				dest[4*x+2] = clampToUint8(src[3*x+2])
+				dest[4*x+3] = 0xff
+			}
+		}
+	}
+
+	lib_image.ForEachRowBand(image.Rect(0, 0, m.width, m.height), workers, func(_ int, band image.Rectangle) {
+		row := make([]float64, 3*m.width)
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			fill(y, row)
+			writeRow(y, row)
+		}
+	})
+	return result
+}
+
+// Demosaic a bayer image using the method and filter pattern given by
+// opts.  The image's first pixel is taken to be the sensor's first pixel.
+// The image may be of any type; see newCFAImage.  The result is an
+// *image.RGBA64 if the image has 16-bit samples, else an *image.RGBA.
+func DemosaicWithOptions(bayerImage image.Image, opts DemosaicOptions) (image.Image, error) {
+	return DemosaicTile(bayerImage, image.Point{}, opts)
+}
+
+// Demosaic a bayer image whose first pixel lies at sensor coordinates
+// sensorOrigin, e.g., a subframe.  The origin determines the phase of the
+// filter pattern over the image.
+func DemosaicTile(bayerImage image.Image, sensorOrigin image.Point, opts DemosaicOptions) (image.Image, error) {
+	if _, ok := demosaicMethodNames[opts.Method]; !ok {
+		return nil, fmt.Errorf("unsupported demosaic method %v", opts.Method)
+	}
+	if _, ok := cfaPatternCells[opts.Pattern]; !ok {
+		return nil, fmt.Errorf("unsupported CFA pattern %v", opts.Pattern)
+	}
+	m := newCFAImage(bayerImage, opts.Pattern.shifted(sensorOrigin), opts.Workers)
+
+	var fill demosaicRowFunc
+	switch opts.Method {
+	case DemosaicBox:
+		fill = m.boxRow
+	case DemosaicBilinear:
+		fill = m.bilinearRow
+	case DemosaicMHC:
+		fill = m.mhcRow
+	case DemosaicVNG:
+		fill = m.vngRow
+	case DemosaicAHD:
+		fill = m.ahdRows(opts.Workers)
+	}
+	return m.render(bayerImage.Bounds(), opts.Workers, fill), nil
+}
+
+// Average each channel over the part of each pixel's 3x3 neighborhood that
+// lies within the image, truncating as Demosaic always has.
+func (m *cfaImage) boxRow(y int, row []float64) {
+	y0 := y - 1
+	if y0 < 0 {
+		y0 = 0
+	}
+	y1 := y + 1
+	if y1 >= m.height {
+		y1 = m.height - 1
+	}
+	for x := 0; x < m.width; x++ {
+		x0 := x - 1
+		if x0 < 0 {
+			x0 = 0
+		}
+		x1 := x + 1
+		if x1 >= m.width {
+			x1 = m.width - 1
+		}
+		sums := [3]float64{}
+		counts := [3]float64{}
+		for ny := y0; ny <= y1; ny++ {
+			src := m.pix[ny*m.width:]
+			cell := m.pattern[ny&1]
+			for nx := x0; nx <= x1; nx++ {
+				channel := cell[nx&1]
+				sums[channel] += src[nx]
+				counts[channel] += 1.0
+			}
+		}
+		for channel := 0; channel < 3; channel++ {
+			row[3*x+channel] = math.Floor(sums[channel] / math.Max(1.0, counts[channel]))
+		}
+	}
+}
+
+// Bilinear weights of the 3x3 neighborhood, addressable as [dy+1][dx+1].
+var bilinearWeights = [3][3]float64{
+	{1.0, 2.0, 1.0},
+	{2.0, 4.0, 2.0},
+	{1.0, 2.0, 1.0},
+}
+
+func (m *cfaImage) bilinearRow(y int, row []float64) {
+	for x := 0; x < m.width; x++ {
+		sums := [3]float64{}
+		weights := [3]float64{}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				channel := m.channel(x+dx, y+dy)
+				w := bilinearWeights[dy+1][dx+1]
+				sums[channel] += w * m.at(x+dx, y+dy)
+				weights[channel] += w
+			}
+		}
+		for channel := 0; channel < 3; channel++ {
+			row[3*x+channel] = sums[channel] / weights[channel]
+		}
+		row[3*x+m.channel(x, y)] = m.at(x, y)
+	}
+}
+
+// Malvar-He-Cutler kernels, each scaled by 8.  Names say which channel is
+// estimated where.
+
+// Green at a red or blue pixel.
+func (m *cfaImage) mhcGreen(x, y int) float64 {
+	v := m.at
+	return (4.0*v(x, y) +
+		2.0*(v(x, y-1)+v(x, y+1)+v(x-1, y)+v(x+1, y)) -
+		(v(x, y-2) + v(x, y+2) + v(x-2, y) + v(x+2, y))) / 8.0
+}
+
+// Red or blue at a green pixel, where that channel's samples lie in the
+// same row.
+func (m *cfaImage) mhcRowNeighbors(x, y int) float64 {
+	v := m.at
+	return (5.0*v(x, y) +
+		4.0*(v(x-1, y)+v(x+1, y)) -
+		(v(x-2, y) + v(x+2, y)) -
+		(v(x-1, y-1) + v(x+1, y-1) + v(x-1, y+1) + v(x+1, y+1)) +
+		0.5*(v(x, y-2)+v(x, y+2))) / 8.0
+}
+
+// Red or blue at a green pixel, where that channel's samples lie in the
+// same column.
+func (m *cfaImage) mhcColumnNeighbors(x, y int) float64 {
+	v := m.at
+	return (5.0*v(x, y) +
+		4.0*(v(x, y-1)+v(x, y+1)) -
+		(v(x, y-2) + v(x, y+2)) -
+		(v(x-1, y-1) + v(x+1, y-1) + v(x-1, y+1) + v(x+1, y+1)) +
+		0.5*(v(x-2, y)+v(x+2, y))) / 8.0
+}
+
+// Red at a blue pixel, or blue at a red pixel.
+func (m *cfaImage) mhcDiagonalNeighbors(x, y int) float64 {
+	v := m.at
+	return (6.0*v(x, y) +
+		2.0*(v(x-1, y-1)+v(x+1, y-1)+v(x-1, y+1)+v(x+1, y+1)) -
+		1.5*(v(x, y-2)+v(x, y+2)+v(x-2, y)+v(x+2, y))) / 8.0
+}
+
+func (m *cfaImage) mhcRow(y int, row []float64) {
+	for x := 0; x < m.width; x++ {
+		rgb := row[3*x : 3*x+3]
+		channel := m.channel(x, y)
+		rgb[channel] = m.at(x, y)
+		if channel == g {
+			rgb[m.channel(x+1, y)] = m.mhcRowNeighbors(x, y)
+			rgb[m.channel(x, y+1)] = m.mhcColumnNeighbors(x, y)
+		} else {
+			rgb[g] = m.mhcGreen(x, y)
+			rgb[r+b-channel] = m.mhcDiagonalNeighbors(x, y)
+		}
+	}
+}
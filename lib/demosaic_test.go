@@ -1,9 +1,12 @@
 package lib
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/png"
+	"math"
 	"os"
 	"testing"
 )
@@ -21,12 +24,8 @@ func savePNG(image image.Image, filename string, t *testing.T) {
 	}
 }
 
-func TestGraydient(t *testing.T) {
-	// Verify that a constant-tone grayscale image can be demosaiced
-	// without crashing.
-
-	width := 255
-	height := 255
+// A gradient with boosted red samples.
+func graydientImage(width, height int) *image.Gray {
 	imageRect := image.Rect(0, 0, width, height)
 	grayImage := image.NewGray(imageRect)
 
@@ -42,6 +41,29 @@ func TestGraydient(t *testing.T) {
 			grayImage.Set(x, y, color.Gray{uint8(intensity)})
 		}
 	}
+	return grayImage
+}
+
+func loadNRESampleImage(t *testing.T) image.Image {
+	// Use a sample full-sensor readout image from NASA Mars 2020 website.
+	inputPathname := "test_data/nre_sample_image.png"
+	inf, err := os.Open(inputPathname)
+	if err != nil {
+		t.Fatal("Can't find test image", inputPathname)
+	}
+	defer inf.Close()
+
+	inputImage, err := png.Decode(inf)
+	if err != nil {
+		t.Fatal("Could not decode PNG test image", inputPathname)
+	}
+	return inputImage
+}
+
+func TestGraydient(t *testing.T) {
+	// Verify that a constant-tone grayscale image can be demosaiced
+	// without crashing.
+	grayImage := graydientImage(255, 255)
 	outDir := "test_data/out/demosaic/"
 	err := ensureDirExists(outDir)
 	if err != nil {
@@ -58,17 +80,7 @@ func TestGraydient(t *testing.T) {
 }
 
 func TestDemosaicRGBGray(t *testing.T) {
-	// Use a sample full-sensor readout image from NASA Mars 2020 website.
-	inputPathname := "test_data/nre_sample_image.png"
-	inf, err := os.Open(inputPathname)
-	if err != nil {
-		t.Fatal("Can't find test image", inputPathname)
-	}
-
-	inputImage, err := png.Decode(inf)
-	if err != nil {
-		t.Fatal("Could not decode PNG test image", inputPathname)
-	}
+	inputImage := loadNRESampleImage(t)
 
 	rgbImage, err := DemosaicRGBGray(inputImage)
 	if err != nil {
@@ -82,3 +94,503 @@ func TestDemosaicRGBGray(t *testing.T) {
 	}
 	savePNG(rgbImage, outDir+"demosaic_rgb_gray.png", t)
 }
+
+// A known RGB scene with smooth gradients, sharp color edges and fine
+// texture.
+func demosaicTestScene(width, height int) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	cx := float64(width) / 2.0
+	cy := float64(height) / 2.0
+	radius := float64(width) / 4.0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx := float64(x)
+			fy := float64(y)
+			red := 40.0 + 150.0*fx/float64(width)
+			green := 60.0 + 120.0*fy/float64(height)
+			blue := 90.0 + 40.0*math.Sin(fx/9.0)*math.Cos(fy/7.0)
+			if math.Hypot(fx-cx, fy-cy) < radius {
+				red, green, blue = 200.0, 140.0, 60.0
+			}
+			if (y > height*3/4) && (x < width/2) {
+				// Fine stripes, about 6 pixels per cycle
+				stripe := 50.0 * math.Sin(fx/1.0)
+				red += stripe
+				green += stripe
+				blue += stripe
+			}
+			result.SetRGBA(x, y, color.RGBA{clampToUint8(red), clampToUint8(green), clampToUint8(blue), 0xff})
+		}
+	}
+	return result
+}
+
+// Sample an RGB image through an RGGB filter.
+func mosaic(img *image.RGBA) *image.Gray {
+	return mosaicWithPattern(img, CFARGGB, image.Point{})
+}
+
+// Sample an RGB image, whose first pixel lies at sensor coordinates origin,
+// through a filter pattern.
+func mosaicWithPattern(img *image.RGBA, pattern CFAPattern, origin image.Point) *image.Gray {
+	bounds := img.Bounds()
+	result := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pix := img.RGBAAt(x, y)
+			values := [3]uint8{pix.R, pix.G, pix.B}
+			channel := pattern.channel(origin.X+x-bounds.Min.X, origin.Y+y-bounds.Min.Y)
+			result.SetGray(x, y, color.Gray{values[channel]})
+		}
+	}
+	return result
+}
+
+// Copy part of an image to a new image whose bounds start at (0, 0), as
+// though it were a subframe read from a file.
+func cropRGBA(img *image.RGBA, rect image.Rectangle) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			result.SetRGBA(x, y, img.RGBAAt(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+	return result
+}
+
+// Peak signal to noise ratio, in dB, of actual vs. expected, ignoring a
+// border of the given width.
+func demosaicPSNR(expected *image.RGBA, actual image.Image, border int) float64 {
+	bounds := expected.Bounds().Inset(border)
+	sumSq := 0.0
+	n := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := expected.RGBAAt(x, y)
+			got := color.RGBAModel.Convert(actual.At(x, y)).(color.RGBA)
+			for _, pair := range [][2]uint8{{want.R, got.R}, {want.G, got.G}, {want.B, got.B}} {
+				d := float64(pair[0]) - float64(pair[1])
+				sumSq += d * d
+				n += 1.0
+			}
+		}
+	}
+	mse := sumSq / n
+	if mse == 0.0 {
+		return math.Inf(1)
+	}
+	return 10.0 * math.Log10(255.0*255.0/mse)
+}
+
+func TestDemosaicMethodsPSNR(t *testing.T) {
+	scene := demosaicTestScene(128, 96)
+	bayer := mosaic(scene)
+
+	outDir := "test_data/out/demosaic/"
+	if err := ensureDirExists(outDir); err != nil {
+		t.Fatal("Could not create test output directory", outDir)
+	}
+	savePNG(scene, outDir+"methods_scene.png", t)
+
+	minPSNR := map[DemosaicMethod]float64{
+		DemosaicBox:      31.0,
+		DemosaicBilinear: 32.0,
+		DemosaicMHC:      32.5,
+		DemosaicVNG:      34.5,
+		DemosaicAHD:      35.0,
+	}
+	psnrs := map[DemosaicMethod]float64{}
+	for method, threshold := range minPSNR {
+		result, err := DemosaicWithOptions(bayer, DemosaicOptions{Method: method})
+		if err != nil {
+			t.Fatal("Error de-mosaicing:", err)
+		}
+		savePNG(result, outDir+"methods_"+method.String()+".png", t)
+		psnrs[method] = demosaicPSNR(scene, result, 2)
+		t.Logf("%v: PSNR %.2f dB", method, psnrs[method])
+		if psnrs[method] < threshold {
+			t.Errorf("%v: expected PSNR of at least %v dB, got %.2f", method, threshold, psnrs[method])
+		}
+	}
+	for _, method := range []DemosaicMethod{DemosaicMHC, DemosaicVNG, DemosaicAHD} {
+		if psnrs[method] <= psnrs[DemosaicBilinear] {
+			t.Errorf("Expected %v to beat bilinear: %.2f vs. %.2f dB", method, psnrs[method], psnrs[DemosaicBilinear])
+		}
+	}
+}
+
+func TestDemosaicMethodsPreserveFlatColor(t *testing.T) {
+	scene := image.NewRGBA(image.Rect(3, 5, 19, 17))
+	for y := scene.Rect.Min.Y; y < scene.Rect.Max.Y; y++ {
+		for x := scene.Rect.Min.X; x < scene.Rect.Max.X; x++ {
+			scene.SetRGBA(x, y, color.RGBA{180, 90, 30, 0xff})
+		}
+	}
+	bayer := mosaic(scene)
+	for method := range demosaicMethodNames {
+		result, err := DemosaicWithOptions(bayer, DemosaicOptions{Method: method})
+		if err != nil {
+			t.Fatal("Error de-mosaicing:", err)
+		}
+		if result.Bounds() != scene.Bounds() {
+			t.Fatalf("%v: expected bounds %v, got %v", method, scene.Bounds(), result.Bounds())
+		}
+		if psnr := demosaicPSNR(scene, result, 0); !math.IsInf(psnr, 1) {
+			t.Errorf("%v: expected an exact result, got PSNR %.2f dB", method, psnr)
+		}
+	}
+}
+
+func TestParseDemosaicMethod(t *testing.T) {
+	for method := range demosaicMethodNames {
+		parsed, err := ParseDemosaicMethod(method.String())
+		if (err != nil) || (parsed != method) {
+			t.Errorf("Expected %v, got %v (%v)", method, parsed, err)
+		}
+	}
+	if _, err := ParseDemosaicMethod("bogus"); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
+
+// The original demosaicing algorithm: average each channel over the part
+// of each pixel's 3x3 neighborhood that lies within the image, truncating.
+func referenceDemosaic(bayerImage *image.Gray) *image.RGBA {
+	bounds := bayerImage.Bounds()
+	result := image.NewRGBA(bounds)
+	for yImage := bounds.Min.Y; yImage < bounds.Max.Y; yImage++ {
+		for xImage := bounds.Min.X; xImage < bounds.Max.X; xImage++ {
+			sums := []int{0, 0, 0}
+			count := []int{0, 0, 0}
+			for y := yImage - 1; y <= yImage+1; y++ {
+				for x := xImage - 1; x <= xImage+1; x++ {
+					if image.Pt(x, y).In(bounds) {
+						channel := CFARGGB.channel(x-bounds.Min.X, y-bounds.Min.Y)
+						sums[channel] += int(bayerImage.GrayAt(x, y).Y)
+						count[channel] += 1
+					}
+				}
+			}
+			avg := func(channel int) uint8 {
+				if count[channel] > 1 {
+					return uint8(sums[channel] / count[channel])
+				}
+				return uint8(sums[channel])
+			}
+			result.SetRGBA(xImage, yImage, color.RGBA{avg(r), avg(g), avg(b), 0xff})
+		}
+	}
+	return result
+}
+
+func assertSamePix(t *testing.T, want, got []uint8) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v bytes, got %v", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Results differ at byte %v: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+// Get the red channel of an image, which holds a bayer image in every
+// channel.
+func redChannel(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	result := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			red, _, _, _ := img.At(x, y).RGBA()
+			result.SetGray(x, y, color.Gray{uint8(red >> 8)})
+		}
+	}
+	return result
+}
+
+func TestDemosaicMatchesReference(t *testing.T) {
+	scene := demosaicTestScene(37, 29)
+	// Bounds needn't start at the origin.
+	offset := mosaic(cropRGBA(scene, scene.Bounds()))
+	offset.Rect = offset.Rect.Add(image.Pt(5, -3))
+	nreSample := loadNRESampleImage(t)
+
+	testCases := []struct {
+		name      string
+		input     image.Image
+		reference *image.RGBA
+	}{
+		{"scene", mosaic(scene), referenceDemosaic(mosaic(scene))},
+		{"offset", offset, referenceDemosaic(offset)},
+		{"graydient", graydientImage(255, 255), referenceDemosaic(graydientImage(255, 255))},
+		{"nre sample", nreSample, referenceDemosaic(redChannel(nreSample))},
+	}
+	for _, tc := range testCases {
+		got, err := Demosaic(tc.input)
+		if err != nil {
+			t.Fatalf("%v: error de-mosaicing: %v", tc.name, err)
+		}
+		assertSamePix(t, tc.reference.Pix, got.(*image.RGBA).Pix)
+
+		// Any number of workers gives the same result.
+		for _, workers := range []int{1, 3, 8} {
+			opts := DefaultDemosaicOptions()
+			opts.Workers = workers
+			got, err := DemosaicWithOptions(tc.input, opts)
+			if err != nil {
+				t.Fatalf("%v: error de-mosaicing: %v", tc.name, err)
+			}
+			assertSamePix(t, tc.reference.Pix, got.(*image.RGBA).Pix)
+		}
+	}
+}
+
+func TestDemosaicMethodsIndependentOfWorkers(t *testing.T) {
+	bayer := mosaic(demosaicTestScene(40, 30))
+	for method := range demosaicMethodNames {
+		var want []uint8
+		for _, workers := range []int{1, 3, 7} {
+			got, err := DemosaicWithOptions(bayer, DemosaicOptions{Method: method, Workers: workers})
+			if err != nil {
+				t.Fatal("Error de-mosaicing:", err)
+			}
+			if want == nil {
+				want = got.(*image.RGBA).Pix
+			} else {
+				assertSamePix(t, want, got.(*image.RGBA).Pix)
+			}
+		}
+	}
+}
+
+func BenchmarkDemosaic(bm *testing.B) {
+	bayer := mosaic(demosaicTestScene(1648, 1200))
+	for _, method := range []DemosaicMethod{DemosaicBox, DemosaicMHC, DemosaicAHD} {
+		for _, workers := range benchmarkWorkerCounts() {
+			bm.Run(fmt.Sprintf("%v-workers=%d", method, workers), func(bm *testing.B) {
+				opts := DemosaicOptions{Method: method, Workers: workers}
+				for i := 0; i < bm.N; i++ {
+					if _, err := DemosaicWithOptions(bayer, opts); err != nil {
+						bm.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Store a bayer image's samples in an image of another type.
+func convertBayerImage(gray *image.Gray, model color.Model) image.Image {
+	bounds := gray.Bounds()
+	var result draw.Image
+	switch model {
+	case color.Gray16Model:
+		result = image.NewGray16(bounds)
+	case color.RGBAModel:
+		result = image.NewRGBA(bounds)
+	case color.NRGBAModel:
+		result = image.NewNRGBA(bounds)
+	case color.RGBA64Model:
+		result = image.NewRGBA64(bounds)
+	case color.NRGBA64Model:
+		result = image.NewNRGBA64(bounds)
+	default:
+		palette := make(color.Palette, 256)
+		for i := range palette {
+			palette[i] = color.Gray{uint8(i)}
+		}
+		result = image.NewPaletted(bounds, palette)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			result.Set(x, y, gray.GrayAt(x, y))
+		}
+	}
+	return result
+}
+
+// An image type with no fast path.
+type plainImage struct {
+	image.Image
+}
+
+func TestDemosaicAcceptsImageTypes(t *testing.T) {
+	bayer := mosaic(demosaicTestScene(40, 30))
+	opts := DemosaicOptions{Method: DemosaicMHC, Pattern: CFARGGB}
+	want, err := DemosaicWithOptions(bayer, opts)
+	if err != nil {
+		t.Fatal("Error de-mosaicing:", err)
+	}
+	wantPix := want.(*image.RGBA).Pix
+
+	// 8-bit inputs give identical 8-bit results.
+	for _, input := range []image.Image{
+		convertBayerImage(bayer, color.RGBAModel),
+		convertBayerImage(bayer, color.NRGBAModel),
+		convertBayerImage(bayer, nil),
+		plainImage{bayer},
+	} {
+		got, err := DemosaicWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("%T: error de-mosaicing: %v", input, err)
+		}
+		rgba, ok := got.(*image.RGBA)
+		if !ok {
+			t.Fatalf("%T: expected an *image.RGBA, got %T", input, got)
+		}
+		assertSamePix(t, wantPix, rgba.Pix)
+	}
+	if _, err := DemosaicRGBGray(convertBayerImage(bayer, color.NRGBAModel)); err != nil {
+		t.Error("Expected DemosaicRGBGray to accept an NRGBA image, got", err)
+	}
+
+	// 16-bit inputs give 16-bit results, which agree to within rounding.
+	for _, model := range []color.Model{color.Gray16Model, color.RGBA64Model, color.NRGBA64Model} {
+		input := convertBayerImage(bayer, model)
+		got, err := DemosaicWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("%T: error de-mosaicing: %v", input, err)
+		}
+		rgba64, ok := got.(*image.RGBA64)
+		if !ok {
+			t.Fatalf("%T: expected an *image.RGBA64, got %T", input, got)
+		}
+		for i := 0; i < len(wantPix); i++ {
+			got8 := float64(uint16(rgba64.Pix[2*i])<<8|uint16(rgba64.Pix[2*i+1])) / 257.0
+			if math.Abs(got8-float64(wantPix[i])) > 0.5 {
+				t.Fatalf("%T: results differ at component %v: expected %v, got %v", input, i, wantPix[i], got8)
+			}
+		}
+	}
+}
+
+func TestDemosaicKeeps16BitPrecision(t *testing.T) {
+	// Values that differ only below 8 bits
+	rect := image.Rect(0, 0, 12, 10)
+	bayer := image.NewGray16(rect)
+	values := [3]uint16{1000, 1001, 1003}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			bayer.SetGray16(x, y, color.Gray16{values[CFARGGB.channel(x, y)]})
+		}
+	}
+	for method := range demosaicMethodNames {
+		got, err := DemosaicWithOptions(bayer, DemosaicOptions{Method: method})
+		if err != nil {
+			t.Fatal("Error de-mosaicing:", err)
+		}
+		rgba64 := got.(*image.RGBA64)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				want := color.RGBA64{values[r], values[g], values[b], 0xffff}
+				if pix := rgba64.RGBA64At(x, y); pix != want {
+					t.Fatalf("%v: expected %v at (%v, %v), got %v", method, want, x, y, pix)
+				}
+			}
+		}
+	}
+}
+
+func TestAHDLabKeeps16BitPrecision(t *testing.T) {
+	// Interpolated values of 8-bit images have fractional parts, which
+	// homogeneity measurement should not round away.  These pixels differ
+	// by less than one 8-bit step.
+	m := &cfaImage{width: 2, height: 1, maxValue: 255.0}
+	planes := newRGBPlanes(2, 1)
+	copy(planes.pix, []float64{100.1, 60.1, 30.1, 100.4, 60.4, 30.4})
+	labs := m.ahdLab(planes, 1)
+	if labs[0] == labs[1] {
+		t.Fatalf("Expected sub-integer differences to be kept, got %v for both", labs[0])
+	}
+	if labs[1].L <= labs[0].L {
+		t.Errorf("Expected the brighter pixel to have greater L, got %v and %v", labs[0], labs[1])
+	}
+
+	// So AHD chooses the same interpolation directions for an 8-bit mosaic
+	// as for the same mosaic at 16 bits, and the results agree to within
+	// rounding.
+	bayer := mosaic(demosaicTestScene(40, 30))
+	opts := DemosaicOptions{Method: DemosaicAHD, Pattern: CFARGGB}
+	want, err := DemosaicWithOptions(bayer, opts)
+	if err != nil {
+		t.Fatal("Error de-mosaicing:", err)
+	}
+	wantPix := want.(*image.RGBA).Pix
+	got, err := DemosaicWithOptions(convertBayerImage(bayer, color.Gray16Model), opts)
+	if err != nil {
+		t.Fatal("Error de-mosaicing:", err)
+	}
+	rgba64 := got.(*image.RGBA64)
+	for i := 0; i < len(wantPix); i++ {
+		got8 := float64(uint16(rgba64.Pix[2*i])<<8|uint16(rgba64.Pix[2*i+1])) / 257.0
+		if math.Abs(got8-float64(wantPix[i])) > 0.5 {
+			t.Fatalf("Results differ at component %v: expected %v, got %v", i, wantPix[i], got8)
+		}
+	}
+}
+
+func TestCFAPatternShifted(t *testing.T) {
+	testCases := []struct {
+		pattern CFAPattern
+		origin  image.Point
+		want    CFAPattern
+	}{
+		{CFARGGB, image.Pt(0, 0), CFARGGB},
+		{CFARGGB, image.Pt(1, 0), CFAGRBG},
+		{CFARGGB, image.Pt(0, 1), CFAGBRG},
+		{CFARGGB, image.Pt(1, 1), CFABGGR},
+		{CFARGGB, image.Pt(-3, 4), CFAGRBG},
+		{CFAGBRG, image.Pt(1, 1), CFAGRBG},
+		{CFABGGR, image.Pt(2, 1), CFAGRBG},
+	}
+	for _, tc := range testCases {
+		if got := tc.pattern.shifted(tc.origin); got != tc.want {
+			t.Errorf("%v shifted by %v: expected %v, got %v", tc.pattern, tc.origin, tc.want, got)
+		}
+	}
+}
+
+func TestParseCFAPattern(t *testing.T) {
+	for pattern := range cfaPatternNames {
+		parsed, err := ParseCFAPattern(pattern.String())
+		if (err != nil) || (parsed != pattern) {
+			t.Errorf("Expected %v, got %v (%v)", pattern, parsed, err)
+		}
+	}
+	if _, err := ParseCFAPattern("RGBG"); err == nil {
+		t.Error("Expected an error for an unknown pattern")
+	}
+}
+
+func TestDemosaicTileUsesSensorOrigin(t *testing.T) {
+	scene := demosaicTestScene(96, 72)
+	for pattern := range cfaPatternNames {
+		opts := DemosaicOptions{Method: DemosaicMHC, Pattern: pattern}
+		for _, origin := range []image.Point{{0, 0}, {17, 10}, {10, 23}, {31, 5}} {
+			subframe := image.Rect(origin.X, origin.Y, origin.X+48, origin.Y+40)
+			expected := cropRGBA(scene, subframe)
+			bayer := mosaicWithPattern(expected, pattern, origin)
+
+			result, err := DemosaicTile(bayer, origin, opts)
+			if err != nil {
+				t.Fatal("Error de-mosaicing:", err)
+			}
+			if psnr := demosaicPSNR(expected, result, 2); psnr < 28.0 {
+				t.Errorf("%v at %v: expected PSNR of at least 28 dB, got %.2f", pattern, origin, psnr)
+			}
+
+			if (origin.X+origin.Y)%2 == 0 {
+				continue
+			}
+			// Ignoring the origin swaps red or blue with green.
+			result, err = DemosaicWithOptions(bayer, opts)
+			if err != nil {
+				t.Fatal("Error de-mosaicing:", err)
+			}
+			if psnr := demosaicPSNR(expected, result, 2); psnr > 25.0 {
+				t.Errorf("%v at %v: expected the wrong phase to give poor results, got %.2f dB", pattern, origin, psnr)
+			}
+		}
+	}
+}
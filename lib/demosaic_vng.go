@@ -0,0 +1,138 @@
+package lib
+
+import "math"
+
+// Variable Number of Gradients demosaicing.  For each pixel, estimate the
+// gradient in each of 8 directions from a 5x5 neighborhood.  Average the
+// colors of the neighborhood in only those directions whose gradients fall
+// below a threshold, and use the averaged differences between channels to
+// fill in the pixel's missing channels.
+
+// Threshold = vngK1 * min gradient + vngK2 * (max gradient - min gradient)
+const (
+	vngK1 = 1.5
+	vngK2 = 0.5
+)
+
+type vngDirection struct {
+	dx, dy int
+}
+
+func (d vngDirection) diagonal() bool {
+	return (d.dx != 0) && (d.dy != 0)
+}
+
+// N, E, S, W, NE, SE, NW, SW
+var vngDirections = [8]vngDirection{
+	{0, -1}, {1, 0}, {0, 1}, {-1, 0},
+	{1, -1}, {1, 1}, {-1, -1}, {-1, 1},
+}
+
+// Get the gradient at (x, y) in direction d.
+func (m *cfaImage) vngGradient(x, y int, d vngDirection) float64 {
+	v := func(dx, dy int) float64 { return m.at(x+dx, y+dy) }
+	diff := func(dx0, dy0, dx1, dy1 int) float64 { return math.Abs(v(dx0, dy0) - v(dx1, dy1)) }
+
+	ux, uy := d.dx, d.dy
+	// Same-channel differences through the center.
+	result := diff(ux, uy, -ux, -uy) + diff(2*ux, 2*uy, 0, 0)
+	if !d.diagonal() {
+		// Perpendicular offset
+		px, py := uy, ux
+		result += (diff(px+ux, py+uy, px-ux, py-uy) + diff(-px+ux, -py+uy, -px-ux, -py-uy) +
+			diff(px+2*ux, py+2*uy, px, py) + diff(-px+2*ux, -py+2*uy, -px, -py)) / 2.0
+	} else if m.channel(x, y) == g {
+		result += diff(ux, 2*uy, -ux, 0) + diff(2*ux, uy, 0, -uy)
+	} else {
+		result += (diff(0, uy, -ux, 0) + diff(ux, 0, 0, -uy) +
+			diff(ux, 2*uy, 0, uy) + diff(2*ux, uy, ux, 0)) / 2.0
+	}
+	return result
+}
+
+// The neighborhood region lying in a direction, as offsets from the
+// center.  extra offsets are used only for a channel that has no samples
+// among offsets.
+type vngRegion struct {
+	offsets, extra [][2]int
+}
+
+// Regions of each of vngDirections
+var vngRegions = func() (result [8]vngRegion) {
+	for i, d := range vngDirections {
+		ux, uy := d.dx, d.dy
+		if d.diagonal() {
+			result[i].offsets = [][2]int{{0, 0}, {ux, uy}, {2 * ux, 2 * uy}, {ux, 0}, {0, uy}, {2 * ux, uy}, {ux, 2 * uy}}
+		} else {
+			px, py := uy, ux
+			result[i].offsets = [][2]int{{0, 0}, {ux, uy}, {2 * ux, 2 * uy}, {px + ux, py + uy}, {-px + ux, -py + uy}}
+			result[i].extra = [][2]int{{px, py}, {-px, -py}, {px + 2*ux, py + 2*uy}, {-px + 2*ux, -py + 2*uy}}
+		}
+	}
+	return
+}()
+
+// Get the mean of each channel over the region of (x, y)'s neighborhood
+// that lies in direction i.
+func (m *cfaImage) vngRegionMeans(x, y int, i int) [3]float64 {
+	offsets := vngRegions[i].offsets
+	extra := vngRegions[i].extra
+
+	sums := [3]float64{}
+	counts := [3]float64{}
+	for _, offset := range offsets {
+		channel := m.channel(x+offset[0], y+offset[1])
+		sums[channel] += m.at(x+offset[0], y+offset[1])
+		counts[channel] += 1.0
+	}
+	extraSums := [3]float64{}
+	extraCounts := [3]float64{}
+	for _, offset := range extra {
+		channel := m.channel(x+offset[0], y+offset[1])
+		extraSums[channel] += m.at(x+offset[0], y+offset[1])
+		extraCounts[channel] += 1.0
+	}
+
+	result := [3]float64{}
+	for channel := range result {
+		if counts[channel] > 0.0 {
+			result[channel] = sums[channel] / counts[channel]
+		} else if extraCounts[channel] > 0.0 {
+			result[channel] = extraSums[channel] / extraCounts[channel]
+		}
+	}
+	return result
+}
+
+func (m *cfaImage) vngRow(y int, row []float64) {
+	gradients := [8]float64{}
+	for x := 0; x < m.width; x++ {
+		minGradient := math.Inf(1)
+		maxGradient := math.Inf(-1)
+		for i, d := range vngDirections {
+			gradients[i] = m.vngGradient(x, y, d)
+			minGradient = math.Min(minGradient, gradients[i])
+			maxGradient = math.Max(maxGradient, gradients[i])
+		}
+		threshold := vngK1*minGradient + vngK2*(maxGradient-minGradient)
+
+		sums := [3]float64{}
+		n := 0.0
+		for i := range vngDirections {
+			if gradients[i] <= threshold {
+				means := m.vngRegionMeans(x, y, i)
+				for channel := range sums {
+					sums[channel] += means[channel]
+				}
+				n += 1.0
+			}
+		}
+
+		channel := m.channel(x, y)
+		v := m.at(x, y)
+		for other := 0; other < 3; other++ {
+			row[3*x+other] = v + (sums[other]-sums[channel])/n
+		}
+		row[3*x+channel] = v
+	}
+}
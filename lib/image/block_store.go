@@ -0,0 +1,293 @@
+package image
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"sync"
+)
+
+// BlockStore holds multi-channel float64 pixels in square blocks.  If the
+// blocks would exceed a memory budget, blocks that have not been used
+// recently are paged out to a scratch file, so that the store can be far
+// larger than available memory.  Each paged block has its own lock, so
+// that concurrent workers visiting different blocks don't contend.
+
+const DefaultBlockSize = 128
+
+// StorageOptions control how a BlockStore holds its pixels.
+type StorageOptions struct {
+	// Width and height of each block, in pixels.
+	BlockSize int
+	// Maximum bytes of blocks to hold in memory.  Zero means no limit, in
+	// which case nothing is paged.  The budget is raised if needed to hold
+	// one row of blocks plus one, so that row-by-row scans don't thrash.
+	MemoryBudget int64
+	// Directory for the scratch file.  "" means the default directory for
+	// temporary files.
+	ScratchDir string
+}
+
+func DefaultStorageOptions() StorageOptions {
+	return StorageOptions{BlockSize: DefaultBlockSize}
+}
+
+type storeBlock struct {
+	// Guards the fields below, if paged.
+	mutex sync.Mutex
+	// nil if the block is not in memory.
+	pix []float64
+	// Has the block ever been written to the scratch file?
+	onDisk bool
+	// Has the block changed since it was last written?
+	dirty bool
+	// Has the block been used since the clock hand last passed it?
+	referenced bool
+}
+
+type BlockStore struct {
+	rect         image.Rectangle
+	channels     int
+	blockSize    int
+	blocksAcross int
+	blocks       []storeBlock
+
+	// Paging state.  scratch is nil if all blocks are held in memory, in
+	// which case no locking is needed.  mutex guards loading and eviction,
+	// and is always acquired before any block's mutex.
+	mutex       sync.Mutex
+	scratch     *os.File
+	resident    []int
+	hand        int
+	maxResident int
+	buf         []byte
+	err         error
+}
+
+func NewBlockStore(rect image.Rectangle, channels int, opts StorageOptions) (*BlockStore, error) {
+	blockSize := opts.BlockSize
+	if blockSize < 1 {
+		blockSize = DefaultBlockSize
+	}
+	blocksAcross := (rect.Dx() + blockSize - 1) / blockSize
+	blocksDown := (rect.Dy() + blockSize - 1) / blockSize
+	numBlocks := blocksAcross * blocksDown
+	blockLen := blockSize * blockSize * channels
+
+	result := &BlockStore{
+		rect:         rect,
+		channels:     channels,
+		blockSize:    blockSize,
+		blocksAcross: blocksAcross,
+		blocks:       make([]storeBlock, numBlocks),
+	}
+
+	blockBytes := int64(blockLen) * 8
+	if (opts.MemoryBudget <= 0) || (opts.MemoryBudget >= int64(numBlocks)*blockBytes) {
+		for i := range result.blocks {
+			result.blocks[i].pix = make([]float64, blockLen)
+		}
+		return result, nil
+	}
+
+	scratch, err := os.CreateTemp(opts.ScratchDir, "blocks-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("creating scratch file: %w", err)
+	}
+	result.scratch = scratch
+	result.maxResident = int(opts.MemoryBudget / blockBytes)
+	if result.maxResident < blocksAcross+1 {
+		result.maxResident = blocksAcross + 1
+	}
+	result.buf = make([]byte, blockBytes)
+	return result, nil
+}
+
+func (s *BlockStore) Bounds() image.Rectangle { return s.rect }
+
+func (s *BlockStore) Channels() int { return s.channels }
+
+func (s *BlockStore) BlockSize() int { return s.blockSize }
+
+// Are blocks paged to a scratch file?
+func (s *BlockStore) Paged() bool { return s.scratch != nil }
+
+// Get the first error encountered while paging, if any.  Pixels read after
+// an error may be wrong.
+func (s *BlockStore) Err() error {
+	if s.scratch == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Release the store's scratch file.  The store must not be used afterward.
+func (s *BlockStore) Close() error {
+	if s.scratch == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := s.scratch.Name()
+	err := s.scratch.Close()
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	s.scratch = nil
+	s.blocks = nil
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// Get the block holding a pixel, and the offset of the pixel within the
+// block.  If paged, the block is loaded if necessary and returned locked;
+// the caller must unlock it.
+func (s *BlockStore) locate(x, y int) (*storeBlock, int) {
+	bx := (x - s.rect.Min.X) / s.blockSize
+	by := (y - s.rect.Min.Y) / s.blockSize
+	index := by*s.blocksAcross + bx
+	block := &s.blocks[index]
+	if s.scratch != nil {
+		block.mutex.Lock()
+		for block.pix == nil {
+			block.mutex.Unlock()
+			s.load(index)
+			block.mutex.Lock()
+		}
+		block.referenced = true
+	}
+	px := x - s.rect.Min.X - bx*s.blockSize
+	py := y - s.rect.Min.Y - by*s.blockSize
+	return block, (py*s.blockSize + px) * s.channels
+}
+
+// Bring a block into memory, if it isn't already.  If the budget is full,
+// a block that has not been used recently is evicted, using the clock
+// algorithm.
+func (s *BlockStore) load(index int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	block := &s.blocks[index]
+	block.mutex.Lock()
+	loaded := block.pix != nil
+	block.mutex.Unlock()
+	if loaded {
+		return
+	}
+
+	var pix []float64
+	if len(s.resident) >= s.maxResident {
+		for pix == nil {
+			evictIndex := s.resident[s.hand]
+			evicted := &s.blocks[evictIndex]
+			evicted.mutex.Lock()
+			if evicted.referenced {
+				evicted.referenced = false
+			} else {
+				if evicted.dirty {
+					s.writeBlock(evictIndex, evicted.pix)
+					evicted.onDisk = true
+					evicted.dirty = false
+				}
+				pix = evicted.pix
+				evicted.pix = nil
+				s.resident[s.hand] = index
+			}
+			evicted.mutex.Unlock()
+			s.hand = (s.hand + 1) % len(s.resident)
+		}
+	} else {
+		pix = make([]float64, s.blockSize*s.blockSize*s.channels)
+		s.resident = append(s.resident, index)
+	}
+
+	block.mutex.Lock()
+	defer block.mutex.Unlock()
+	if block.onDisk {
+		s.readBlock(index, pix)
+	} else {
+		for i := range pix {
+			pix[i] = 0.0
+		}
+	}
+	block.pix = pix
+}
+
+func (s *BlockStore) writeBlock(index int, pix []float64) {
+	for i, v := range pix {
+		binary.LittleEndian.PutUint64(s.buf[i*8:], math.Float64bits(v))
+	}
+	if _, err := s.scratch.WriteAt(s.buf, int64(index)*int64(len(s.buf))); (err != nil) && (s.err == nil) {
+		s.err = fmt.Errorf("writing block %d: %w", index, err)
+	}
+}
+
+func (s *BlockStore) readBlock(index int, pix []float64) {
+	if _, err := s.scratch.ReadAt(s.buf, int64(index)*int64(len(s.buf))); (err != nil) && (s.err == nil) {
+		s.err = fmt.Errorf("reading block %d: %w", index, err)
+	}
+	for i := range pix {
+		pix[i] = math.Float64frombits(binary.LittleEndian.Uint64(s.buf[i*8:]))
+	}
+}
+
+// Get one channel of a pixel.  Pixels outside the store are zero.
+func (s *BlockStore) Value(x, y, channel int) float64 {
+	if !(image.Point{x, y}.In(s.rect)) {
+		return 0.0
+	}
+	block, offset := s.locate(x, y)
+	result := block.pix[offset+channel]
+	if s.scratch != nil {
+		block.mutex.Unlock()
+	}
+	return result
+}
+
+func (s *BlockStore) SetValue(x, y, channel int, v float64) {
+	if !(image.Point{x, y}.In(s.rect)) {
+		return
+	}
+	block, offset := s.locate(x, y)
+	block.pix[offset+channel] = v
+	if s.scratch != nil {
+		block.dirty = true
+		block.mutex.Unlock()
+	}
+}
+
+// Copy all channels of a pixel into dest.  Pixels outside the store are
+// zero.
+func (s *BlockStore) Pixel(x, y int, dest []float64) {
+	if !(image.Point{x, y}.In(s.rect)) {
+		for i := range dest[:s.channels] {
+			dest[i] = 0.0
+		}
+		return
+	}
+	block, offset := s.locate(x, y)
+	copy(dest[:s.channels], block.pix[offset:offset+s.channels])
+	if s.scratch != nil {
+		block.mutex.Unlock()
+	}
+}
+
+// Set all channels of a pixel from src.
+func (s *BlockStore) SetPixel(x, y int, src []float64) {
+	if !(image.Point{x, y}.In(s.rect)) {
+		return
+	}
+	block, offset := s.locate(x, y)
+	copy(block.pix[offset:offset+s.channels], src[:s.channels])
+	if s.scratch != nil {
+		block.dirty = true
+		block.mutex.Unlock()
+	}
+}
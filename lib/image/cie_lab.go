@@ -89,22 +89,93 @@ func NewCIELab(r image.Rectangle) *CIELab {
 	return &CIELab{Pix: pix, Stride: channels * r.Dx(), Rect: r}
 }
 
-// Create a CIELab from an image.RGBA.
-// The image origin will be at 0, 0.
+// Create a CIELab from an image, using one worker per CPU.
+// The result has the same bounds as the source image.
 func CIELabFromImage(src image.Image) *CIELab {
+	return CIELabFromImageParallel(src, 0)
+}
+
+// Create a CIELab from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.
+func CIELabFromImageParallel(src image.Image, workers int) *CIELab {
 	rect := src.Bounds()
 	result := NewCIELab(rect)
 
-	offset := 0
-	for y := rect.Min.Y; y < rect.Max.Y; y++ {
-		for x := rect.Min.X; x < rect.Max.X; x++ {
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		switch s := src.(type) {
+		case *image.RGBA:
+			convertRGBARows(result, s, band)
+		case *image.NRGBA:
+			convertNRGBARows(result, s, band)
+		default:
+			convertRows(result, src, band)
+		}
+	})
+	return result
+}
+
+func (p *CIELab) setLabFromRGB(offset int, r, g, b uint32) {
+	labL, laba, labb := lib_color.RGBToCIELab(r, g, b)
+	p.Pix[offset] = labL
+	p.Pix[offset+1] = laba
+	p.Pix[offset+2] = labb
+}
+
+func convertRows(dest *CIELab, src image.Image, band image.Rectangle) {
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		offset := dest.PixOffset(band.Min.X, y)
+		for x := band.Min.X; x < band.Max.X; x++ {
 			r, g, b, _ := src.At(x, y).RGBA()
-			labL, laba, labb := lib_color.RGBToCIELab(r, g, b)
-			result.Pix[offset] = labL
-			result.Pix[offset+1] = laba
-			result.Pix[offset+2] = labb
+			dest.setLabFromRGB(offset, r, g, b)
 			offset += 3
 		}
 	}
-	return result
+}
+
+// Convert without going through color.Color.  The results match those
+// of color.RGBA.RGBA.
+func convertRGBARows(dest *CIELab, src *image.RGBA, band image.Rectangle) {
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		offset := dest.PixOffset(band.Min.X, y)
+		s := src.PixOffset(band.Min.X, y)
+		for x := band.Min.X; x < band.Max.X; x++ {
+			labL, laba, labb := lib_color.RGB8ToCIELab(src.Pix[s], src.Pix[s+1], src.Pix[s+2])
+			dest.Pix[offset] = labL
+			dest.Pix[offset+1] = laba
+			dest.Pix[offset+2] = labb
+			offset += 3
+			s += 4
+		}
+	}
+}
+
+// Convert without going through color.Color.  The results match those
+// of color.NRGBA.RGBA, which premultiplies by alpha.
+func convertNRGBARows(dest *CIELab, src *image.NRGBA, band image.Rectangle) {
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		offset := dest.PixOffset(band.Min.X, y)
+		s := src.PixOffset(band.Min.X, y)
+		for x := band.Min.X; x < band.Max.X; x++ {
+			if src.Pix[s+3] == 0xff {
+				labL, laba, labb := lib_color.RGB8ToCIELab(src.Pix[s], src.Pix[s+1], src.Pix[s+2])
+				dest.Pix[offset] = labL
+				dest.Pix[offset+1] = laba
+				dest.Pix[offset+2] = labb
+				offset += 3
+				s += 4
+				continue
+			}
+			r := uint32(src.Pix[s])
+			g := uint32(src.Pix[s+1])
+			b := uint32(src.Pix[s+2])
+			a := uint32(src.Pix[s+3])
+			r |= r << 8
+			g |= g << 8
+			b |= b << 8
+			a |= a << 8
+			dest.setLabFromRGB(offset, r*a/0xffff, g*a/0xffff, b*a/0xffff)
+			offset += 3
+			s += 4
+		}
+	}
 }
@@ -0,0 +1,97 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// CIELCh is an image of lib_color.CIELCh pixels, with hue in degrees.
+type CIELCh struct {
+	// Pix, Stride, Rect
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *CIELCh) ColorModel() color.Model { return lib_color.CIELChModel }
+
+func (p *CIELCh) Bounds() image.Rectangle { return p.Rect }
+
+func (p *CIELCh) At(x, y int) color.Color {
+	return p.CIELChAt(x, y)
+}
+
+func (p *CIELCh) CIELChAt(x, y int) lib_color.CIELCh {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return lib_color.CIELCh{}
+	}
+	i := p.PixOffset(x, y)
+	return lib_color.CIELCh{L: p.Pix[i], C: p.Pix[i+1], H: p.Pix[i+2]}
+}
+
+func (p *CIELCh) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+func (p *CIELCh) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.SetCIELCh(x, y, lib_color.CIELChModel.Convert(c).(lib_color.CIELCh))
+}
+
+func (p *CIELCh) SetCIELCh(x, y int, c lib_color.CIELCh) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0] = c.L
+	s[1] = c.C
+	s[2] = c.H
+}
+
+func (p *CIELCh) SubImage(rect image.Rectangle) *CIELCh {
+	// This is taken from the implementation of NRGBA's SubImage.
+	r := rect.Intersect(p.Rect)
+	if r.Empty() {
+		return &CIELCh{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &CIELCh{
+		Pix:    p.Pix[i:], // <- Those who choose to access Pix directly can overrun the image.
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+func (p *CIELCh) Opaque() bool {
+	return true
+}
+
+func NewCIELCh(r image.Rectangle) *CIELCh {
+	area := r.Dx() * r.Dy()
+	channels := 3 // L, C, H
+	bufferSize := area * channels
+	pix := make([]float64, bufferSize)
+	return &CIELCh{Pix: pix, Stride: channels * r.Dx(), Rect: r}
+}
+
+// Create a CIELCh from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.  The result has the same bounds as the
+// source image.
+func CIELChFromImage(src image.Image, workers int) *CIELCh {
+	rect := src.Bounds()
+	result := NewCIELCh(rect)
+
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				result.Set(x, y, src.At(x, y))
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,98 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// CIELuv is an image of lib_color.CIELuv pixels, relative to the D65 white
+// point.
+type CIELuv struct {
+	// Pix, Stride, Rect
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *CIELuv) ColorModel() color.Model { return lib_color.CIELuvModel }
+
+func (p *CIELuv) Bounds() image.Rectangle { return p.Rect }
+
+func (p *CIELuv) At(x, y int) color.Color {
+	return p.CIELuvAt(x, y)
+}
+
+func (p *CIELuv) CIELuvAt(x, y int) lib_color.CIELuv {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return lib_color.CIELuv{}
+	}
+	i := p.PixOffset(x, y)
+	return lib_color.CIELuv{L: p.Pix[i], U: p.Pix[i+1], V: p.Pix[i+2]}
+}
+
+func (p *CIELuv) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+func (p *CIELuv) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.SetCIELuv(x, y, lib_color.CIELuvModel.Convert(c).(lib_color.CIELuv))
+}
+
+func (p *CIELuv) SetCIELuv(x, y int, c lib_color.CIELuv) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0] = c.L
+	s[1] = c.U
+	s[2] = c.V
+}
+
+func (p *CIELuv) SubImage(rect image.Rectangle) *CIELuv {
+	// This is taken from the implementation of NRGBA's SubImage.
+	r := rect.Intersect(p.Rect)
+	if r.Empty() {
+		return &CIELuv{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &CIELuv{
+		Pix:    p.Pix[i:], // <- Those who choose to access Pix directly can overrun the image.
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+func (p *CIELuv) Opaque() bool {
+	return true
+}
+
+func NewCIELuv(r image.Rectangle) *CIELuv {
+	area := r.Dx() * r.Dy()
+	channels := 3 // L, u, v
+	bufferSize := area * channels
+	pix := make([]float64, bufferSize)
+	return &CIELuv{Pix: pix, Stride: channels * r.Dx(), Rect: r}
+}
+
+// Create a CIELuv from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.  The result has the same bounds as the
+// source image.
+func CIELuvFromImage(src image.Image, workers int) *CIELuv {
+	rect := src.Bounds()
+	result := NewCIELuv(rect)
+
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				result.Set(x, y, src.At(x, y))
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,163 @@
+package color
+
+import "fmt"
+
+// Chromatic adaptation maps colors seen under one illuminant to the colors
+// that look the same under another.  Each method converts XYZ to a cone
+// response space, scales each cone response by the ratio of the two
+// illuminants' responses, and converts back.  See
+// http://www.brucelindbloom.com/index.html?Eqn_ChromAdapt.html
+
+// Reference white points, normalized to Y = 1.
+var (
+	WhiteD65 = CIEXYZ{X: d65IllumX, Y: d65IllumY, Z: d65IllumZ}
+	WhiteD50 = CIEXYZ{X: 0.96422, Y: 1.0, Z: 0.82521}
+	// Incandescent light
+	WhiteA = CIEXYZ{X: 1.09850, Y: 1.0, Z: 0.35585}
+	// A warm white, on the blackbody locus near 4000 K, approximating the
+	// butterscotch daylight at the surface of Mars.  It is an aesthetic
+	// target rather than a measurement.
+	WhiteMarsDaylight = WhiteFromChromaticity(0.3805, 0.3768)
+)
+
+// Get the white point, normalized to Y = 1, with CIE xy chromaticity
+// (x, y).
+func WhiteFromChromaticity(x, y float64) CIEXYZ {
+	return CIEXYZ{X: x / y, Y: 1.0, Z: (1.0 - x - y) / y}
+}
+
+// Get the CIE xy chromaticity of a color.  Black has the chromaticity of
+// D65.
+func (c CIEXYZ) Chromaticity() (x, y float64) {
+	sum := c.X + c.Y + c.Z
+	if sum <= 0.0 {
+		return WhiteD65.Chromaticity()
+	}
+	return c.X / sum, c.Y / sum
+}
+
+// AdaptationMethod selects the cone response space in which chromatic
+// adaptation is done.
+type AdaptationMethod int
+
+const (
+	AdaptBradford AdaptationMethod = iota
+	AdaptVonKries
+	AdaptCAT02
+)
+
+var adaptationMethodNames = map[AdaptationMethod]string{
+	AdaptBradford: "bradford",
+	AdaptVonKries: "vonkries",
+	AdaptCAT02:    "cat02",
+}
+
+func (method AdaptationMethod) String() string {
+	if name, ok := adaptationMethodNames[method]; ok {
+		return name
+	}
+	return fmt.Sprintf("AdaptationMethod(%d)", int(method))
+}
+
+// Marshal the method by name, e.g., in JSON.
+func (method AdaptationMethod) MarshalText() ([]byte, error) {
+	return []byte(method.String()), nil
+}
+
+// Get the AdaptationMethod with a given name, e.g., "bradford".
+func ParseAdaptationMethod(name string) (AdaptationMethod, error) {
+	for method, methodName := range adaptationMethodNames {
+		if methodName == name {
+			return method, nil
+		}
+	}
+	return AdaptBradford, fmt.Errorf("unknown adaptation method %q", name)
+}
+
+type matrix3 [3][3]float64
+
+func (m matrix3) apply(v [3]float64) (result [3]float64) {
+	for i := range result {
+		result[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return
+}
+
+func (m matrix3) mul(n matrix3) (result matrix3) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return
+}
+
+// Invert m, which must not be singular, by its adjugate.
+func (m matrix3) inverse() (result matrix3) {
+	cofactor := func(i, j int) float64 {
+		r0, r1 := (i+1)%3, (i+2)%3
+		c0, c1 := (j+1)%3, (j+2)%3
+		return m[r0][c0]*m[r1][c1] - m[r0][c1]*m[r1][c0]
+	}
+	det := m[0][0]*cofactor(0, 0) + m[0][1]*cofactor(0, 1) + m[0][2]*cofactor(0, 2)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			result[j][i] = cofactor(i, j) / det
+		}
+	}
+	return
+}
+
+// Matrices from XYZ to each method's cone response space
+var coneResponses = map[AdaptationMethod]matrix3{
+	AdaptBradford: {
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	},
+	// Hunt-Pointer-Estevez, normalized to D65
+	AdaptVonKries: {
+		{0.40024, 0.70760, -0.08081},
+		{-0.22630, 1.16532, 0.04570},
+		{0.0, 0.0, 0.91822},
+	},
+	AdaptCAT02: {
+		{0.7328, 0.4296, -0.1624},
+		{-0.7036, 1.6975, 0.0061},
+		{0.0030, 0.0136, 0.9834},
+	},
+}
+
+// ChromaticAdaptation maps XYZ colors seen under a source illuminant to
+// the corresponding colors under a destination illuminant.
+type ChromaticAdaptation struct {
+	m matrix3
+}
+
+// Create an adaptation from the illuminant with white point src to the
+// one with white point dest.  Unknown methods use Bradford.
+func NewChromaticAdaptation(method AdaptationMethod, src, dest CIEXYZ) ChromaticAdaptation {
+	cone, ok := coneResponses[method]
+	if !ok {
+		cone = coneResponses[AdaptBradford]
+	}
+	srcCone := cone.apply([3]float64{src.X, src.Y, src.Z})
+	destCone := cone.apply([3]float64{dest.X, dest.Y, dest.Z})
+	scale := matrix3{}
+	for i := range scale {
+		scale[i][i] = destCone[i] / srcCone[i]
+	}
+	return ChromaticAdaptation{cone.inverse().mul(scale).mul(cone)}
+}
+
+func (ca ChromaticAdaptation) Apply(c CIEXYZ) CIEXYZ {
+	v := ca.m.apply([3]float64{c.X, c.Y, c.Z})
+	return CIEXYZ{X: v[0], Y: v[1], Z: v[2]}
+}
+
+// Get the adaptation's matrix, which multiplies XYZ column vectors.
+func (ca ChromaticAdaptation) Matrix() [3][3]float64 {
+	return ca.m
+}
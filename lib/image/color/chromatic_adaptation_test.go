@@ -0,0 +1,98 @@
+package color
+
+import (
+	"math"
+	"testing"
+)
+
+func xyzNear(a, b CIEXYZ, tolerance float64) bool {
+	return (math.Abs(a.X-b.X) <= tolerance) && (math.Abs(a.Y-b.Y) <= tolerance) && (math.Abs(a.Z-b.Z) <= tolerance)
+}
+
+func TestChromaticAdaptationMapsWhites(t *testing.T) {
+	whites := []CIEXYZ{WhiteD65, WhiteD50, WhiteA, WhiteMarsDaylight}
+	for method := range adaptationMethodNames {
+		for _, src := range whites {
+			for _, dest := range whites {
+				ca := NewChromaticAdaptation(method, src, dest)
+				if got := ca.Apply(src); !xyzNear(got, dest, 1.0e-9) {
+					t.Errorf("%v: expected %v to map to %v, got %v", method, src, dest, got)
+				}
+				// Adapting back recovers the original color.
+				back := NewChromaticAdaptation(method, dest, src)
+				color := CIEXYZ{X: 0.3, Y: 0.25, Z: 0.4}
+				if got := back.Apply(ca.Apply(color)); !xyzNear(got, color, 1.0e-9) {
+					t.Errorf("%v: expected round trip to give %v, got %v", method, color, got)
+				}
+			}
+		}
+	}
+}
+
+// Bradford D65 to D50, from http://www.brucelindbloom.com
+func TestBradfordD65ToD50(t *testing.T) {
+	want := [3][3]float64{
+		{1.0478112, 0.0228866, -0.0501270},
+		{0.0295424, 0.9904844, -0.0170491},
+		{-0.0092345, 0.0150436, 0.7521316},
+	}
+	got := NewChromaticAdaptation(AdaptBradford, WhiteD65, WhiteD50).Matrix()
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(got[i][j]-want[i][j]) > 1.0e-4 {
+				t.Fatalf("Expected %v, got %v", want, got)
+			}
+		}
+	}
+}
+
+func TestParseAdaptationMethod(t *testing.T) {
+	for method := range adaptationMethodNames {
+		parsed, err := ParseAdaptationMethod(method.String())
+		if (err != nil) || (parsed != method) {
+			t.Errorf("Expected %v, got %v (%v)", method, parsed, err)
+		}
+	}
+	if _, err := ParseAdaptationMethod("bogus"); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
+
+func TestChromaticity(t *testing.T) {
+	testCases := []struct {
+		white CIEXYZ
+		x, y  float64
+	}{
+		{WhiteD65, 0.3127, 0.3290},
+		{WhiteD50, 0.3457, 0.3585},
+		{WhiteA, 0.4476, 0.4074},
+		{WhiteMarsDaylight, 0.3805, 0.3768},
+	}
+	for _, tc := range testCases {
+		x, y := tc.white.Chromaticity()
+		if (math.Abs(x-tc.x) > 1.0e-4) || (math.Abs(y-tc.y) > 1.0e-4) {
+			t.Errorf("Expected %v to have chromaticity (%v, %v), got (%v, %v)", tc.white, tc.x, tc.y, x, y)
+		}
+		if got := WhiteFromChromaticity(x, y); !xyzNear(got, tc.white, 1.0e-9) {
+			t.Errorf("Expected %v, got %v", tc.white, got)
+		}
+	}
+}
+
+func TestExportedConversionsRoundTrip(t *testing.T) {
+	for _, tc := range xyzTestCases {
+		xyz := LinearRGBToCIEXYZ(SRGBToLinear(norm(tc.r)), SRGBToLinear(norm(tc.g)), SRGBToLinear(norm(tc.b)))
+		if !(eq(xyz.X, tc.x) && eq(xyz.Y, tc.y) && eq(xyz.Z, tc.z)) {
+			t.Errorf("%v: expected %v, got %v", hexTupleStr(tc.r, tc.g, tc.b), fTupleStr(tc.x, tc.y, tc.z), xyz)
+		}
+		nr, ng, nb := xyz.LinearRGB()
+		for _, pair := range [][2]float64{{nr, SRGBToLinear(norm(tc.r))}, {ng, SRGBToLinear(norm(tc.g))}, {nb, SRGBToLinear(norm(tc.b))}} {
+			if math.Abs(LinearToSRGB(pair[0])-LinearToSRGB(pair[1])) > 1.0e-6 {
+				t.Errorf("%v: linear RGB round trip gave (%v, %v, %v)", hexTupleStr(tc.r, tc.g, tc.b), nr, ng, nb)
+			}
+		}
+		if back := xyz.CIELab().CIEXYZ(); !xyzNear(back, xyz, 1.0e-9) {
+			t.Errorf("%v: Lab round trip gave %v", xyz, back)
+		}
+	}
+}
@@ -32,6 +32,18 @@ func cieLabModel(c color.Color) color.Color {
 
 var CIELabModel color.Model = color.ModelFunc(cieLabModel)
 
+// Get the XYZ color of a Lab color, relative to the D65 white point.
+func (c CIELab) CIEXYZ() CIEXYZ {
+	x, y, z := cieLabD65ToXYZ(c.L, c.A, c.B)
+	return CIEXYZ{X: x, Y: y, Z: z}
+}
+
+// Get the Lab color, relative to the D65 white point, of an XYZ color.
+func (c CIEXYZ) CIELab() CIELab {
+	labL, laba, labb := cieXYZToLabD65(c.X, c.Y, c.Z)
+	return CIELab{L: labL, A: laba, B: labb}
+}
+
 func cieLabToRGB(labL, laba, labb float64) (r, g, b uint32) {
 	x, y, z := cieLabD65ToXYZ(labL, laba, labb)
 	r, g, b = cieXYZToRGB(x, y, z)
@@ -44,6 +56,24 @@ func RGBToCIELab(r, g, b uint32) (labL, laba, labb float64) {
 	return
 }
 
+// Gamma-expanded values of 8-bit sRGB components, widened to 16 bits as
+// color.RGBA.RGBA does.
+var linear8 = func() (result [256]float64) {
+	for i := range result {
+		v := uint32(i)
+		result[i] = gammaExpanded(norm(v | v<<8))
+	}
+	return
+}()
+
+// Convert 8-bit sRGB to CIE Lab.  The result matches that of RGBToCIELab
+// for the same components widened to 16 bits, but is faster.
+func RGB8ToCIELab(r, g, b uint8) (labL, laba, labb float64) {
+	x, y, z := linearRGBToCIEXYZ(linear8[r], linear8[g], linear8[b])
+	labL, laba, labb = cieXYZToLabD65(x, y, z)
+	return
+}
+
 const cieE = 216.0 / 24389.0
 
 // Caveat: these match python colormath, rather than Wikipedia.
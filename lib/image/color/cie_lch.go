@@ -0,0 +1,47 @@
+package color
+
+import (
+	"image/color"
+	"math"
+)
+
+// CIELCh represents CIE L*a*b* in cylindrical coordinates: lightness,
+// chroma, and hue angle in degrees, 0.0 ... 360.0.  Like CIELab it assumes
+// a 2° observer and the D65 illuminant.
+type CIELCh struct {
+	L, C, H float64
+}
+
+func (c CIELCh) RGBA() (r, g, b, a uint32) {
+	return c.CIELab().RGBA()
+}
+
+func cieLChModel(c color.Color) color.Color {
+	if _, ok := c.(CIELCh); ok {
+		return c
+	}
+	return cieLabModel(c).(CIELab).LCh()
+}
+
+var CIELChModel color.Model = color.ModelFunc(cieLChModel)
+
+// Get the cylindrical form of a Lab color.
+func (c CIELab) LCh() CIELCh {
+	chroma, hue := rectToPolar(c.A, c.B)
+	return CIELCh{L: c.L, C: chroma, H: hue}
+}
+
+func (c CIELCh) CIELab() CIELab {
+	a, b := polarToRect(c.C, c.H)
+	return CIELab{L: c.L, A: a, B: b}
+}
+
+// Get the magnitude and angle, in degrees 0.0 ... 360.0, of (x, y).
+func rectToPolar(x, y float64) (magnitude, degrees float64) {
+	return math.Hypot(x, y), posRadToDeg(math.Atan2(y, x))
+}
+
+func polarToRect(magnitude, degrees float64) (x, y float64) {
+	radians := degToRad(degrees)
+	return magnitude * math.Cos(radians), magnitude * math.Sin(radians)
+}
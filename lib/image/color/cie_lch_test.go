@@ -0,0 +1,44 @@
+package color
+
+import (
+	"image/color"
+	"testing"
+)
+
+// sRGB primaries, from http://www.brucelindbloom.com
+var rgbToLChTestCases = []struct {
+	r, g, b uint32
+	l, c, h float64
+}{
+	{0xffff, 0x0000, 0x0000, 53.24, 104.55, 40.00},
+	{0x0000, 0xffff, 0x0000, 87.73, 119.78, 136.02},
+	{0x0000, 0x0000, 0xffff, 32.30, 133.81, 306.28},
+	{0xffff, 0xffff, 0x0000, 97.14, 96.91, 102.85},
+}
+
+func TestRGBToLCh(t *testing.T) {
+	for _, tc := range rgbToLChTestCases {
+		name := hexTupleStr(tc.r, tc.g, tc.b)
+		t.Run(name, func(t *testing.T) {
+			src := color.RGBA64{R: uint16(tc.r), G: uint16(tc.g), B: uint16(tc.b), A: 0xffff}
+			got := CIELChModel.Convert(src).(CIELCh)
+			if !(near(got.L, tc.l, 0.02) && near(got.C, tc.c, 0.02) && nearDegrees(got.H, tc.h, 0.02)) {
+				t.Errorf("want %v, got %v", fTupleStr(tc.l, tc.c, tc.h), fTupleStr(got.L, got.C, got.H))
+			}
+		})
+	}
+}
+
+func TestLabToLChRoundTrip(t *testing.T) {
+	for _, tc := range rgbToLabTestCases {
+		lab := CIELab{L: tc.labL, A: tc.laba, B: tc.labb}
+		lch := lab.LCh()
+		if (lch.H < 0.0) || (lch.H >= 360.0) {
+			t.Errorf("%v: expected hue in [0, 360), got %v", lab, lch.H)
+		}
+		back := lch.CIELab()
+		if !(near(back.L, lab.L, 1.0e-9) && near(back.A, lab.A, 1.0e-9) && near(back.B, lab.B, 1.0e-9)) {
+			t.Errorf("%v -> %v -> %v", lab, lch, back)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package color
+
+import (
+	"image/color"
+	"math"
+)
+
+// CIELuv represents the CIE L*u*v* colorspace, 2° observer, D65 illuminant.
+// See https://en.wikipedia.org/wiki/CIELUV
+type CIELuv struct {
+	L, U, V float64
+}
+
+func (c CIELuv) RGBA() (r, g, b, a uint32) {
+	return c.CIEXYZ().RGBA()
+}
+
+func cieLuvModel(c color.Color) color.Color {
+	if _, ok := c.(CIELuv); ok {
+		return c
+	}
+	return cieXYZModel(c).(CIEXYZ).CIELuv()
+}
+
+var CIELuvModel color.Model = color.ModelFunc(cieLuvModel)
+
+const cieK = 24389.0 / 27.0
+
+// Get the u'v' chromaticity of an XYZ color.  Black has the chromaticity
+// of D65.
+func uvPrime(x, y, z float64) (u, v float64) {
+	denom := x + 15.0*y + 3.0*z
+	if denom <= 0.0 {
+		return uvPrime(d65IllumX, d65IllumY, d65IllumZ)
+	}
+	return 4.0 * x / denom, 9.0 * y / denom
+}
+
+// Get the Luv color, relative to the D65 white point, of an XYZ color.
+func (c CIEXYZ) CIELuv() CIELuv {
+	yr := c.Y / d65IllumY
+	labL := cieK * yr
+	if yr > cieE {
+		labL = 116.0*math.Cbrt(yr) - 16.0
+	}
+	u, v := uvPrime(c.X, c.Y, c.Z)
+	un, vn := uvPrime(d65IllumX, d65IllumY, d65IllumZ)
+	return CIELuv{L: labL, U: 13.0 * labL * (u - un), V: 13.0 * labL * (v - vn)}
+}
+
+// Get the XYZ color of a Luv color, relative to the D65 white point.
+func (c CIELuv) CIEXYZ() CIEXYZ {
+	if c.L <= 0.0 {
+		return CIEXYZ{}
+	}
+	y := c.L / cieK
+	if c.L > cieK*cieE {
+		t := (c.L + 16.0) / 116.0
+		y = t * t * t
+	}
+	y *= d65IllumY
+
+	un, vn := uvPrime(d65IllumX, d65IllumY, d65IllumZ)
+	u := c.U/(13.0*c.L) + un
+	v := c.V/(13.0*c.L) + vn
+	return CIEXYZ{X: y * 9.0 * u / (4.0 * v), Y: y, Z: y * (12.0 - 3.0*u - 20.0*v) / (4.0 * v)}
+}
@@ -0,0 +1,42 @@
+package color
+
+import (
+	"image/color"
+	"testing"
+)
+
+// From http://www.brucelindbloom.com, D65 reference white
+var rgbToLuvTestCases = []struct {
+	r, g, b uint32
+	l, u, v float64
+}{
+	{0x0000, 0x0000, 0x0000, 0.0, 0.0, 0.0},
+	{0xffff, 0xffff, 0xffff, 100.0, 0.0, 0.0},
+	{0xffff, 0x0000, 0x0000, 53.24, 175.01, 37.76},
+	{0x0000, 0xffff, 0x0000, 87.73, -83.08, 107.40},
+	{0x0000, 0x0000, 0xffff, 32.30, -9.41, -130.35},
+}
+
+func TestRGBToLuv(t *testing.T) {
+	for _, tc := range rgbToLuvTestCases {
+		name := hexTupleStr(tc.r, tc.g, tc.b)
+		t.Run(name, func(t *testing.T) {
+			src := color.RGBA64{R: uint16(tc.r), G: uint16(tc.g), B: uint16(tc.b), A: 0xffff}
+			got := CIELuvModel.Convert(src).(CIELuv)
+			if !(near(got.L, tc.l, 0.05) && near(got.U, tc.u, 0.05) && near(got.V, tc.v, 0.05)) {
+				t.Errorf("want %v, got %v", fTupleStr(tc.l, tc.u, tc.v), fTupleStr(got.L, got.U, got.V))
+			}
+		})
+	}
+}
+
+func TestXYZToLuvRoundTrip(t *testing.T) {
+	for _, tc := range xyzTestCases {
+		xyz := CIEXYZ{X: tc.x, Y: tc.y, Z: tc.z}
+		luv := xyz.CIELuv()
+		back := luv.CIEXYZ()
+		if !(near(back.X, xyz.X, 1.0e-9) && near(back.Y, xyz.Y, 1.0e-9) && near(back.Z, xyz.Z, 1.0e-9)) {
+			t.Errorf("%v -> %v -> %v", xyz, luv, back)
+		}
+	}
+}
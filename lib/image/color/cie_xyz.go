@@ -46,11 +46,34 @@ func gammaExpanded(u float64) float64 {
 	return math.Pow(uScaled, 2.4)
 }
 
+// Gamma-expand an sRGB component in [0, 1] to linear light.
+func SRGBToLinear(u float64) float64 {
+	return gammaExpanded(u)
+}
+
+// Gamma-compress a linear sRGB component to [0, 1], clamping.
+func LinearToSRGB(u float64) float64 {
+	return gammaCompressed(u)
+}
+
+// Get the XYZ color of linear sRGB components, which are nominally in
+// [0, 1].
+func LinearRGBToCIEXYZ(r, g, b float64) CIEXYZ {
+	x, y, z := linearRGBToCIEXYZ(r, g, b)
+	return CIEXYZ{X: x, Y: y, Z: z}
+}
+
+// Get the linear sRGB components of an XYZ color.  Out-of-gamut colors
+// give components outside [0, 1].
+func (c CIEXYZ) LinearRGB() (r, g, b float64) {
+	return cieXYZToLinearRGB(c.X, c.Y, c.Z)
+}
+
 func rgbToCIEXYZ(r, g, b uint32) (x, y, z float64) {
-	nr := gammaExpanded(norm(r))
-	ng := gammaExpanded(norm(g))
-	nb := gammaExpanded(norm(b))
+	return linearRGBToCIEXYZ(gammaExpanded(norm(r)), gammaExpanded(norm(g)), gammaExpanded(norm(b)))
+}
 
+func linearRGBToCIEXYZ(nr, ng, nb float64) (x, y, z float64) {
 	x = 0.41239080*nr + 0.35758434*ng + 0.18048079*nb
 	y = 0.21263901*nr + 0.71516868*ng + 0.07219232*nb
 	z = 0.01933082*nr + 0.11919478*ng + 0.95053215*nb
@@ -74,10 +97,15 @@ func gammaCompressed(u float64) float64 {
 	return result
 }
 
+func cieXYZToLinearRGB(x, y, z float64) (nr, ng, nb float64) {
+	nr = 3.24096994*x + -1.53738318*y + -0.49861076*z
+	ng = -0.96924364*x + 1.8759675*y + 0.04155506*z
+	nb = 0.05563008*x + -0.20397696*y + 1.05697151*z
+	return
+}
+
 func cieXYZToRGB(x, y, z float64) (r, g, b uint32) {
-	nr := 3.24096994*x + -1.53738318*y + -0.49861076*z
-	ng := -0.96924364*x + 1.8759675*y + 0.04155506*z
-	nb := 0.05563008*x + -0.20397696*y + 1.05697151*z
+	nr, ng, nb := cieXYZToLinearRGB(x, y, z)
 
 	gr := gammaCompressed(nr)
 	gg := gammaCompressed(ng)
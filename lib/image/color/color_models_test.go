@@ -0,0 +1,53 @@
+package color
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// Is a within tolerance of b?
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// Angles in degrees, which wrap around at 360.
+func nearDegrees(a, b, tolerance float64) bool {
+	diff := math.Mod(math.Abs(a-b), 360.0)
+	return math.Min(diff, 360.0-diff) <= tolerance
+}
+
+var roundTripModels = []struct {
+	name  string
+	model color.Model
+}{
+	{"LinearRGB", LinearRGBModel},
+	{"HSL", HSLModel},
+	{"CIELCh", CIELChModel},
+	{"CIELuv", CIELuvModel},
+	{"Oklab", OklabModel},
+	{"OkLCh", OkLChModel},
+}
+
+func TestModelsRoundTrip(t *testing.T) {
+	for _, m := range roundTripModels {
+		for _, tc := range xyzTestCases {
+			name := m.name + hexTupleStr(tc.r, tc.g, tc.b)
+			t.Run(name, func(t *testing.T) {
+				src := color.RGBA64{R: uint16(tc.r), G: uint16(tc.g), B: uint16(tc.b), A: 0xffff}
+				converted := m.model.Convert(src)
+				r, g, b, a := converted.RGBA()
+				if !(eq_u(r, tc.r) && eq_u(g, tc.g) && eq_u(b, tc.b)) {
+					t.Errorf("%v -> %v -> %v", hexTupleStr(tc.r, tc.g, tc.b), converted, hexTupleStr(r, g, b))
+				}
+				if a != 0xffff {
+					t.Errorf("Expected full opaque alpha 0xffff, got %v", a)
+				}
+				// Converting to the model again changes nothing.
+				if again := m.model.Convert(converted); again != converted {
+					t.Errorf("Expected %v, got %v", converted, again)
+				}
+			})
+		}
+	}
+}
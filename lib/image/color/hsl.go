@@ -0,0 +1,74 @@
+package color
+
+import (
+	"image/color"
+	"math"
+)
+
+// HSL represents hue, saturation and lightness.  Like HSV, all components
+// are in the range 0.0 ... 1.0.
+type HSL struct {
+	H, S, L float64
+}
+
+// Conform to color.Color interface:
+func (c HSL) RGBA() (r, g, b, a uint32) {
+	r, g, b = hslToRGB(c.H, c.S, c.L)
+	a = 0xffff
+	return
+}
+
+// Conform to color.ColorModel:
+func hslModel(c color.Color) color.Color {
+	if _, ok := c.(HSL); ok {
+		return c
+	}
+	r, g, b, _ := c.RGBA()
+	h, s, l := rgbToHSL(r, g, b)
+	return HSL{h, s, l}
+}
+
+var HSLModel color.Model = color.ModelFunc(hslModel)
+
+// Convert "normalized" RGB, with all components in 0.0 ... 1.0, to HSL.
+// Returns values in the range 0.0 ... 1.0
+func normRGBToHSL(rn, gn, bn float64) (float64, float64, float64) {
+	// This derives from Wikipedia.
+	maxComp := max(rn, gn, bn)
+	minComp := min(rn, gn, bn)
+
+	h := rgbToHue(rn, gn, bn, minComp, maxComp)
+	l := (maxComp + minComp) / 2.0
+	s := 0.0
+	if (l > 0.0) && (l < 1.0) {
+		s = (maxComp - minComp) / (1.0 - math.Abs(2.0*l-1.0))
+	}
+	return h, s, l
+}
+
+// Convert RGB color to HSL.
+// Returns values in the range 0.0 ... 1.0
+func rgbToHSL(r, g, b uint32) (float64, float64, float64) {
+	return normRGBToHSL(norm(r), norm(g), norm(b))
+}
+
+// Convert HSL color to "normalized" RGB,
+// with each result color component in 0.0 ... 1.0
+func hslToNormRGB(h, s, l float64) (float64, float64, float64) {
+	if s == 0 {
+		return l, l, l
+	}
+
+	chroma := (1.0 - math.Abs(2.0*l-1.0)) * s
+	r1, g1, b1 := hueToNormRGB(h, chroma)
+	m := l - chroma/2.0
+	return r1 + m, g1 + m, b1 + m
+}
+
+// Convert HSL color to RGB.
+// h, s, l are each in 0.0 ... 1.0.
+// The returned values are each in 0 ... 0xffff
+func hslToRGB(h, s, l float64) (uint32, uint32, uint32) {
+	rn, gn, bn := hslToNormRGB(h, s, l)
+	return denorm(rn), denorm(gn), denorm(bn)
+}
@@ -0,0 +1,49 @@
+package color
+
+import (
+	"testing"
+)
+
+var rgbToHSLTestCases = []struct {
+	r, g, b uint32
+	h, s, l float64
+}{
+	{0x0000, 0x0000, 0x0000, 0.0, 0.0, 0.0},
+	{0xffff, 0xffff, 0xffff, 0.0, 0.0, 1.0},
+	{0x547a, 0x547a, 0x547a, 0.0, 0.0, 0.33},
+	{0xffff, 0x0000, 0x0000, 0.0, 1.0, 0.5},
+	{0xffff, 0xffff, 0x0000, 1.0 / 6.0, 1.0, 0.5},
+	{0x0000, 0xffff, 0x0000, 2.0 / 6.0, 1.0, 0.5},
+	{0x0000, 0xffff, 0xffff, 3.0 / 6.0, 1.0, 0.5},
+	{0x0000, 0x0000, 0xffff, 4.0 / 6.0, 1.0, 0.5},
+	{0xffff, 0x0000, 0xffff, 5.0 / 6.0, 1.0, 0.5},
+	{0xffff, 0x547a, 0x547a, 0.0, 1.0, 0.664996},
+	{0x547a, 0xab84, 0xffff, 0.58209, 1.0, 0.664996},
+	{0x547a, 0x547a, 0xab84, 4.0 / 6.0, 0.34, 0.5},
+	{0x547a, 0x0000, 0x0000, 0.0, 1.0, 0.164996},
+}
+
+func TestRGBToHSL(t *testing.T) {
+	for _, tc := range rgbToHSLTestCases {
+		name := hexTupleStr(tc.r, tc.g, tc.b)
+		t.Run(name, func(t *testing.T) {
+			h, s, l := rgbToHSL(tc.r, tc.g, tc.b)
+			if !(eq(h, tc.h) && eq(s, tc.s) && eq(l, tc.l)) {
+				t.Errorf("want %v, got %v", fTupleStr(tc.h, tc.s, tc.l), fTupleStr(h, s, l))
+			}
+		})
+	}
+}
+
+func TestHSLToRGB(t *testing.T) {
+	for _, tc := range rgbToHSLTestCases {
+		name := fTupleStr(tc.h, tc.s, tc.l)
+		t.Run(name, func(t *testing.T) {
+			h, s, l := rgbToHSL(tc.r, tc.g, tc.b)
+			r, g, b, _ := HSL{H: h, S: s, L: l}.RGBA()
+			if !(eq_u(r, tc.r) && eq_u(g, tc.g) && eq_u(b, tc.b)) {
+				t.Errorf("want %v, got %v", hexTupleStr(tc.r, tc.g, tc.b), hexTupleStr(r, g, b))
+			}
+		})
+	}
+}
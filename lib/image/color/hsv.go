@@ -108,46 +108,37 @@ func rgbToHSV(r, g, b uint32) (float64, float64, float64) {
 	return normRGBToHSV(norm(r), norm(g), norm(b))
 }
 
-// Convert HSV color to "normalized" RGB,
-// with each result color component in 0.0 ... 1.0
-// Round-trip RGB->HSV->RGB does not always succeed.
-func hsvToNormRGB(h, s, v float64) (float64, float64, float64) {
-	if s == 0 {
-		return v, v, v
-	}
-
+// Get the "normalized" RGB components, less the smallest component, of a
+// color with hue h, in 0.0 ... 1.0, and the given chroma.
+func hueToNormRGB(h, chroma float64) (float64, float64, float64) {
 	// This is from Wikipedia.
-	chroma := s * v
 	h6 := h * 6.0
 	x := chroma * (1.0 - math.Abs(math.Mod(h6, 2.0)-1.0))
 
-	var r1, g1, b1 float64
 	if (0.0 <= h6) && (h6 <= 1) {
-		r1 = chroma
-		g1 = x
-		b1 = 0
+		return chroma, x, 0
 	} else if (1 < h6) && (h6 <= 2) {
-		r1 = x
-		g1 = chroma
-		b1 = 0
+		return x, chroma, 0
 	} else if (2 < h6) && (h6 <= 3) {
-		r1 = 0
-		g1 = chroma
-		b1 = x
+		return 0, chroma, x
 	} else if (3 < h6) && (h6 <= 4) {
-		r1 = 0
-		g1 = x
-		b1 = chroma
+		return 0, x, chroma
 	} else if (4 < h6) && (h6 <= 5) {
-		r1 = x
-		g1 = 0
-		b1 = chroma
-	} else {
-		r1 = chroma
-		g1 = 0
-		b1 = x
+		return x, 0, chroma
+	}
+	return chroma, 0, x
+}
+
+// Convert HSV color to "normalized" RGB,
+// with each result color component in 0.0 ... 1.0
+// Round-trip RGB->HSV->RGB does not always succeed.
+func hsvToNormRGB(h, s, v float64) (float64, float64, float64) {
+	if s == 0 {
+		return v, v, v
 	}
 
+	chroma := s * v
+	r1, g1, b1 := hueToNormRGB(h, chroma)
 	m := v - chroma
 	return r1 + m, g1 + m, b1 + m
 }
@@ -0,0 +1,41 @@
+package color
+
+import (
+	"image/color"
+)
+
+// LinearRGB represents sRGB before gamma compression, i.e., with components
+// proportional to light intensity.  Components are nominally in 0.0 ... 1.0;
+// out-of-gamut colors have components outside that range.
+type LinearRGB struct {
+	R, G, B float64
+}
+
+// Get a linear sRGB color as RGBA, clamping out-of-gamut components.
+func (c LinearRGB) RGBA() (r, g, b, a uint32) {
+	r = denorm(gammaCompressed(c.R))
+	g = denorm(gammaCompressed(c.G))
+	b = denorm(gammaCompressed(c.B))
+	a = 0xffff
+	return
+}
+
+func linearRGBModel(c color.Color) color.Color {
+	if _, ok := c.(LinearRGB); ok {
+		return c
+	}
+	r, g, b, _ := c.RGBA()
+	return LinearRGB{R: gammaExpanded(norm(r)), G: gammaExpanded(norm(g)), B: gammaExpanded(norm(b))}
+}
+
+var LinearRGBModel color.Model = color.ModelFunc(linearRGBModel)
+
+func (c LinearRGB) CIEXYZ() CIEXYZ {
+	return LinearRGBToCIEXYZ(c.R, c.G, c.B)
+}
+
+// Get the linear sRGB color of an XYZ color.  See also CIEXYZ.LinearRGB.
+func LinearRGBFromCIEXYZ(c CIEXYZ) LinearRGB {
+	r, g, b := c.LinearRGB()
+	return LinearRGB{R: r, G: g, B: b}
+}
@@ -0,0 +1,61 @@
+package color
+
+import (
+	"image/color"
+	"testing"
+)
+
+// Values from the sRGB transfer function; see
+// https://en.wikipedia.org/wiki/SRGB
+var linearRGBTestCases = []struct {
+	v      uint32
+	linear float64
+}{
+	{0x0000, 0.000000},
+	{0x0a3d, 0.003096}, // Linear segment
+	{0x547a, 0.088977},
+	{0x8000, 0.214048},
+	{0xab84, 0.406439},
+	{0xffff, 1.000000},
+}
+
+func TestRGBToLinearRGB(t *testing.T) {
+	for _, tc := range linearRGBTestCases {
+		name := hexTupleStr(tc.v)
+		t.Run(name, func(t *testing.T) {
+			v := uint16(tc.v)
+			got := LinearRGBModel.Convert(color.RGBA64{R: v, G: v, B: v, A: 0xffff}).(LinearRGB)
+			if !(near(got.R, tc.linear, 1.0e-5) && near(got.G, tc.linear, 1.0e-5) && near(got.B, tc.linear, 1.0e-5)) {
+				t.Errorf("want %v, got %v", tc.linear, got)
+			}
+			r, _, _, _ := LinearRGB{R: tc.linear, G: tc.linear, B: tc.linear}.RGBA()
+			if !eq_u(r, tc.v) {
+				t.Errorf("want 0x%04x, got 0x%04x", tc.v, r)
+			}
+		})
+	}
+}
+
+func TestLinearRGBClampsOutOfGamut(t *testing.T) {
+	r, g, b, _ := LinearRGB{R: 1.5, G: -0.2, B: 0.214048}.RGBA()
+	if (r != 0xffff) || (g != 0) || !eq_u(b, 0x8000) {
+		t.Errorf("want (0xffff,0x0000,0x8000), got %v", hexTupleStr(r, g, b))
+	}
+}
+
+func TestLinearRGBToCIEXYZ(t *testing.T) {
+	for _, tc := range xyzTestCases {
+		name := hexTupleStr(tc.r, tc.g, tc.b)
+		t.Run(name, func(t *testing.T) {
+			linear := LinearRGBModel.Convert(CIEXYZ{X: tc.x, Y: tc.y, Z: tc.z}).(LinearRGB)
+			xyz := linear.CIEXYZ()
+			if !(eq(xyz.X, tc.x) && eq(xyz.Y, tc.y) && eq(xyz.Z, tc.z)) {
+				t.Errorf("want %v, got %v", fTupleStr(tc.x, tc.y, tc.z), fTupleStr(xyz.X, xyz.Y, xyz.Z))
+			}
+			back := LinearRGBFromCIEXYZ(xyz)
+			if !(near(back.R, linear.R, 1.0e-6) && near(back.G, linear.G, 1.0e-6) && near(back.B, linear.B, 1.0e-6)) {
+				t.Errorf("want %v, got %v", linear, back)
+			}
+		})
+	}
+}
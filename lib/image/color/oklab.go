@@ -0,0 +1,81 @@
+package color
+
+import (
+	"image/color"
+	"math"
+)
+
+// Oklab is a perceptual color space in which Euclidean distances, and hue,
+// are more uniform than in CIE Lab.  It assumes the D65 illuminant.  L is
+// in 0.0 ... 1.0; a and b are roughly within -0.4 ... 0.4.
+// See https://bottosson.github.io/posts/oklab/
+type Oklab struct {
+	L, A, B float64
+}
+
+func (c Oklab) RGBA() (r, g, b, a uint32) {
+	return c.LinearRGB().RGBA()
+}
+
+func oklabModel(c color.Color) color.Color {
+	if _, ok := c.(Oklab); ok {
+		return c
+	}
+	return linearRGBModel(c).(LinearRGB).Oklab()
+}
+
+var OklabModel color.Model = color.ModelFunc(oklabModel)
+
+// OkLCh represents Oklab in cylindrical coordinates: lightness, chroma,
+// and hue angle in degrees, 0.0 ... 360.0.
+type OkLCh struct {
+	L, C, H float64
+}
+
+func (c OkLCh) RGBA() (r, g, b, a uint32) {
+	return c.Oklab().RGBA()
+}
+
+func okLChModel(c color.Color) color.Color {
+	if _, ok := c.(OkLCh); ok {
+		return c
+	}
+	return oklabModel(c).(Oklab).LCh()
+}
+
+var OkLChModel color.Model = color.ModelFunc(okLChModel)
+
+func (c LinearRGB) Oklab() Oklab {
+	// Cone responses
+	l := math.Cbrt(0.4122214708*c.R + 0.5363325363*c.G + 0.0514459929*c.B)
+	m := math.Cbrt(0.2119034982*c.R + 0.6806995451*c.G + 0.1073969566*c.B)
+	s := math.Cbrt(0.0883024619*c.R + 0.2817188376*c.G + 0.6299787005*c.B)
+	return Oklab{
+		L: 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		A: 1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		B: 0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+func (c Oklab) LinearRGB() LinearRGB {
+	l := c.L + 0.3963377774*c.A + 0.2158037573*c.B
+	m := c.L - 0.1055613458*c.A - 0.0638541728*c.B
+	s := c.L - 0.0894841775*c.A - 1.2914855480*c.B
+	l, m, s = l*l*l, m*m*m, s*s*s
+	return LinearRGB{
+		R: 4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		G: -1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		B: -0.0041960863*l - 0.7034186147*m + 1.7076147010*s,
+	}
+}
+
+// Get the cylindrical form of an Oklab color.
+func (c Oklab) LCh() OkLCh {
+	chroma, hue := rectToPolar(c.A, c.B)
+	return OkLCh{L: c.L, C: chroma, H: hue}
+}
+
+func (c OkLCh) Oklab() Oklab {
+	a, b := polarToRect(c.C, c.H)
+	return Oklab{L: c.L, A: a, B: b}
+}
@@ -0,0 +1,46 @@
+package color
+
+import (
+	"image/color"
+	"testing"
+)
+
+// From https://www.w3.org/TR/css-color-4/ and
+// https://bottosson.github.io/posts/oklab/
+var rgbToOklabTestCases = []struct {
+	r, g, b          uint32
+	labL, laba, labb float64
+	c, h             float64
+}{
+	{0xffff, 0xffff, 0xffff, 1.0, 0.0, 0.0, 0.0, 0.0},
+	{0xffff, 0x0000, 0x0000, 0.627955, 0.224863, 0.125846, 0.257683, 29.2339},
+	{0x0000, 0xffff, 0x0000, 0.866440, -0.233888, 0.179498, 0.294827, 142.4953},
+	{0x0000, 0x0000, 0xffff, 0.452014, -0.032457, -0.311528, 0.313214, 264.0520},
+}
+
+func TestRGBToOklab(t *testing.T) {
+	for _, tc := range rgbToOklabTestCases {
+		name := hexTupleStr(tc.r, tc.g, tc.b)
+		t.Run(name, func(t *testing.T) {
+			src := color.RGBA64{R: uint16(tc.r), G: uint16(tc.g), B: uint16(tc.b), A: 0xffff}
+			lab := OklabModel.Convert(src).(Oklab)
+			if !(near(lab.L, tc.labL, 1.0e-5) && near(lab.A, tc.laba, 1.0e-5) && near(lab.B, tc.labb, 1.0e-5)) {
+				t.Errorf("want %v, got %v", fTupleStr(tc.labL, tc.laba, tc.labb), fTupleStr(lab.L, lab.A, lab.B))
+			}
+			lch := OkLChModel.Convert(src).(OkLCh)
+			if !near(lch.C, tc.c, 1.0e-5) || ((tc.c > 0.0) && !nearDegrees(lch.H, tc.h, 1.0e-3)) {
+				t.Errorf("want %v, got %v", fTupleStr(tc.labL, tc.c, tc.h), fTupleStr(lch.L, lch.C, lch.H))
+			}
+		})
+	}
+}
+
+func TestLinearRGBToOklabRoundTrip(t *testing.T) {
+	for _, tc := range xyzTestCases {
+		linear := LinearRGBFromCIEXYZ(CIEXYZ{X: tc.x, Y: tc.y, Z: tc.z})
+		back := linear.Oklab().LCh().Oklab().LinearRGB()
+		if !(near(back.R, linear.R, 1.0e-6) && near(back.G, linear.G, 1.0e-6) && near(back.B, linear.B, 1.0e-6)) {
+			t.Errorf("%v -> %v", linear, back)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+type settableImage interface {
+	image.Image
+	Set(x, y int, c color.Color)
+}
+
+var colorImageTypes = []struct {
+	name      string
+	model     color.Model
+	newImage  func(r image.Rectangle) settableImage
+	subImage  func(img settableImage, r image.Rectangle) settableImage
+	fromImage func(src image.Image, workers int) image.Image
+}{
+	{
+		"LinearRGB", lib_color.LinearRGBModel,
+		func(r image.Rectangle) settableImage { return NewLinearRGB(r) },
+		func(img settableImage, r image.Rectangle) settableImage { return img.(*LinearRGB).SubImage(r) },
+		func(src image.Image, workers int) image.Image { return LinearRGBFromImage(src, workers) },
+	},
+	{
+		"HSL", lib_color.HSLModel,
+		func(r image.Rectangle) settableImage { return NewHSL(r) },
+		func(img settableImage, r image.Rectangle) settableImage { return img.(*HSL).SubImage(r) },
+		func(src image.Image, workers int) image.Image { return HSLFromImage(src, workers) },
+	},
+	{
+		"CIELCh", lib_color.CIELChModel,
+		func(r image.Rectangle) settableImage { return NewCIELCh(r) },
+		func(img settableImage, r image.Rectangle) settableImage { return img.(*CIELCh).SubImage(r) },
+		func(src image.Image, workers int) image.Image { return CIELChFromImage(src, workers) },
+	},
+	{
+		"CIELuv", lib_color.CIELuvModel,
+		func(r image.Rectangle) settableImage { return NewCIELuv(r) },
+		func(img settableImage, r image.Rectangle) settableImage { return img.(*CIELuv).SubImage(r) },
+		func(src image.Image, workers int) image.Image { return CIELuvFromImage(src, workers) },
+	},
+	{
+		"Oklab", lib_color.OklabModel,
+		func(r image.Rectangle) settableImage { return NewOklab(r) },
+		func(img settableImage, r image.Rectangle) settableImage { return img.(*Oklab).SubImage(r) },
+		func(src image.Image, workers int) image.Image { return OklabFromImage(src, workers) },
+	},
+	{
+		"OkLCh", lib_color.OkLChModel,
+		func(r image.Rectangle) settableImage { return NewOkLCh(r) },
+		func(img settableImage, r image.Rectangle) settableImage { return img.(*OkLCh).SubImage(r) },
+		func(src image.Image, workers int) image.Image { return OkLChFromImage(src, workers) },
+	},
+}
+
+func randomRGBA(rect image.Rectangle, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	result := image.NewRGBA(rect)
+	rng.Read(result.Pix)
+	for i := 3; i < len(result.Pix); i += 4 {
+		result.Pix[i] = 0xff
+	}
+	return result
+}
+
+func TestColorImagesSetAt(t *testing.T) {
+	rect := image.Rect(-2, 3, 6, 9)
+	for _, it := range colorImageTypes {
+		t.Run(it.name, func(t *testing.T) {
+			img := it.newImage(rect)
+			if !img.Bounds().Eq(rect) {
+				t.Fatalf("Expected bounds %v, got %v", rect, img.Bounds())
+			}
+			want := it.model.Convert(color.RGBA{R: 0xc0, G: 0x40, B: 0x20, A: 0xff})
+			img.Set(1, 4, want)
+			if got := img.At(1, 4); got != want {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+			if got := img.At(2, 4); got == want {
+				t.Errorf("Expected neighboring pixel to be unchanged, got %v", got)
+			}
+			// Pixels outside the image are ignored.
+			img.Set(-3, 4, want)
+			if got := img.At(-3, 4); got == want {
+				t.Errorf("Expected out-of-bounds pixel to be unset, got %v", got)
+			}
+		})
+	}
+}
+
+func TestColorImagesSubImage(t *testing.T) {
+	rect := image.Rect(0, 0, 8, 6)
+	for _, it := range colorImageTypes {
+		t.Run(it.name, func(t *testing.T) {
+			img := it.newImage(rect)
+			sub := it.subImage(img, image.Rect(2, 1, 12, 4))
+			wantBounds := image.Rect(2, 1, 8, 4)
+			if !sub.Bounds().Eq(wantBounds) {
+				t.Fatalf("Expected bounds %v, got %v", wantBounds, sub.Bounds())
+			}
+
+			// The subimage shares its parent's pixels.
+			want := it.model.Convert(color.RGBA{R: 0x10, G: 0x80, B: 0xf0, A: 0xff})
+			sub.Set(7, 3, want)
+			if got := img.At(7, 3); got != want {
+				t.Errorf("Expected parent pixel %v, got %v", want, got)
+			}
+			img.Set(2, 1, want)
+			if got := sub.At(2, 1); got != want {
+				t.Errorf("Expected subimage pixel %v, got %v", want, got)
+			}
+			if got := sub.At(1, 1); got == want {
+				t.Errorf("Expected pixel outside subimage to be unset, got %v", got)
+			}
+
+			empty := it.subImage(img, image.Rect(20, 20, 30, 30))
+			if !empty.Bounds().Empty() {
+				t.Errorf("Expected empty bounds, got %v", empty.Bounds())
+			}
+		})
+	}
+}
+
+func TestColorImagesFromImage(t *testing.T) {
+	rect := image.Rect(3, 5, 40, 29)
+	src := randomRGBA(rect, 1)
+	for _, it := range colorImageTypes {
+		t.Run(it.name, func(t *testing.T) {
+			for _, workers := range []int{1, 3, 0} {
+				got := it.fromImage(src, workers)
+				if !got.Bounds().Eq(rect) {
+					t.Fatalf("Expected bounds %v, got %v", rect, got.Bounds())
+				}
+				for y := rect.Min.Y; y < rect.Max.Y; y++ {
+					for x := rect.Min.X; x < rect.Max.X; x++ {
+						want := it.model.Convert(src.At(x, y))
+						if actual := got.At(x, y); actual != want {
+							t.Fatalf("%v workers: expected %v at (%v, %v), got %v", workers, want, x, y, actual)
+						}
+					}
+				}
+			}
+		})
+	}
+}
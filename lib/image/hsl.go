@@ -0,0 +1,97 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// HSL is an image of lib_color.HSL pixels, each in 0.0 ... 1.0.
+type HSL struct {
+	// Pix, Stride, Rect
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *HSL) ColorModel() color.Model { return lib_color.HSLModel }
+
+func (p *HSL) Bounds() image.Rectangle { return p.Rect }
+
+func (p *HSL) At(x, y int) color.Color {
+	return p.HSLAt(x, y)
+}
+
+func (p *HSL) HSLAt(x, y int) lib_color.HSL {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return lib_color.HSL{}
+	}
+	i := p.PixOffset(x, y)
+	return lib_color.HSL{H: p.Pix[i], S: p.Pix[i+1], L: p.Pix[i+2]}
+}
+
+func (p *HSL) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+func (p *HSL) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.SetHSL(x, y, lib_color.HSLModel.Convert(c).(lib_color.HSL))
+}
+
+func (p *HSL) SetHSL(x, y int, c lib_color.HSL) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0] = c.H
+	s[1] = c.S
+	s[2] = c.L
+}
+
+func (p *HSL) SubImage(rect image.Rectangle) *HSL {
+	// This is taken from the implementation of NRGBA's SubImage.
+	r := rect.Intersect(p.Rect)
+	if r.Empty() {
+		return &HSL{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &HSL{
+		Pix:    p.Pix[i:], // <- Those who choose to access Pix directly can overrun the image.
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+func (p *HSL) Opaque() bool {
+	return true
+}
+
+func NewHSL(r image.Rectangle) *HSL {
+	area := r.Dx() * r.Dy()
+	channels := 3 // H, S, L
+	bufferSize := area * channels
+	pix := make([]float64, bufferSize)
+	return &HSL{Pix: pix, Stride: channels * r.Dx(), Rect: r}
+}
+
+// Create an HSL from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.  The result has the same bounds as the
+// source image.
+func HSLFromImage(src image.Image, workers int) *HSL {
+	rect := src.Bounds()
+	result := NewHSL(rect)
+
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				result.Set(x, y, src.At(x, y))
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,98 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// LinearRGB is an image of lib_color.LinearRGB pixels, with components
+// proportional to light intensity.
+type LinearRGB struct {
+	// Pix, Stride, Rect
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *LinearRGB) ColorModel() color.Model { return lib_color.LinearRGBModel }
+
+func (p *LinearRGB) Bounds() image.Rectangle { return p.Rect }
+
+func (p *LinearRGB) At(x, y int) color.Color {
+	return p.LinearRGBAt(x, y)
+}
+
+func (p *LinearRGB) LinearRGBAt(x, y int) lib_color.LinearRGB {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return lib_color.LinearRGB{}
+	}
+	i := p.PixOffset(x, y)
+	return lib_color.LinearRGB{R: p.Pix[i], G: p.Pix[i+1], B: p.Pix[i+2]}
+}
+
+func (p *LinearRGB) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+func (p *LinearRGB) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.SetLinearRGB(x, y, lib_color.LinearRGBModel.Convert(c).(lib_color.LinearRGB))
+}
+
+func (p *LinearRGB) SetLinearRGB(x, y int, c lib_color.LinearRGB) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0] = c.R
+	s[1] = c.G
+	s[2] = c.B
+}
+
+func (p *LinearRGB) SubImage(rect image.Rectangle) *LinearRGB {
+	// This is taken from the implementation of NRGBA's SubImage.
+	r := rect.Intersect(p.Rect)
+	if r.Empty() {
+		return &LinearRGB{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &LinearRGB{
+		Pix:    p.Pix[i:], // <- Those who choose to access Pix directly can overrun the image.
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+func (p *LinearRGB) Opaque() bool {
+	return true
+}
+
+func NewLinearRGB(r image.Rectangle) *LinearRGB {
+	area := r.Dx() * r.Dy()
+	channels := 3 // R, G, B
+	bufferSize := area * channels
+	pix := make([]float64, bufferSize)
+	return &LinearRGB{Pix: pix, Stride: channels * r.Dx(), Rect: r}
+}
+
+// Create a LinearRGB from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.  The result has the same bounds as the
+// source image.
+func LinearRGBFromImage(src image.Image, workers int) *LinearRGB {
+	rect := src.Bounds()
+	result := NewLinearRGB(rect)
+
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				result.Set(x, y, src.At(x, y))
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,97 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Oklab is an image of lib_color.Oklab pixels.
+type Oklab struct {
+	// Pix, Stride, Rect
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *Oklab) ColorModel() color.Model { return lib_color.OklabModel }
+
+func (p *Oklab) Bounds() image.Rectangle { return p.Rect }
+
+func (p *Oklab) At(x, y int) color.Color {
+	return p.OklabAt(x, y)
+}
+
+func (p *Oklab) OklabAt(x, y int) lib_color.Oklab {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return lib_color.Oklab{}
+	}
+	i := p.PixOffset(x, y)
+	return lib_color.Oklab{L: p.Pix[i], A: p.Pix[i+1], B: p.Pix[i+2]}
+}
+
+func (p *Oklab) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+func (p *Oklab) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.SetOklab(x, y, lib_color.OklabModel.Convert(c).(lib_color.Oklab))
+}
+
+func (p *Oklab) SetOklab(x, y int, c lib_color.Oklab) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0] = c.L
+	s[1] = c.A
+	s[2] = c.B
+}
+
+func (p *Oklab) SubImage(rect image.Rectangle) *Oklab {
+	// This is taken from the implementation of NRGBA's SubImage.
+	r := rect.Intersect(p.Rect)
+	if r.Empty() {
+		return &Oklab{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &Oklab{
+		Pix:    p.Pix[i:], // <- Those who choose to access Pix directly can overrun the image.
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+func (p *Oklab) Opaque() bool {
+	return true
+}
+
+func NewOklab(r image.Rectangle) *Oklab {
+	area := r.Dx() * r.Dy()
+	channels := 3 // L, a, b
+	bufferSize := area * channels
+	pix := make([]float64, bufferSize)
+	return &Oklab{Pix: pix, Stride: channels * r.Dx(), Rect: r}
+}
+
+// Create an Oklab from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.  The result has the same bounds as the
+// source image.
+func OklabFromImage(src image.Image, workers int) *Oklab {
+	rect := src.Bounds()
+	result := NewOklab(rect)
+
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				result.Set(x, y, src.At(x, y))
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,97 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// OkLCh is an image of lib_color.OkLCh pixels, with hue in degrees.
+type OkLCh struct {
+	// Pix, Stride, Rect
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *OkLCh) ColorModel() color.Model { return lib_color.OkLChModel }
+
+func (p *OkLCh) Bounds() image.Rectangle { return p.Rect }
+
+func (p *OkLCh) At(x, y int) color.Color {
+	return p.OkLChAt(x, y)
+}
+
+func (p *OkLCh) OkLChAt(x, y int) lib_color.OkLCh {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return lib_color.OkLCh{}
+	}
+	i := p.PixOffset(x, y)
+	return lib_color.OkLCh{L: p.Pix[i], C: p.Pix[i+1], H: p.Pix[i+2]}
+}
+
+func (p *OkLCh) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+func (p *OkLCh) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.SetOkLCh(x, y, lib_color.OkLChModel.Convert(c).(lib_color.OkLCh))
+}
+
+func (p *OkLCh) SetOkLCh(x, y int, c lib_color.OkLCh) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0] = c.L
+	s[1] = c.C
+	s[2] = c.H
+}
+
+func (p *OkLCh) SubImage(rect image.Rectangle) *OkLCh {
+	// This is taken from the implementation of NRGBA's SubImage.
+	r := rect.Intersect(p.Rect)
+	if r.Empty() {
+		return &OkLCh{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &OkLCh{
+		Pix:    p.Pix[i:], // <- Those who choose to access Pix directly can overrun the image.
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+func (p *OkLCh) Opaque() bool {
+	return true
+}
+
+func NewOkLCh(r image.Rectangle) *OkLCh {
+	area := r.Dx() * r.Dy()
+	channels := 3 // L, C, H
+	bufferSize := area * channels
+	pix := make([]float64, bufferSize)
+	return &OkLCh{Pix: pix, Stride: channels * r.Dx(), Rect: r}
+}
+
+// Create an OkLCh from an image, converting row bands concurrently.
+// workers <= 0 means one per CPU.  The result has the same bounds as the
+// source image.
+func OkLChFromImage(src image.Image, workers int) *OkLCh {
+	rect := src.Bounds()
+	result := NewOkLCh(rect)
+
+	ForEachRowBand(rect, workers, func(_ int, band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				result.Set(x, y, src.At(x, y))
+			}
+		}
+	})
+	return result
+}
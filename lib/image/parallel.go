@@ -0,0 +1,151 @@
+package image
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// Get the number of workers to use.  workers <= 0 means one per CPU.
+func NumWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+// Split a rectangle into at most n horizontal bands of nearly equal height.
+func RowBands(rect image.Rectangle, n int) []image.Rectangle {
+	height := rect.Dy()
+	if n > height {
+		n = height
+	}
+	if n < 1 {
+		n = 1
+	}
+	result := make([]image.Rectangle, 0, n)
+	for i := 0; i < n; i++ {
+		y0 := rect.Min.Y + i*height/n
+		y1 := rect.Min.Y + (i+1)*height/n
+		result = append(result, image.Rect(rect.Min.X, y0, rect.Max.X, y1))
+	}
+	return result
+}
+
+// Call fn concurrently for each of up to workers row bands of rect,
+// returning when all calls have returned.  fn receives the index of its
+// band, so that it can store per-band results for merging in band order.
+// workers <= 0 means one per CPU.
+func ForEachRowBand(rect image.Rectangle, workers int, fn func(i int, band image.Rectangle)) {
+	bands := RowBands(rect, NumWorkers(workers))
+	if len(bands) == 1 {
+		fn(0, bands[0])
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(bands))
+	for i, band := range bands {
+		go func(i int, band image.Rectangle) {
+			defer wg.Done()
+			fn(i, band)
+		}(i, band)
+	}
+	wg.Wait()
+}
+
+// Call fn concurrently, on up to workers goroutines, for each of rects,
+// returning when all calls have returned.  fn receives the index of its
+// rectangle, so that it can store per-rectangle results for merging in
+// order.  Unlike ForEachRowBand, how the work is divided does not depend
+// on the number of workers.  workers <= 0 means one per CPU.
+func ForEachRect(rects []image.Rectangle, workers int, fn func(i int, rect image.Rectangle)) {
+	n := NumWorkers(workers)
+	if n > len(rects) {
+		n = len(rects)
+	}
+	if n <= 1 {
+		for i, rect := range rects {
+			fn(i, rect)
+		}
+		return
+	}
+
+	indices := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	for w := 0; w < n; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i, rects[i])
+			}
+		}()
+	}
+	for i := range rects {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// Blocked is implemented by images stored in square blocks, such as
+// TiledCIELab, which are fastest to visit one block at a time.
+type Blocked interface {
+	BlockSize() int
+}
+
+// Split a rectangle into blocks of blockSize x blockSize pixels, aligned
+// with its origin.  Blocks on the right and bottom edges may be smaller.
+func Blocks(rect image.Rectangle, blockSize int) []image.Rectangle {
+	result := []image.Rectangle{}
+	for y := rect.Min.Y; y < rect.Max.Y; y += blockSize {
+		for x := rect.Min.X; x < rect.Max.X; x += blockSize {
+			result = append(result, image.Rect(x, y, x+blockSize, y+blockSize).Intersect(rect))
+		}
+	}
+	return result
+}
+
+// Call fn concurrently for parts of an image, on up to workers goroutines,
+// returning when all calls have returned.  Blocked images are visited one
+// block at a time, so that each worker needs only one block in memory;
+// others are visited in row bands.  fn receives the index of the worker
+// calling it, 0 <= i < NumWorkers(workers), so that it can accumulate
+// per-worker results.  The order in which parts are visited is unspecified.
+func ForEachImagePart(img image.Image, workers int, fn func(i int, part image.Rectangle)) {
+	blocked, ok := img.(Blocked)
+	if !ok {
+		ForEachRowBand(img.Bounds(), workers, fn)
+		return
+	}
+
+	blocks := Blocks(img.Bounds(), blocked.BlockSize())
+	n := NumWorkers(workers)
+	if n > len(blocks) {
+		n = len(blocks)
+	}
+	if n <= 1 {
+		for _, block := range blocks {
+			fn(0, block)
+		}
+		return
+	}
+
+	parts := make(chan image.Rectangle)
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for part := range parts {
+				fn(i, part)
+			}
+		}(i)
+	}
+	for _, block := range blocks {
+		parts <- block
+	}
+	close(parts)
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// LabImage is a CIE Lab image whose pixels can be read and written.
+type LabImage interface {
+	image.Image
+	CIELabAt(x, y int) lib_color.CIELab
+	SetCIELab(x, y int, c lib_color.CIELab)
+}
+
+// TiledCIELab is a CIE Lab image held in a BlockStore, so that it can be
+// larger than available memory.  It is safe for concurrent use, provided
+// concurrent writers modify different pixels.
+type TiledCIELab struct {
+	store *BlockStore
+}
+
+func NewTiledCIELab(r image.Rectangle, opts StorageOptions) (*TiledCIELab, error) {
+	store, err := NewBlockStore(r, 3, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TiledCIELab{store}, nil
+}
+
+func (p *TiledCIELab) ColorModel() color.Model { return lib_color.CIELabModel }
+
+func (p *TiledCIELab) Bounds() image.Rectangle { return p.store.Bounds() }
+
+func (p *TiledCIELab) BlockSize() int { return p.store.BlockSize() }
+
+func (p *TiledCIELab) At(x, y int) color.Color {
+	return p.CIELabAt(x, y)
+}
+
+func (p *TiledCIELab) CIELabAt(x, y int) lib_color.CIELab {
+	var pix [3]float64
+	p.store.Pixel(x, y, pix[:])
+	return lib_color.CIELab{L: pix[0], A: pix[1], B: pix[2]}
+}
+
+func (p *TiledCIELab) Set(x, y int, c color.Color) {
+	p.SetCIELab(x, y, lib_color.CIELabModel.Convert(c).(lib_color.CIELab))
+}
+
+func (p *TiledCIELab) SetCIELab(x, y int, c lib_color.CIELab) {
+	pix := [3]float64{c.L, c.A, c.B}
+	p.store.SetPixel(x, y, pix[:])
+}
+
+// Get one channel of a pixel: 0 for L, 1 for a, 2 for b.
+func (p *TiledCIELab) Channel(x, y, channel int) float64 {
+	return p.store.Value(x, y, channel)
+}
+
+func (p *TiledCIELab) SetChannel(x, y, channel int, v float64) {
+	p.store.SetValue(x, y, channel, v)
+}
+
+func (p *TiledCIELab) Opaque() bool {
+	return true
+}
+
+// Get the first error encountered while paging, if any.
+func (p *TiledCIELab) Err() error {
+	return p.store.Err()
+}
+
+// Release the image's scratch file, if any.
+func (p *TiledCIELab) Close() error {
+	return p.store.Close()
+}
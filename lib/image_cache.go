@@ -12,7 +12,7 @@ import (
 )
 
 type ImageCache struct {
-	idb     ImageDB
+	store   ImageStore
 	rootdir string
 }
 
@@ -21,12 +21,12 @@ const DefaultCachePathname = "./image_cache"
 const thumbDir = "thumbnail"
 const fullDir = "full_res"
 
-func NewImageCache(idb ImageDB) (ImageCache, error) {
-	return NewImageCacheAtPath(idb, DefaultCachePathname)
+func NewImageCache(store ImageStore) (ImageCache, error) {
+	return NewImageCacheAtPath(store, DefaultCachePathname)
 }
 
-func NewImageCacheAtPath(idb ImageDB, cacheDir string) (ImageCache, error) {
-	result := ImageCache{idb, cacheDir}
+func NewImageCacheAtPath(store ImageStore, cacheDir string) (ImageCache, error) {
+	result := ImageCache{store, cacheDir}
 	err := result.ensureDirsExist()
 	return result, err
 }
@@ -120,7 +120,7 @@ func (cache *ImageCache) ThumbNail(imageID string) (image.Image, error) {
 	if err == nil {
 		return result, err
 	}
-	url, err := cache.idb.ThumbnailURL(imageID)
+	url, err := cache.store.ThumbnailURL(imageID)
 	if err != nil {
 		return result, err
 	}
@@ -134,7 +134,7 @@ func (cache *ImageCache) FullSize(imageID string) (image.Image, error) {
 	if err == nil {
 		return result, err
 	}
-	url, err := cache.idb.FullSizeURL(imageID)
+	url, err := cache.store.FullSizeURL(imageID)
 	if err != nil {
 		return result, err
 	}
@@ -13,9 +13,9 @@ func TestGetAThumbnail(t *testing.T) {
 	}
 	defer os.Remove(idb.DBName)
 
-	loadSampleData(idb, t)
+	loadSampleData(&idb, t)
 
-	cache, err := NewImageCache(idb)
+	cache, err := NewImageCache(&idb)
 	if err != nil {
 		t.Fatal("Error creating image cache:", err)
 	}
@@ -42,9 +42,9 @@ func TestGetAFullRes(t *testing.T) {
 	}
 	defer os.Remove(idb.DBName)
 
-	loadSampleData(idb, t)
+	loadSampleData(&idb, t)
 
-	cache, err := NewImageCache(idb)
+	cache, err := NewImageCache(&idb)
 	if err != nil {
 		t.Fatal("Error creating image cache:", err)
 	}
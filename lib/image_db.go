@@ -4,8 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"math"
-
-	_ "github.com/mattn/go-sqlite3"
+	"strconv"
+	"strings"
 )
 
 type ImageDB struct {
@@ -48,7 +48,7 @@ const schema string = `CREATE TABLE IF NOT EXISTS Images (
 		date_taken_utc TIMESTAMP NOT NULL,
 		-- date_taken_mars TIMESTAMP NOT NULL,
 		-- date_received TIMESTAMP NOT NULL,
-		-- sol INTEGER NOT NULL,
+		sol INTEGER,
 
 		-- misc
 		attitude TEXT NOT NULL, -- 3-tuple of floats, I think
@@ -77,9 +77,51 @@ const schema string = `CREATE TABLE IF NOT EXISTS Images (
 		ext_height REAL
 	);`
 
+// Columns added since the original schema, for upgrading existing databases.
+var addedColumns = []struct{ name, decl string }{
+	{"sol", "INTEGER"},
+}
+
 func (idb *ImageDB) initSchema() error {
 	_, err := idb.DB.Exec(schema)
-	return err
+	if err != nil {
+		return err
+	}
+	return idb.addMissingColumns()
+}
+
+func (idb *ImageDB) addMissingColumns() error {
+	existing, err := idb.columnNames()
+	if err != nil {
+		return err
+	}
+	for _, col := range addedColumns {
+		if !existing[col.name] {
+			query := fmt.Sprintf("ALTER TABLE Images ADD COLUMN %v %v", col.name, col.decl)
+			if _, err = idb.DB.Exec(query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (idb *ImageDB) columnNames() (map[string]bool, error) {
+	result := map[string]bool{}
+	rows, err := idb.DB.Query("SELECT name FROM pragma_table_info('Images')")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return result, err
+		}
+		result[name] = true
+	}
+	return result, rows.Err()
 }
 
 // Create/access an image database at the DefaultDBPathname.
@@ -88,10 +130,11 @@ func NewImageDB() (ImageDB, error) {
 }
 
 // Create/access an image database.
+// The SQLite driver is chosen at build time; see sqlite_driver_*.go.
 func NewImageDBAtPath(pathname string) (ImageDB, error) {
 	result := ImageDB{}
 
-	db, err := sql.Open("sqlite3", pathname)
+	db, err := sql.Open(sqliteDriverName, pathname)
 	if err != nil {
 		return result, err
 	}
@@ -102,10 +145,17 @@ func NewImageDBAtPath(pathname string) (ImageDB, error) {
 }
 
 // Add or update Images from provided records.
+// Records are upserted by image ID, so adding the same records more than
+// once is harmless.
 func (idb *ImageDB) AddOrUpdate(records []ImageInfo) error {
-	statement, err := idb.prepareUpdateOne()
+	tx, err := idb.DB.Begin()
+	if err != nil {
+		return err
+	}
 
+	statement, err := prepareUpdateOne(tx)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	defer statement.Close()
@@ -113,13 +163,14 @@ func (idb *ImageDB) AddOrUpdate(records []ImageInfo) error {
 	for _, record := range records {
 		err := addOrUpdateOne(statement, record)
 		if err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (idb *ImageDB) prepareUpdateOne() (*sql.Stmt, error) {
+func prepareUpdateOne(tx *sql.Tx) (*sql.Stmt, error) {
 	// SQLite3 supports named query parameters.  Go's sql.DB support
 	// for named parameters looks a bit verbose to me.
 	// https://golang.org/pkg/database/sql/#Named
@@ -132,7 +183,7 @@ func (idb *ImageDB) prepareUpdateOne() (*sql.Stmt, error) {
 		sample_type,
 		color_type,
 		small_url, full_res_url, json_url,
-		date_taken_utc,
+		date_taken_utc, sol,
 		attitude, drive, site,
 		ext_mast_azimuth, ext_mast_elevation,
 		ext_sclk,
@@ -148,7 +199,7 @@ func (idb *ImageDB) prepareUpdateOne() (*sql.Stmt, error) {
 		?,
 		?,
 		?, ?, ?,
-		?,
+		?, ?,
 		?, ?, ?,
 		?, ?,
 		?,
@@ -157,7 +208,7 @@ func (idb *ImageDB) prepareUpdateOne() (*sql.Stmt, error) {
 		?, ?, ?, ?,
 		?, ?
 	)`
-	return idb.DB.Prepare(query)
+	return tx.Prepare(query)
 }
 
 func addOrUpdateOne(statement *sql.Stmt, record ImageInfo) error {
@@ -185,7 +236,8 @@ func addOrUpdateOne(statement *sql.Stmt, record ImageInfo) error {
 		record.SampleType,
 		colorType,
 		record.ImageFiles.Small, record.ImageFiles.FullRes, record.JsonLink,
-		record.DateTakenUtc, attitudeStr, record.Drive, record.Site,
+		record.DateTakenUtc, record.Sol.dbValue(),
+		attitudeStr, record.Drive, record.Site,
 		record.Extended.MastAzimuth, record.Extended.MastElevation,
 		record.Extended.Sclk, record.Extended.ScaleFactor,
 		extXYZ[0], extXYZ[1], extXYZ[2],
@@ -242,3 +294,170 @@ func (idb *ImageDB) ThumbnailURL(imageID string) (string, error) {
 func (idb *ImageDB) FullSizeURL(imageID string) (string, error) {
 	return idb.imageURL(imageID, "full_res_url")
 }
+
+// Columns needed to reconstruct an ImageInfo.
+// date_taken_utc is cast to TEXT so that drivers that understand TIMESTAMP
+// columns return the stored string unchanged.
+const imageInfoColumns = `image_id, credit, caption, title,
+		cam_instrument, cam_filter, cam_model_component_list, cam_model_type,
+		cam_pos_x, cam_pos_y, cam_pos_z,
+		sample_type,
+		small_url, full_res_url, json_url,
+		CAST(date_taken_utc AS TEXT), sol,
+		attitude, drive, site,
+		ext_mast_azimuth, ext_mast_elevation,
+		ext_sclk,
+		ext_scale_factor,
+		ext_x, ext_y, ext_z,
+		ext_sf_left, ext_sf_top, ext_sf_width, ext_sf_height,
+		ext_width, ext_height`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func nullIntVal(ival sql.NullInt64) int {
+	if ival.Valid {
+		return int(ival.Int64)
+	}
+	return 0
+}
+
+// Undo fmt.Sprint of a []float64.
+func parseFloatTuple(s string) FloatTuple {
+	result := FloatTuple{}
+	for _, field := range strings.Fields(strings.Trim(s, "[]")) {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			value = math.NaN()
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+func nonNanTuple(values ...float64) FloatTuple {
+	for _, v := range values {
+		if math.IsNaN(v) {
+			return FloatTuple{}
+		}
+	}
+	return FloatTuple(values)
+}
+
+func scanImageInfo(row rowScanner) (ImageInfo, error) {
+	result := ImageInfo{}
+	var componentList, smallURL, fullResURL, jsonURL sql.NullString
+	var camX, camY, camZ sql.NullFloat64
+	var sol, drive, site sql.NullInt64
+	var attitude string
+	var mastAz, mastEl, sclk, scaleFactor sql.NullFloat64
+	var extX, extY, extZ sql.NullFloat64
+	var sfLeft, sfTop, sfWidth, sfHeight sql.NullFloat64
+	var width, height sql.NullFloat64
+
+	err := row.Scan(
+		&result.ImageID, &result.Credit, &result.Caption, &result.Title,
+		&result.Camera.Instrument, &result.Camera.FilterName, &componentList,
+		&result.Camera.CameraModelType,
+		&camX, &camY, &camZ,
+		&result.SampleType,
+		&smallURL, &fullResURL, &jsonURL,
+		&result.DateTakenUtc, &sol,
+		&attitude, &drive, &site,
+		&mastAz, &mastEl,
+		&sclk,
+		&scaleFactor,
+		&extX, &extY, &extZ,
+		&sfLeft, &sfTop, &sfWidth, &sfHeight,
+		&width, &height,
+	)
+	if err != nil {
+		return result, err
+	}
+
+	result.Camera.CameraModelComponentList = componentList.String
+	result.Camera.CameraPosition = nonNanTuple(valOrNan(camX), valOrNan(camY), valOrNan(camZ))
+	result.ImageFiles.Small = smallURL.String
+	result.ImageFiles.FullRes = fullResURL.String
+	result.JsonLink = jsonURL.String
+	result.Sol = unknownSol
+	if sol.Valid {
+		result.Sol = optSol(sol.Int64)
+	}
+	result.Attitude = parseFloatTuple(attitude)
+	result.Drive = optInt(nullIntVal(drive))
+	result.Site = optInt(nullIntVal(site))
+
+	ext := &result.Extended
+	ext.MastAzimuth = optFloat(valOrNan(mastAz))
+	ext.MastElevation = optFloat(valOrNan(mastEl))
+	ext.Sclk = optFloat(valOrNan(sclk))
+	ext.ScaleFactor = optFloat(valOrNan(scaleFactor))
+	ext.XYZ = nonNanTuple(valOrNan(extX), valOrNan(extY), valOrNan(extZ))
+	ext.SubframeRect = Rect{
+		Origin: Origin{X: int(sfLeft.Float64), Y: int(sfTop.Float64)},
+		Size:   Size{Width: int(sfWidth.Float64), Height: int(sfHeight.Float64)},
+	}
+	ext.Dimension = Size{Width: int(width.Float64), Height: int(height.Float64)}
+	return result, nil
+}
+
+// Get the record for an image.
+func (idb *ImageDB) Image(imageID string) (ImageInfo, error) {
+	query := "SELECT " + imageInfoColumns + " FROM Images WHERE image_id = ?"
+	return scanImageInfo(idb.DB.QueryRow(query, imageID))
+}
+
+// Get all records matching a query, ordered by image ID.
+func (idb *ImageDB) Query(query ImageQuery) ([]ImageInfo, error) {
+	result := []ImageInfo{}
+
+	conditions := []string{}
+	args := []interface{}{}
+	if query.Camera != "" {
+		conditions = append(conditions, "cam_instrument = ?")
+		args = append(args, query.Camera)
+	}
+	if query.SampleType != "" {
+		conditions = append(conditions, "sample_type = ?")
+		args = append(args, query.SampleType)
+	}
+	if len(query.ColorTypes) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(query.ColorTypes)), ", ")
+		conditions = append(conditions, "color_type IN ("+placeholders+")")
+		for _, ct := range query.ColorTypes {
+			args = append(args, ct)
+		}
+	}
+	if query.ScaleFactor != 0.0 {
+		conditions = append(conditions, "ext_scale_factor = ?")
+		args = append(args, query.ScaleFactor)
+	}
+	if query.HasSubframeRect {
+		conditions = append(conditions,
+			"ext_sf_left NOT NULL", "ext_sf_top NOT NULL",
+			"ext_sf_width > 0", "ext_sf_height > 0")
+	}
+
+	sqlQuery := "SELECT " + imageInfoColumns + " FROM Images"
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY image_id"
+
+	rows, err := idb.DB.Query(sqlQuery, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanImageInfo(rows)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
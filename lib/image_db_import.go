@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Offline import of RSS API responses.
+// Each "page" is a raw JSON response from the RSS API, i.e., an envelope
+// of the form accepted by ParseImageMetadata: {"images": [...], ...}
+// Pages can be stored as individual .json files, as lines of a JSON Lines
+// (.jsonl) file, or as .json members of a (possibly gzipped) tarball.
+
+type pageHandler func(source string, rawJson []byte) error
+
+// ImportFile adds or updates Images from previously saved RSS API responses.
+// pathname may be a single .json page, a JSON Lines file containing one page
+// per line, a tarball (.tar, .tar.gz, .tgz) of .json pages, or a directory
+// containing any of these.
+// Returns the number of image records imported.
+func (idb *ImageDB) ImportFile(pathname string) (int, error) {
+	numRecords := 0
+	handler := func(source string, rawJson []byte) error {
+		records, err := ParseImageMetadata(rawJson)
+		if err != nil {
+			return fmt.Errorf("error parsing %v: %v", source, err)
+		}
+		if err = idb.AddOrUpdate(records); err != nil {
+			return fmt.Errorf("error importing %v: %v", source, err)
+		}
+		numRecords += len(records)
+		return nil
+	}
+
+	err := readPages(pathname, handler)
+	return numRecords, err
+}
+
+func readPages(pathname string, handler pageHandler) error {
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return readPageDir(pathname, handler)
+	}
+	return readPageFile(pathname, handler)
+}
+
+func readPageDir(dirname string, handler pageHandler) error {
+	pathnames := []string{}
+	err := filepath.Walk(dirname, func(pathname string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isPageFile(pathname) {
+			pathnames = append(pathnames, pathname)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Import in a reproducible order.
+	sort.Strings(pathnames)
+	for _, pathname := range pathnames {
+		if err = readPageFile(pathname, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isPageFile(pathname string) bool {
+	return isJSONFile(pathname) || isJSONLinesFile(pathname) || isTarball(pathname)
+}
+
+func isJSONFile(pathname string) bool {
+	return strings.HasSuffix(strings.ToLower(pathname), ".json")
+}
+
+func isJSONLinesFile(pathname string) bool {
+	lower := strings.ToLower(pathname)
+	return strings.HasSuffix(lower, ".jsonl") || strings.HasSuffix(lower, ".ndjson")
+}
+
+func isTarball(pathname string) bool {
+	lower := strings.ToLower(pathname)
+	for _, suffix := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func readPageFile(pathname string, handler pageHandler) error {
+	inf, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer inf.Close()
+
+	switch {
+	case isJSONLinesFile(pathname):
+		return readJSONLines(pathname, inf, handler)
+	case isTarball(pathname):
+		return readTarball(pathname, inf, handler)
+	}
+
+	// Assume anything else is a single page.
+	rawJson, err := ioutil.ReadAll(inf)
+	if err != nil {
+		return err
+	}
+	return handler(pathname, rawJson)
+}
+
+func readJSONLines(source string, inf io.Reader, handler pageHandler) error {
+	// Pages can be much larger than bufio.Scanner's default token size,
+	// so read whole lines instead.
+	reader := bufio.NewReader(inf)
+	lineNum := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		lineNum += 1
+		if len(bytes.TrimSpace(line)) > 0 {
+			lineSource := fmt.Sprintf("%v:%d", source, lineNum)
+			if handlerErr := handler(lineSource, line); handlerErr != nil {
+				return handlerErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readTarball(source string, inf io.Reader, handler pageHandler) error {
+	lower := strings.ToLower(source)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gzReader, err := gzip.NewReader(inf)
+		if err != nil {
+			return err
+		}
+		defer gzReader.Close()
+		inf = gzReader
+	}
+
+	tarReader := tar.NewReader(inf)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		memberSource := source + ":" + header.Name
+		switch {
+		case isJSONLinesFile(header.Name):
+			err = readJSONLines(memberSource, tarReader, handler)
+		case isJSONFile(header.Name):
+			var rawJson []byte
+			rawJson, err = ioutil.ReadAll(tarReader)
+			if err == nil {
+				err = handler(memberSource, rawJson)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
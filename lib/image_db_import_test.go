@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const samplePagePath = "test_data/sample_rss_response.json"
+
+func countImages(idb ImageDB, t *testing.T) int {
+	result := -1
+	row := idb.DB.QueryRow("SELECT COUNT(*) FROM Images")
+	if err := row.Scan(&result); err != nil {
+		t.Fatal("Error retrieving count:", err)
+	}
+	return result
+}
+
+func newTempDB(t *testing.T) ImageDB {
+	idb, err := NewImageDBAtPath(filepath.Join(t.TempDir(), "import_test.db"))
+	if err != nil {
+		t.Fatal("Error creating Image DB:", err)
+	}
+	return idb
+}
+
+func readSamplePage(t *testing.T) []byte {
+	data, err := ioutil.ReadFile(samplePagePath)
+	if err != nil || len(data) <= 0 {
+		t.Fatal("Failed to read test JSON file:", err)
+	}
+	return data
+}
+
+// Get the sample page as a single line of JSON.
+func compactSamplePage(t *testing.T) []byte {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, readSamplePage(t)); err != nil {
+		t.Fatal("Could not compact sample page:", err)
+	}
+	return compacted.Bytes()
+}
+
+func importAndCheck(idb ImageDB, pathname string, wantImported, wantRows int, t *testing.T) {
+	got, err := idb.ImportFile(pathname)
+	if err != nil {
+		t.Fatal("Error importing", pathname, ":", err)
+	}
+	if got != wantImported {
+		t.Errorf("Expected to import %v records from %v, got %v", wantImported, pathname, got)
+	}
+	if rows := countImages(idb, t); rows != wantRows {
+		t.Errorf("Expected database to have # rows = %v, got %v", wantRows, rows)
+	}
+}
+
+func TestImportJSONFile(t *testing.T) {
+	idb := newTempDB(t)
+	importAndCheck(idb, samplePagePath, 100, 100, t)
+	// Re-importing should not add duplicate records.
+	importAndCheck(idb, samplePagePath, 100, 100, t)
+}
+
+func TestImportDir(t *testing.T) {
+	dirname := t.TempDir()
+	data := readSamplePage(t)
+	for _, name := range []string{"page_00000.json", "page_00001.json", "ignored.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dirname, name), data, 0644); err != nil {
+			t.Fatal("Could not write test page:", err)
+		}
+	}
+
+	idb := newTempDB(t)
+	importAndCheck(idb, dirname, 200, 100, t)
+}
+
+func TestImportJSONLines(t *testing.T) {
+	line := compactSamplePage(t)
+	content := bytes.Join([][]byte{line, line, []byte("")}, []byte("\n"))
+
+	pathname := filepath.Join(t.TempDir(), "pages.jsonl")
+	if err := ioutil.WriteFile(pathname, content, 0644); err != nil {
+		t.Fatal("Could not write JSON Lines file:", err)
+	}
+
+	idb := newTempDB(t)
+	importAndCheck(idb, pathname, 200, 100, t)
+}
+
+func TestImportTarball(t *testing.T) {
+	data := readSamplePage(t)
+
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+	for _, name := range []string{"pages/page_00000.json", "pages/page_00001.json"} {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal("Could not write tar header:", err)
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			t.Fatal("Could not write tar member:", err)
+		}
+	}
+	tarWriter.Close()
+	gzWriter.Close()
+
+	pathname := filepath.Join(t.TempDir(), "pages.tar.gz")
+	if err := ioutil.WriteFile(pathname, buf.Bytes(), 0644); err != nil {
+		t.Fatal("Could not write tarball:", err)
+	}
+
+	idb := newTempDB(t)
+	importAndCheck(idb, pathname, 200, 100, t)
+}
+
+func TestImportInvalidPage(t *testing.T) {
+	pathname := filepath.Join(t.TempDir(), "bad.json")
+	idb := newTempDB(t)
+	for _, content := range []string{"{not json", `{"nav": []}`} {
+		if err := ioutil.WriteFile(pathname, []byte(content), 0644); err != nil {
+			t.Fatal("Could not write test page:", err)
+		}
+		if _, err := idb.ImportFile(pathname); err == nil {
+			t.Errorf("Expected an error importing %v", content)
+		}
+	}
+	if _, err := idb.ImportFile(filepath.Join(t.TempDir(), "no_such_file.json")); err == nil {
+		t.Error("Expected an error importing a nonexistent file.")
+	}
+}
+
+func TestSaveRawPage(t *testing.T) {
+	dirname := filepath.Join(t.TempDir(), "raw")
+	data := readSamplePage(t)
+	if err := SaveRawPage(dirname, 3, data); err != nil {
+		t.Fatal("Error saving raw page:", err)
+	}
+
+	idb := newTempDB(t)
+	importAndCheck(idb, dirname, 100, 100, t)
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"database/sql"
+
+	"github.com/mchapman87501/go_mars_2020_img_utils/lib/traverse"
+)
+
+// Get the site/drive/position information for every image in the database.
+func (idb *ImageDB) TraverseRecords() ([]traverse.Record, error) {
+	result := []traverse.Record{}
+
+	query := `SELECT image_id, cam_instrument, sol, site, drive, ext_x, ext_y, ext_z
+		FROM Images
+		ORDER BY site, drive, image_id`
+	rows, err := idb.DB.Query(query)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := traverse.Record{}
+		sol := sql.NullInt64{}
+		site := sql.NullInt64{}
+		drive := sql.NullInt64{}
+		x := sql.NullFloat64{}
+		y := sql.NullFloat64{}
+		z := sql.NullFloat64{}
+		err = rows.Scan(&record.ImageID, &record.Camera, &sol, &site, &drive, &x, &y, &z)
+		if err != nil {
+			return result, err
+		}
+		record.Sol = traverse.UnknownSol
+		if sol.Valid {
+			record.Sol = int(sol.Int64)
+		}
+		record.Site = int(site.Int64)
+		record.Drive = int(drive.Int64)
+		record.XYZ = [3]float64{valOrNan(x), valOrNan(y), valOrNan(z)}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
+
+// Reconstruct the rover traverse from all images in the database.
+func (idb *ImageDB) Traverse() (traverse.Traverse, error) {
+	records, err := idb.TraverseRecords()
+	if err != nil {
+		return traverse.Traverse{}, err
+	}
+	return traverse.New(records), nil
+}
+
+// Get the IDs of all images taken at a given site and drive.
+func (idb *ImageDB) ImagesAt(site, drive int) ([]string, error) {
+	result := []string{}
+
+	rows, err := idb.DB.Query(
+		"SELECT image_id FROM Images WHERE site = ? AND drive = ? ORDER BY image_id",
+		site, drive)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageID string
+		if err = rows.Scan(&imageID); err != nil {
+			return result, err
+		}
+		result = append(result, imageID)
+	}
+	return result, rows.Err()
+}
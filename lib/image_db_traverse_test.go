@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/mchapman87501/go_mars_2020_img_utils/lib/traverse"
+)
+
+func TestImageDBTraverse(t *testing.T) {
+	idb := newTempDB(t)
+	loadSampleData(&idb, t)
+
+	trav, err := idb.Traverse()
+	if err != nil {
+		t.Fatal("Error building traverse:", err)
+	}
+	if len(trav.Positions) != 2 {
+		t.Fatalf("Expected 2 traverse positions, got %v", len(trav.Positions))
+	}
+
+	pos := trav.Positions[1]
+	if (pos.Site != 3) || (pos.Drive != 792) || (pos.FirstSol != 24) {
+		t.Errorf("Unexpected traverse position %+v", pos)
+	}
+	if !pos.HasXYZ() {
+		t.Errorf("Expected position %v/%v to have known coordinates", pos.Site, pos.Drive)
+	}
+	if trav.Positions[0].HasXYZ() {
+		t.Error("Expected position with 'UNK' xyz to have unknown coordinates")
+	}
+
+	imageIDs, err := idb.ImagesAt(3, 792)
+	if err != nil {
+		t.Fatal("Error retrieving images at site/drive:", err)
+	}
+	if len(imageIDs) != pos.NumImages() {
+		t.Errorf("Expected %v images at 3/792, got %v", pos.NumImages(), len(imageIDs))
+	}
+}
+
+func TestAddSolColumn(t *testing.T) {
+	// Simulate a database created before the sol column was added.
+	idb := newTempDB(t)
+	if _, err := idb.DB.Exec("DROP TABLE Images"); err != nil {
+		t.Fatal(err)
+	}
+	oldSchema := "CREATE TABLE Images (image_id TEXT NOT NULL PRIMARY KEY)"
+	if _, err := idb.DB.Exec(oldSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := idb.addMissingColumns(); err != nil {
+		t.Fatal("Error adding missing columns:", err)
+	}
+	columns, err := idb.columnNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !columns["sol"] {
+		t.Errorf("Expected sol column to be added; columns are %v", columns)
+	}
+}
+
+func TestImageDBTraverseUnknownSols(t *testing.T) {
+	idb := newTempDB(t)
+	loadSampleData(&idb, t)
+	// Rows added before the sol column was added have no sol.
+	if _, err := idb.DB.Exec("UPDATE Images SET sol = NULL"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := idb.TraverseRecords()
+	if err != nil {
+		t.Fatal("Error reading traverse records:", err)
+	}
+	for _, record := range records {
+		if record.Sol != traverse.UnknownSol {
+			t.Fatalf("Expected unknown sols, got %+v", record)
+		}
+	}
+	for _, pos := range traverse.New(records).Positions {
+		if pos.HasSols() {
+			t.Errorf("Expected position %v/%v to have unknown sols, got %v ... %v", pos.Site, pos.Drive, pos.FirstSol, pos.LastSol)
+		}
+	}
+}
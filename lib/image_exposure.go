@@ -21,6 +21,16 @@ type ImageExposure struct {
 }
 
 func NewImageExposure(image *lib_image.CIELab) *ImageExposure {
+	return newMaskedImageExposure(image, nil, 0)
+}
+
+// Get the exposure of the pixels for which include returns true.  A nil
+// include function includes all pixels.  Parts of the image are processed
+// by up to workers goroutines; workers <= 0 means one per CPU.
+func newMaskedImageExposure(img lib_image.LabImage, include func(x, y int) bool, workers int) *ImageExposure {
+	if include == nil {
+		include = func(x, y int) bool { return true }
+	}
 	result := &ImageExposure{
 		BinMinVal: 0.0,
 		BinScale:  1.0,
@@ -32,37 +42,79 @@ func NewImageExposure(image *lib_image.CIELab) *ImageExposure {
 	// This can happen when building a composite image out of tiles that
 	// have widely varying exposure ranges.
 	// Map the actual range onto 0...lhBuckets-1
-	min := image.Bounds().Min
-	max := image.Bounds().Max
+	numBands := lib_image.NumWorkers(workers)
+	type bandRange struct {
+		found    bool
+		min, max float64
+	}
+	ranges := make([]bandRange, numBands)
+	lib_image.ForEachImagePart(img, workers, func(i int, band image.Rectangle) {
+		r := &ranges[i]
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				if !include(x, y) {
+					continue
+				}
+				lab := img.CIELabAt(x, y)
+				if !r.found || lab.L < r.min {
+					r.min = lab.L
+				}
+				if !r.found || lab.L > r.max {
+					r.max = lab.L
+				}
+				r.found = true
+			}
+		}
+	})
 
 	first := true
 	labLMin := 0.0
 	labLMax := 100.0
-	for x := min.X; x < max.X; x++ {
-		for y := min.Y; y < max.Y; y++ {
-			lab := image.CIELabAt(x, y)
-			if first || lab.L < labLMin {
-				labLMin = lab.L
-			}
-			if first || lab.L > labLMax {
-				labLMax = lab.L
-			}
-			first = false
+	for _, r := range ranges {
+		if !r.found {
+			continue
+		}
+		if first || r.min < labLMin {
+			labLMin = r.min
+		}
+		if first || r.max > labLMax {
+			labLMax = r.max
 		}
+		first = false
 	}
 	scale := float64(numBuckets-1) / (labLMax - labLMin)
 	result.BinMinVal = labLMin
 	result.BinScale = scale
-	for x := min.X; x < max.X; x++ {
-		for y := min.Y; y < max.Y; y++ {
-			lab := image.CIELabAt(x, y)
-			index := int((lab.L - labLMin) * scale)
-			result.Histogram[index] += 1
+
+	histograms := make([][]int, numBands)
+	counts := make([]int, numBands)
+	lib_image.ForEachImagePart(img, workers, func(i int, band image.Rectangle) {
+		// A worker may be called for several parts.
+		if histograms[i] == nil {
+			histograms[i] = make([]int, numBuckets)
+		}
+		histogram := histograms[i]
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				if !include(x, y) {
+					continue
+				}
+				lab := img.CIELabAt(x, y)
+				index := int((lab.L - labLMin) * scale)
+				histogram[index] += 1
+				counts[i] += 1
+			}
+		}
+	})
+	numPixels := 0
+	for i, histogram := range histograms {
+		for j, n := range histogram {
+			result.Histogram[j] += n
 		}
+		numPixels += counts[i]
 	}
 
 	// Compute the PDFs
-	numPixels := image.Bounds().Dx() * image.Bounds().Dy()
 	if numPixels > 0 {
 		for i := 0; i < numBuckets; i++ {
 			pdf := float64(result.Histogram[i]) / float64(numPixels)
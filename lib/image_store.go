@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"math"
+)
+
+// ImageStore is implemented by anything that can store and retrieve
+// image metadata.  ImageDB stores metadata in SQLite; MemImageStore keeps
+// it in memory.
+type ImageStore interface {
+	// Add or update records, keyed by image ID.
+	AddOrUpdate(records []ImageInfo) error
+	// Get the record for an image.
+	Image(imageID string) (ImageInfo, error)
+	// Get the thumbnail URL for an image.
+	ThumbnailURL(imageID string) (string, error)
+	// Get the full-resolution URL for an image.
+	FullSizeURL(imageID string) (string, error)
+	// Get all records matching a query, ordered by image ID.
+	Query(query ImageQuery) ([]ImageInfo, error)
+	// Get the names of all cameras, sorted.
+	Cameras() []string
+}
+
+// ImageQuery selects image records.  Zero-valued fields match any record.
+type ImageQuery struct {
+	Camera     string
+	SampleType string
+	// Match any of these color types.  See the note about color types in
+	// composite_image_set.go.
+	ColorTypes []string
+	// Match this scale factor exactly.
+	ScaleFactor float64
+	// Match only records that have a subframe rectangle with positive extent.
+	HasSubframeRect bool
+}
+
+// Get the color type for an image record.
+func (info ImageInfo) ColorType() string {
+	return getColorTypeStr(info.ImageID)
+}
+
+// Does a record satisfy a query?
+func (query ImageQuery) Matches(info ImageInfo) bool {
+	if (query.Camera != "") && (info.Camera.Instrument != query.Camera) {
+		return false
+	}
+	if (query.SampleType != "") && (info.SampleType != query.SampleType) {
+		return false
+	}
+	if len(query.ColorTypes) > 0 {
+		colorType := info.ColorType()
+		found := false
+		for _, ct := range query.ColorTypes {
+			if ct == colorType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if (query.ScaleFactor != 0.0) && (float64(info.Extended.ScaleFactor) != query.ScaleFactor) {
+		return false
+	}
+	if query.HasSubframeRect {
+		size := info.Extended.SubframeRect.Size
+		if (size.Width <= 0) || (size.Height <= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Order float64 values with NaNs first, as SQLite orders NULLs.
+func lessNaNFirst(a, b float64) bool {
+	if math.IsNaN(a) {
+		return !math.IsNaN(b)
+	}
+	if math.IsNaN(b) {
+		return false
+	}
+	return a < b
+}
@@ -1,74 +1,246 @@
 package lib
 
 import (
+	"fmt"
+	"math"
 	"sort"
 )
 
-type Float64Interpolator struct {
-	// Mapping from known input values to known output values
-	// Uses scaled input values
-	yVals map[int]float64
-	// Ordered keys from values:
-	xVals []int
+// InterpolationKind determines how a Float64Interpolator fills the gaps
+// between known values.
+type InterpolationKind int
+
+const (
+	// Straight lines between known values.
+	InterpLinear InterpolationKind = iota
+	// A monotone cubic Hermite spline (Fritsch-Carlson), which is smooth
+	// and never overshoots: it is monotone wherever the known values are.
+	InterpMonotoneCubic
+	// The value of the nearest known input.
+	InterpNearest
+)
+
+var interpolationKindNames = map[InterpolationKind]string{
+	InterpLinear:        "linear",
+	InterpMonotoneCubic: "cubic",
+	InterpNearest:       "nearest",
+}
+
+func (kind InterpolationKind) String() string {
+	if name, ok := interpolationKindNames[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("InterpolationKind(%d)", int(kind))
+}
+
+// Get the InterpolationKind with a given name, e.g., "cubic".
+func ParseInterpolationKind(name string) (InterpolationKind, error) {
+	for kind, kindName := range interpolationKindNames {
+		if kindName == name {
+			return kind, nil
+		}
+	}
+	return InterpLinear, fmt.Errorf("unknown interpolation kind %q", name)
+}
+
+// EndPolicy determines how a Float64Interpolator handles inputs beyond its
+// first and last known inputs.
+type EndPolicy int
+
+const (
+	// Continue the curve along the slope at its ends.  Nearest
+	// interpolation has no slope, and so clamps.
+	EndExtrapolate EndPolicy = iota
+	// Hold the values at the ends.
+	EndClamp
+)
+
+type InterpolatorOptions struct {
+	Kind InterpolationKind
+	Ends EndPolicy
 }
 
-func toFixed(fval float64) int {
-	return int(fval * 1000000)
+// Float64Interpolator maps inputs to outputs by interpolating between known
+// values.  It is immutable, and therefore safe for concurrent use.
+type Float64Interpolator struct {
+	// Known inputs, increasing, and their outputs.
+	xs, ys []float64
+	// Tangents at each known input, for InterpMonotoneCubic.
+	tangents []float64
+	opts     InterpolatorOptions
+
+	// Optional lookup table spanning [lutMin, lutMin + (len(lut)-1)/lutScale].
+	lut      []float64
+	lutMin   float64
+	lutScale float64
 }
 
+// Create a linear interpolator that extrapolates, from a map of known
+// inputs to outputs.
 func NewFloat64Interpolator(yVals map[float64]float64) *Float64Interpolator {
-	xVals := make([]int, 0, len(yVals))
-	myYVals := make(map[int]float64, len(yVals))
-	for k, v := range yVals {
-		fixed := toFixed(k)
-		xVals = append(xVals, fixed)
-		myYVals[fixed] = v
+	return NewFloat64InterpolatorWithOptions(yVals, InterpolatorOptions{})
+}
+
+func NewFloat64InterpolatorWithOptions(yVals map[float64]float64, opts InterpolatorOptions) *Float64Interpolator {
+	xs := make([]float64, 0, len(yVals))
+	for x := range yVals {
+		xs = append(xs, x)
+	}
+	sort.Float64s(xs)
+	ys := make([]float64, len(xs))
+	for i, x := range xs {
+		ys[i] = yVals[x]
+	}
+
+	result := &Float64Interpolator{xs: xs, ys: ys, opts: opts}
+	if opts.Kind == InterpMonotoneCubic {
+		result.tangents = monotoneTangents(xs, ys)
+	}
+	return result
+}
+
+// Get Fritsch-Carlson tangents for a monotone cubic Hermite spline.
+// See Fritsch and Carlson, "Monotone Piecewise Cubic Interpolation",
+// SIAM Journal on Numerical Analysis, 1980.
+func monotoneTangents(xs, ys []float64) []float64 {
+	n := len(xs)
+	result := make([]float64, n)
+	if n < 2 {
+		return result
+	}
+	secants := make([]float64, n-1)
+	for k := range secants {
+		secants[k] = (ys[k+1] - ys[k]) / (xs[k+1] - xs[k])
 	}
-	sort.Ints(xVals)
 
-	return &Float64Interpolator{yVals: myYVals, xVals: xVals}
+	result[0] = secants[0]
+	result[n-1] = secants[n-2]
+	for k := 1; k < n-1; k++ {
+		if secants[k-1]*secants[k] > 0.0 {
+			result[k] = (secants[k-1] + secants[k]) / 2.0
+		}
+	}
+
+	// Limit tangents so that each segment is monotone.
+	for k, d := range secants {
+		if d == 0.0 {
+			result[k] = 0.0
+			result[k+1] = 0.0
+			continue
+		}
+		alpha := result[k] / d
+		beta := result[k+1] / d
+		if sumSq := alpha*alpha + beta*beta; sumSq > 9.0 {
+			tau := 3.0 / math.Sqrt(sumSq)
+			result[k] = tau * alpha * d
+			result[k+1] = tau * beta * d
+		}
+	}
+	return result
+}
+
+// Get a copy of the interpolator that looks up inputs in [min, max] in a
+// table of n precomputed values, interpolating linearly between entries.
+// This is faster than exact evaluation when there are many known values,
+// at the cost of accuracy near sharp bends.  Inputs outside [min, max] are
+// evaluated exactly.
+func (interp *Float64Interpolator) WithLUT(min, max float64, n int) *Float64Interpolator {
+	result := *interp
+	if (n < 2) || !(max > min) {
+		result.lut = nil
+		return &result
+	}
+	result.lut = make([]float64, n)
+	result.lutMin = min
+	result.lutScale = float64(n-1) / (max - min)
+	for i := range result.lut {
+		result.lut[i] = interp.eval(min + float64(i)/result.lutScale)
+	}
+	return &result
 }
 
 func (interp *Float64Interpolator) Interp(x float64) float64 {
-	if len(interp.xVals) <= 0 {
+	if interp.lut != nil {
+		t := (x - interp.lutMin) * interp.lutScale
+		if (t >= 0.0) && (t <= float64(len(interp.lut)-1)) {
+			i := int(t)
+			if i >= len(interp.lut)-1 {
+				return interp.lut[len(interp.lut)-1]
+			}
+			fract := t - float64(i)
+			return interp.lut[i] + fract*(interp.lut[i+1]-interp.lut[i])
+		}
+	}
+	return interp.eval(x)
+}
+
+func (interp *Float64Interpolator) eval(x float64) float64 {
+	n := len(interp.xs)
+	if n <= 0 {
 		return x
 	}
-	fixed := toFixed(x)
-	result, ok := interp.yVals[fixed]
-	if ok {
-		return result
+	if n == 1 {
+		return interp.ys[0]
 	}
-	xPrev, xNext := interp.bisectLeft(fixed)
-	xOffset := fixed - xPrev
-	fract := float64(xOffset) / float64(xNext-xPrev)
-	yPrev := interp.yVals[xPrev]
-	yNext := interp.yVals[xNext]
 
-	// Cache the new result.
-	result = yPrev + fract*(yNext-yPrev)
-	interp.yVals[fixed] = result
-	return result
+	first := interp.xs[0]
+	last := interp.xs[n-1]
+	if (x < first) || (x > last) {
+		if (interp.opts.Ends == EndClamp) || (interp.opts.Kind == InterpNearest) {
+			if x < first {
+				return interp.ys[0]
+			}
+			return interp.ys[n-1]
+		}
+		if interp.opts.Kind == InterpMonotoneCubic {
+			if x < first {
+				return interp.ys[0] + interp.tangents[0]*(x-first)
+			}
+			return interp.ys[n-1] + interp.tangents[n-1]*(x-last)
+		}
+		// Linear extrapolation continues the end segments, below.
+	}
+
+	i := interp.bisectLeft(x)
+	x0, x1 := interp.xs[i-1], interp.xs[i]
+	y0, y1 := interp.ys[i-1], interp.ys[i]
+	switch interp.opts.Kind {
+	case InterpNearest:
+		if x-x0 < x1-x {
+			return y0
+		}
+		return y1
+	case InterpMonotoneCubic:
+		h := x1 - x0
+		t := (x - x0) / h
+		t2 := t * t
+		t3 := t2 * t
+		return (2.0*t3-3.0*t2+1.0)*y0 + (t3-2.0*t2+t)*h*interp.tangents[i-1] +
+			(-2.0*t3+3.0*t2)*y1 + (t3-t2)*h*interp.tangents[i]
+	}
+	fract := (x - x0) / (x1 - x0)
+	return y0 + fract*(y1-y0)
 }
 
-func (interp *Float64Interpolator) bisectLeft(fixed int) (xPrev, xNext int) {
+// Get the index of the known input that ends the segment containing x.
+// The result is in [1, len(xs) - 1]; inputs beyond the ends map to the end
+// segments.  There must be at least two known inputs.
+func (interp *Float64Interpolator) bisectLeft(x float64) int {
 	// This is derived from Python's bisect.py.
 	lo := 0
-	hi := len(interp.xVals)
+	hi := len(interp.xs)
 	for lo < hi {
 		mid := int(lo+hi) / 2
-		if interp.xVals[mid] > fixed {
+		if interp.xs[mid] > x {
 			hi = mid
 		} else {
 			lo = mid + 1
 		}
 	}
-	nextIndex := lo
-	if nextIndex <= 0 {
-		nextIndex = 1
-	} else if nextIndex >= len(interp.xVals) {
-		nextIndex = len(interp.xVals) - 1
+	if lo <= 0 {
+		return 1
+	} else if lo >= len(interp.xs) {
+		return len(interp.xs) - 1
 	}
-	xPrev = interp.xVals[nextIndex-1]
-	xNext = interp.xVals[nextIndex]
-	return
+	return lo
 }
@@ -2,15 +2,15 @@ package lib
 
 import (
 	"math"
+	"sync"
 	"testing"
 )
 
 func BisectLeftTC(interp *Float64Interpolator, x float64, wantXPrev, wantXNext float64, t *testing.T) {
-	gotXPrev, gotXNext := interp.bisectLeft(toFixed(x))
-	fixedPrev := toFixed(wantXPrev)
-	fixedNext := toFixed(wantXNext)
-	if (gotXPrev != fixedPrev) || (gotXNext != fixedNext) {
-		t.Errorf("Failed BisectLeft.  Given %v, wanted (%v, %v), got (%v, %v)", x, fixedPrev, fixedNext, gotXPrev, gotXNext)
+	i := interp.bisectLeft(x)
+	gotXPrev, gotXNext := interp.xs[i-1], interp.xs[i]
+	if (gotXPrev != wantXPrev) || (gotXNext != wantXNext) {
+		t.Errorf("Failed BisectLeft.  Given %v, wanted (%v, %v), got (%v, %v)", x, wantXPrev, wantXNext, gotXPrev, gotXNext)
 	}
 
 }
@@ -126,3 +126,110 @@ func TestInterpEvenCount(t *testing.T) {
 		InterpTC(interp, tc.x, tc.want, t)
 	}
 }
+
+func TestInterpKindsAndEnds(t *testing.T) {
+	yVals := map[float64]float64{
+		0.0: 0.0,
+		1.0: 1.0,
+		2.0: 4.0,
+		3.0: 4.0,
+	}
+	testCases := []struct {
+		opts InterpolatorOptions
+		x    float64
+		want float64
+	}{
+		{InterpolatorOptions{InterpLinear, EndExtrapolate}, 1.5, 2.5},
+		{InterpolatorOptions{InterpLinear, EndExtrapolate}, -1.0, -1.0},
+		{InterpolatorOptions{InterpLinear, EndClamp}, -1.0, 0.0},
+		{InterpolatorOptions{InterpLinear, EndClamp}, 5.0, 4.0},
+		{InterpolatorOptions{InterpNearest, EndExtrapolate}, 1.4, 1.0},
+		{InterpolatorOptions{InterpNearest, EndExtrapolate}, 1.6, 4.0},
+		{InterpolatorOptions{InterpNearest, EndExtrapolate}, 9.0, 4.0},
+		{InterpolatorOptions{InterpMonotoneCubic, EndExtrapolate}, 2.0, 4.0},
+		// The flat final segment stays flat.
+		{InterpolatorOptions{InterpMonotoneCubic, EndExtrapolate}, 2.5, 4.0},
+		{InterpolatorOptions{InterpMonotoneCubic, EndExtrapolate}, 4.0, 4.0},
+		{InterpolatorOptions{InterpMonotoneCubic, EndExtrapolate}, -1.0, -1.0},
+		{InterpolatorOptions{InterpMonotoneCubic, EndClamp}, -1.0, 0.0},
+	}
+	for _, tc := range testCases {
+		interp := NewFloat64InterpolatorWithOptions(yVals, tc.opts)
+		if got := interp.Interp(tc.x); math.Abs(got-tc.want) > 1.0e-9 {
+			t.Errorf("%v, ends %v: interp(%v), wanted %v, got %v", tc.opts.Kind, tc.opts.Ends, tc.x, tc.want, got)
+		}
+	}
+}
+
+func TestMonotoneCubicDoesNotOvershoot(t *testing.T) {
+	// A step, which an ordinary cubic spline would overshoot.
+	yVals := map[float64]float64{0.0: 0.0, 1.0: 0.0, 2.0: 10.0, 3.0: 10.0, 4.0: 10.5}
+	interp := NewFloat64InterpolatorWithOptions(yVals, InterpolatorOptions{Kind: InterpMonotoneCubic})
+	prev := interp.Interp(0.0)
+	for x := 0.01; x <= 4.0; x += 0.01 {
+		got := interp.Interp(x)
+		if (got < prev-1.0e-12) || (got < 0.0) || (got > 10.5) {
+			t.Fatalf("Expected a monotone curve within [0, 10.5]; interp(%v) = %v after %v", x, got, prev)
+		}
+		prev = got
+	}
+}
+
+func TestInterpDegenerate(t *testing.T) {
+	if got := NewFloat64Interpolator(map[float64]float64{}).Interp(3.0); got != 3.0 {
+		t.Errorf("Expected an empty interpolator to be the identity, got %v", got)
+	}
+	single := NewFloat64Interpolator(map[float64]float64{1.0: 7.0})
+	if got := single.Interp(3.0); got != 7.0 {
+		t.Errorf("Expected a single known value to be constant, got %v", got)
+	}
+}
+
+func TestInterpLUT(t *testing.T) {
+	yVals := map[float64]float64{0.0: 0.0, 10.0: 20.0, 30.0: 25.0, 100.0: 100.0}
+	for _, kind := range []InterpolationKind{InterpLinear, InterpMonotoneCubic} {
+		exact := NewFloat64InterpolatorWithOptions(yVals, InterpolatorOptions{Kind: kind})
+		table := exact.WithLUT(0.0, 100.0, 1001)
+		for x := -5.0; x <= 105.0; x += 0.37 {
+			if got, want := table.Interp(x), exact.Interp(x); math.Abs(got-want) > 0.01 {
+				t.Errorf("%v: LUT interp(%v), wanted %v, got %v", kind, x, want, got)
+			}
+		}
+		if got := table.Interp(100.0); math.Abs(got-100.0) > 1.0e-9 {
+			t.Errorf("%v: expected LUT to reach its upper bound exactly, got %v", kind, got)
+		}
+	}
+}
+
+func TestInterpConcurrentUse(t *testing.T) {
+	yVals := map[float64]float64{0.0: 0.0, 0.5: 1.0, 1.0: 4.0}
+	interp := NewFloat64InterpolatorWithOptions(yVals, InterpolatorOptions{Kind: InterpMonotoneCubic}).WithLUT(0.0, 1.0, 256)
+	want := interp.Interp(0.3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				if got := interp.Interp(0.3); got != want {
+					t.Errorf("Expected %v, got %v", want, got)
+					return
+				}
+				interp.Interp(float64(j) / 10000.0)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestParseInterpolationKind(t *testing.T) {
+	for _, kind := range []InterpolationKind{InterpLinear, InterpMonotoneCubic, InterpNearest} {
+		parsed, err := ParseInterpolationKind(kind.String())
+		if (err != nil) || (parsed != kind) {
+			t.Errorf("Expected %v, got %v (%v)", kind, parsed, err)
+		}
+	}
+	if _, err := ParseInterpolationKind("bogus"); err == nil {
+		t.Error("Expected an error for an unknown kind")
+	}
+}
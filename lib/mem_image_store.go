@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemImageStore is an ImageStore that keeps all records in memory.
+// It is safe for concurrent use.
+type MemImageStore struct {
+	mutex   sync.RWMutex
+	records map[string]ImageInfo
+}
+
+func NewMemImageStore() *MemImageStore {
+	return &MemImageStore{records: map[string]ImageInfo{}}
+}
+
+func (store *MemImageStore) AddOrUpdate(records []ImageInfo) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, record := range records {
+		store.records[record.ImageID] = record
+	}
+	return nil
+}
+
+func (store *MemImageStore) Image(imageID string) (ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result, ok := store.records[imageID]
+	if !ok {
+		return result, fmt.Errorf("no such image: %v", imageID)
+	}
+	return result, nil
+}
+
+func (store *MemImageStore) ThumbnailURL(imageID string) (string, error) {
+	record, err := store.Image(imageID)
+	return record.ImageFiles.Small, err
+}
+
+func (store *MemImageStore) FullSizeURL(imageID string) (string, error) {
+	record, err := store.Image(imageID)
+	return record.ImageFiles.FullRes, err
+}
+
+func (store *MemImageStore) Query(query ImageQuery) ([]ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result := []ImageInfo{}
+	for _, record := range store.records {
+		if query.Matches(record) {
+			result = append(result, record)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ImageID < result[j].ImageID
+	})
+	return result, nil
+}
+
+func (store *MemImageStore) Cameras() []string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	seen := map[string]bool{}
+	result := []string{}
+	for _, record := range store.records {
+		camera := record.Camera.Instrument
+		if !seen[camera] {
+			seen[camera] = true
+			result = append(result, camera)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
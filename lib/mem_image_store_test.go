@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newFakeMemStore(t *testing.T) *MemImageStore {
+	store := NewMemImageStore()
+	loadFakeData(store, t)
+	return store
+}
+
+func newSampleMemStore(t *testing.T) *MemImageStore {
+	store := NewMemImageStore()
+	loadSampleData(store, t)
+	return store
+}
+
+func TestMemImageStoreImage(t *testing.T) {
+	store := newSampleMemStore(t)
+
+	imageID := "NLF_0024_0669080250_161ECM_N0030792NCAM00194_01_290J"
+	record, err := store.Image(imageID)
+	if err != nil {
+		t.Fatal("Error retrieving image:", err)
+	}
+	if record.ImageID != imageID {
+		t.Errorf("Expected image %v, got %v", imageID, record.ImageID)
+	}
+
+	url, err := store.FullSizeURL(imageID)
+	if (err != nil) || (url != record.ImageFiles.FullRes) {
+		t.Errorf("Unexpected full size URL %v (error %v)", url, err)
+	}
+
+	if _, err = store.Image("no such image"); err == nil {
+		t.Error("Expected an error retrieving an unknown image.")
+	}
+}
+
+func TestMemImageStoreCompositeImageSets(t *testing.T) {
+	store := newFakeMemStore(t)
+
+	imageSets, err := GetCompositeImageSets(store, "NAVCAM_LEFT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imageSets) != 1 {
+		t.Errorf("Expected 1 image set, got %v", len(imageSets))
+	}
+}
+
+func TestMemImageStoreStereoPairs(t *testing.T) {
+	store := newSampleMemStore(t)
+	pairs, err := FindStereoPairs(store)
+	if err != nil {
+		t.Fatal("Error finding stereo pairs:", err)
+	}
+	if len(pairs) != 1 {
+		t.Errorf("Expected 1 stereo pair, got %v", pairs)
+	}
+}
+
+// An ImageStore whose queries fail.
+type failingQueryStore struct {
+	*MemImageStore
+}
+
+func (store failingQueryStore) Query(query ImageQuery) ([]ImageInfo, error) {
+	return []ImageInfo{}, errors.New("query failed")
+}
+
+func TestFindStereoPairsReportsErrors(t *testing.T) {
+	if _, err := FindStereoPairs(failingQueryStore{newSampleMemStore(t)}); err == nil {
+		t.Error("Expected an error from a failing store")
+	}
+}
+
+func TestMemImageStoreCameras(t *testing.T) {
+	store := newFakeMemStore(t)
+	want := []string{"EDL_PUCAM2", "NAVCAM_LEFT", "NAVCAM_RIGHT", "SHERLOC_WATSON"}
+	got := store.Cameras()
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected cameras %v, got %v", want, got)
+	}
+}
+
+func queryIDs(store ImageStore, query ImageQuery, t *testing.T) []string {
+	records, err := store.Query(query)
+	if err != nil {
+		t.Fatal("Error querying store:", err)
+	}
+	result := []string{}
+	for _, record := range records {
+		result = append(result, record.ImageID)
+	}
+	return result
+}
+
+// ImageDB and MemImageStore should answer queries identically.
+func TestImageStoresAgree(t *testing.T) {
+	idb := recreateInMemDB(t)
+	mem := newFakeMemStore(t)
+
+	queries := []ImageQuery{
+		{},
+		{Camera: "NAVCAM_LEFT"},
+		{SampleType: "Thumbnail"},
+		{ColorTypes: []string{"E", "F"}},
+		{ScaleFactor: 4.0},
+		{Camera: "NAVCAM_LEFT", SampleType: "Full", ColorTypes: []string{"E"}, ScaleFactor: 1.0, HasSubframeRect: true},
+	}
+	for _, query := range queries {
+		dbIDs := queryIDs(&idb, query, t)
+		memIDs := queryIDs(mem, query, t)
+		if !reflect.DeepEqual(dbIDs, memIDs) {
+			t.Errorf("Query %+v: ImageDB returned %v, MemImageStore returned %v", query, dbIDs, memIDs)
+		}
+	}
+
+	if !reflect.DeepEqual(idb.Cameras(), mem.Cameras()) {
+		t.Errorf("Cameras differ: %v vs. %v", idb.Cameras(), mem.Cameras())
+	}
+}
+
+func TestImageDBRoundTrip(t *testing.T) {
+	idb := newTempDB(t)
+	loadSampleData(&idb, t)
+	mem := newSampleMemStore(t)
+
+	imageID := "NLF_0024_0669080250_161ECM_N0030792NCAM00194_01_290J"
+	want, _ := mem.Image(imageID)
+	got, err := idb.Image(imageID)
+	if err != nil {
+		t.Fatal("Error retrieving image:", err)
+	}
+
+	if (got.DateTakenUtc != want.DateTakenUtc) || (got.Sol != want.Sol) ||
+		(got.Site != want.Site) || (got.Drive != want.Drive) {
+		t.Errorf("Round trip mismatch: want %+v, got %+v", want, got)
+	}
+	if !reflect.DeepEqual(got.Attitude, want.Attitude) {
+		t.Errorf("Expected attitude %v, got %v", want.Attitude, got.Attitude)
+	}
+	if !reflect.DeepEqual(got.Extended.SubframeRect, want.Extended.SubframeRect) {
+		t.Errorf("Expected subframe %v, got %v", want.Extended.SubframeRect, got.Extended.SubframeRect)
+	}
+	if !reflect.DeepEqual(got.Extended.XYZ, want.Extended.XYZ) {
+		t.Errorf("Expected XYZ %v, got %v", want.Extended.XYZ, got.Extended.XYZ)
+	}
+	if got.Extended.Sclk != want.Extended.Sclk {
+		t.Errorf("Expected sclk %v, got %v", want.Extended.Sclk, got.Extended.Sclk)
+	}
+}
+
+func TestImageCacheUsesCachedFile(t *testing.T) {
+	store := newFakeMemStore(t)
+	cache, err := NewImageCacheAtPath(store, filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal("Error creating image cache:", err)
+	}
+
+	// Pre-populate the cache, so no download is needed.
+	imageID := "NLF_0024_0669080250_161ECM_N0030792NCAM00194_01_290J"
+	want := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	if err = SavePNG(want, cache.ThumbPath(imageID)); err != nil {
+		t.Fatal("Could not save cached thumbnail:", err)
+	}
+
+	got, err := cache.ThumbNail(imageID)
+	if err != nil {
+		t.Fatal("Error loading cached thumbnail:", err)
+	}
+	if got.Bounds() != want.Bounds() {
+		t.Errorf("Expected cached image bounds %v, got %v", want.Bounds(), got.Bounds())
+	}
+	os.RemoveAll(cache.rootdir)
+}
@@ -0,0 +1,155 @@
+package lib
+
+// Gaussian and Laplacian image pyramids, for multi-band blending.
+// See Burt and Adelson, "A Multiresolution Spline With Application to
+// Image Mosaics", ACM Transactions on Graphics, 1983.
+
+// floatPlane is a single channel of float64 pixel values.
+type floatPlane struct {
+	width, height int
+	pix           []float64
+}
+
+func newFloatPlane(width, height int) *floatPlane {
+	return &floatPlane{width, height, make([]float64, width*height)}
+}
+
+// Get a pixel value.  Coordinates outside the plane are clamped to its
+// edges.
+func (p *floatPlane) at(x, y int) float64 {
+	if x < 0 {
+		x = 0
+	} else if x >= p.width {
+		x = p.width - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= p.height {
+		y = p.height - 1
+	}
+	return p.pix[y*p.width+x]
+}
+
+func (p *floatPlane) set(x, y int, v float64) {
+	p.pix[y*p.width+x] = v
+}
+
+// 5-tap binomial approximation of a Gaussian kernel.
+var pyramidKernel = [5]float64{1.0 / 16.0, 4.0 / 16.0, 6.0 / 16.0, 4.0 / 16.0, 1.0 / 16.0}
+
+// Blur and subsample by 2 in each dimension.
+func (p *floatPlane) reduce() *floatPlane {
+	// Blur horizontally while subsampling columns, then vertically while
+	// subsampling rows.
+	width := (p.width + 1) / 2
+	height := (p.height + 1) / 2
+
+	horiz := newFloatPlane(width, p.height)
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < width; x++ {
+			sum := 0.0
+			for k, weight := range pyramidKernel {
+				sum += weight * p.at(2*x+k-2, y)
+			}
+			horiz.set(x, y, sum)
+		}
+	}
+
+	result := newFloatPlane(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sum := 0.0
+			for k, weight := range pyramidKernel {
+				sum += weight * horiz.at(x, 2*y+k-2)
+			}
+			result.set(x, y, sum)
+		}
+	}
+	return result
+}
+
+// Upsample to the given dimensions, which should be about twice those of
+// p, interpolating the missing samples.
+func (p *floatPlane) expand(width, height int) *floatPlane {
+	// Each output sample is a weighted sum of the input samples that
+	// would land near it after zero-insertion upsampling.
+	// Even output coordinates coincide with an input sample; odd
+	// coordinates lie midway between two input samples.
+	interp := func(get func(i int) float64, i int) float64 {
+		if i%2 == 0 {
+			return 0.125*get(i/2-1) + 0.75*get(i/2) + 0.125*get(i/2+1)
+		}
+		return 0.5*get(i/2) + 0.5*get(i/2+1)
+	}
+
+	horiz := newFloatPlane(width, p.height)
+	for y := 0; y < p.height; y++ {
+		get := func(i int) float64 { return p.at(i, y) }
+		for x := 0; x < width; x++ {
+			horiz.set(x, y, interp(get, x))
+		}
+	}
+
+	result := newFloatPlane(width, height)
+	for x := 0; x < width; x++ {
+		get := func(i int) float64 { return horiz.at(x, i) }
+		for y := 0; y < height; y++ {
+			result.set(x, y, interp(get, y))
+		}
+	}
+	return result
+}
+
+// Get the number of pyramid levels that a plane can support, up to
+// maxLevels.  The coarsest level must be at least a few pixels across.
+func pyramidLevels(width, height, maxLevels int) int {
+	result := 1
+	for (result < maxLevels) && (width >= 8) && (height >= 8) {
+		width = (width + 1) / 2
+		height = (height + 1) / 2
+		result += 1
+	}
+	return result
+}
+
+func gaussianPyramid(p *floatPlane, levels int) []*floatPlane {
+	result := []*floatPlane{p}
+	for i := 1; i < levels; i++ {
+		result = append(result, result[i-1].reduce())
+	}
+	return result
+}
+
+// Build a Laplacian pyramid.  Each level but the last holds the detail lost
+// in reducing to the next level.  The last level is the coarsest Gaussian
+// level.
+func laplacianPyramid(p *floatPlane, levels int) []*floatPlane {
+	gaussian := gaussianPyramid(p, levels)
+	result := make([]*floatPlane, levels)
+	for i := 0; i < levels-1; i++ {
+		fine := gaussian[i]
+		expanded := gaussian[i+1].expand(fine.width, fine.height)
+		detail := newFloatPlane(fine.width, fine.height)
+		for j := range detail.pix {
+			detail.pix[j] = fine.pix[j] - expanded.pix[j]
+		}
+		result[i] = detail
+	}
+	result[levels-1] = gaussian[levels-1]
+	return result
+}
+
+// Reconstruct a plane from its Laplacian pyramid.
+func collapsePyramid(laplacian []*floatPlane) *floatPlane {
+	levels := len(laplacian)
+	result := laplacian[levels-1]
+	for i := levels - 2; i >= 0; i-- {
+		detail := laplacian[i]
+		expanded := result.expand(detail.width, detail.height)
+		for j := range expanded.pix {
+			expanded.pix[j] += detail.pix[j]
+		}
+		result = expanded
+	}
+	return result
+}
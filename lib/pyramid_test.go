@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"math"
+	"testing"
+)
+
+func rampPlane(width, height int) *floatPlane {
+	result := newFloatPlane(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			result.set(x, y, float64(x*3+y*y%7))
+		}
+	}
+	return result
+}
+
+func TestReduceExpandSizes(t *testing.T) {
+	p := newFloatPlane(37, 20)
+	reduced := p.reduce()
+	if reduced.width != 19 || reduced.height != 10 {
+		t.Errorf("Expected reduced size 19x10, got %vx%v", reduced.width, reduced.height)
+	}
+	expanded := reduced.expand(37, 20)
+	if expanded.width != 37 || expanded.height != 20 {
+		t.Errorf("Expected expanded size 37x20, got %vx%v", expanded.width, expanded.height)
+	}
+}
+
+func TestReducePreservesConstant(t *testing.T) {
+	p := newFloatPlane(16, 9)
+	for i := range p.pix {
+		p.pix[i] = 42.0
+	}
+	for _, v := range p.reduce().expand(16, 9).pix {
+		if math.Abs(v-42.0) > 1.0e-9 {
+			t.Fatalf("Expected reduce/expand to preserve a constant plane; got %v", v)
+		}
+	}
+}
+
+func TestLaplacianReconstruction(t *testing.T) {
+	p := rampPlane(45, 31)
+	levels := pyramidLevels(p.width, p.height, 6)
+	if levels < 3 {
+		t.Fatalf("Expected at least 3 pyramid levels for 45x31, got %v", levels)
+	}
+
+	got := collapsePyramid(laplacianPyramid(p, levels))
+	for i, want := range p.pix {
+		if math.Abs(got.pix[i]-want) > 1.0e-9 {
+			t.Fatalf("Reconstruction differs at %v: want %v, got %v", i, want, got.pix[i])
+		}
+	}
+}
+
+func TestPyramidLevels(t *testing.T) {
+	if got := pyramidLevels(4, 4, 6); got != 1 {
+		t.Errorf("Expected 1 level for a tiny plane, got %v", got)
+	}
+	if got := pyramidLevels(1024, 1024, 6); got != 6 {
+		t.Errorf("Expected levels to be limited to 6, got %v", got)
+	}
+}
@@ -0,0 +1,256 @@
+package lib
+
+import (
+	"image"
+	"math"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Tile registration.  A tile's SubframeRect may be off by a pixel or so.
+// Registration estimates the tile's true offset by maximizing the normalized
+// cross-correlation of its L channel with previously composited data,
+// within RegistrationRadius pixels of its nominal position.
+
+// TileCorrection records the offset applied to a tile during registration.
+type TileCorrection struct {
+	// The tile's nominal position in the composite.
+	Rect image.Rectangle
+	// The tile's estimated true position, relative to Rect.
+	DX, DY float64
+	// Normalized cross-correlation at the chosen offset, in [-1, 1].
+	Score float64
+	// False if the tile had too little textured overlap to be registered.
+	// DX and DY are then zero.
+	Registered bool
+}
+
+// Get the part of the composite covered by a registered tile's content:
+// Rect, shifted by the correction to the nearest pixel.
+func (c TileCorrection) registeredRect() image.Rectangle {
+	return c.Rect.Add(image.Pt(int(math.Round(c.DX)), int(math.Round(c.DY))))
+}
+
+// Minimum number of overlapping pixels needed to register a tile.
+const minRegistrationSamples = 64
+
+// Get the L channel of previously composited data within overlap, or NaN
+// where there is none.  Correlation reads this copy rather than Result,
+// which may be paged.
+func (comp *Compositor) coveredL(overlap image.Rectangle) *floatPlane {
+	result := newFloatPlane(overlap.Dx(), overlap.Dy())
+	for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+		for x := overlap.Min.X; x < overlap.Max.X; x++ {
+			v := math.NaN()
+			if comp.weight(x, y) > 0.0 {
+				v = comp.Result.Channel(x, y, 0)
+			}
+			result.set(x-overlap.Min.X, y-overlap.Min.Y, v)
+		}
+	}
+	return result
+}
+
+// Variances no larger than this fraction of the sum of squares are
+// treated as no variation, to ignore rounding error in flat images.
+const minRelativeVariance = 1.0e-12
+
+// Get the normalized cross-correlation of ref with moving, displaced by
+// (dx, dy): ref's pixel (x, y) is compared with moving's pixel
+// (x, y) + origin - (dx, dy).  NaN values in ref mark missing data, and
+// are skipped.  Returns false if there are too few samples, or if either
+// side has no variation.
+func planeCorrelation(ref, moving *floatPlane, origin image.Point, dx, dy int) (float64, bool) {
+	n := 0
+	sumR, sumM, sumRR, sumMM, sumRM := 0.0, 0.0, 0.0, 0.0, 0.0
+	for y := 0; y < ref.height; y++ {
+		my := y + origin.Y - dy
+		if (my < 0) || (my >= moving.height) {
+			continue
+		}
+		for x := 0; x < ref.width; x++ {
+			mx := x + origin.X - dx
+			if (mx < 0) || (mx >= moving.width) {
+				continue
+			}
+			r := ref.pix[y*ref.width+x]
+			if math.IsNaN(r) {
+				continue
+			}
+			m := moving.pix[my*moving.width+mx]
+			n += 1
+			sumR += r
+			sumM += m
+			sumRR += r * r
+			sumMM += m * m
+			sumRM += r * m
+		}
+	}
+	if n < minRegistrationSamples {
+		return 0.0, false
+	}
+	fn := float64(n)
+	covar := sumRM - sumR*sumM/fn
+	varR := sumRR - sumR*sumR/fn
+	varM := sumMM - sumM*sumM/fn
+	if (varR <= minRelativeVariance*sumRR) || (varM <= minRelativeVariance*sumMM) {
+		return 0.0, false
+	}
+	return covar / math.Sqrt(varR*varM), true
+}
+
+// Refine an integer peak location using a parabola through the peak and
+// its two neighbors.  Returns an offset in [-0.5, 0.5].
+func parabolicPeak(before, peak, after float64) float64 {
+	denom := before - 2.0*peak + after
+	if denom >= 0.0 {
+		// Not a maximum.
+		return 0.0
+	}
+	result := 0.5 * (before - after) / denom
+	return math.Max(-0.5, math.Min(0.5, result))
+}
+
+// Estimate the displacement of moving relative to ref, to sub-pixel
+// precision, by maximizing planeCorrelation within radius pixels.  Returns
+// the displacement and its correlation, or false if no displacement could
+// be scored.
+func planeOffset(ref, moving *floatPlane, origin image.Point, radius int) (dx, dy, score float64, ok bool) {
+	size := 2*radius + 1
+	scores := make([]float64, size*size)
+	valid := make([]bool, size*size)
+	bestI := -1
+	for iy := -radius; iy <= radius; iy++ {
+		for ix := -radius; ix <= radius; ix++ {
+			i := (iy+radius)*size + (ix + radius)
+			scores[i], valid[i] = planeCorrelation(ref, moving, origin, ix, iy)
+			if valid[i] && ((bestI < 0) || (scores[i] > scores[bestI])) {
+				bestI = i
+			}
+		}
+	}
+	if bestI < 0 {
+		return 0.0, 0.0, 0.0, false
+	}
+
+	bx := bestI % size
+	by := bestI / size
+	dx = float64(bx - radius)
+	dy = float64(by - radius)
+	score = scores[bestI]
+	// Sub-pixel refinement, where the peak has valid neighbors.
+	if (bx > 0) && (bx < size-1) && valid[bestI-1] && valid[bestI+1] {
+		dx += parabolicPeak(scores[bestI-1], score, scores[bestI+1])
+	}
+	if (by > 0) && (by < size-1) && valid[bestI-size] && valid[bestI+size] {
+		dy += parabolicPeak(scores[bestI-size], score, scores[bestI+size])
+	}
+	return dx, dy, score, true
+}
+
+// Get a tile's L channel.
+func lPlane(tile *lib_image.CIELab) *floatPlane {
+	bounds := tile.Bounds()
+	result := newFloatPlane(bounds.Dx(), bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			result.set(x-bounds.Min.X, y-bounds.Min.Y, tile.Pix[tile.PixOffset(x, y)])
+		}
+	}
+	return result
+}
+
+// Estimate a tile's offset from its nominal position destRect.
+func (comp *Compositor) estimateOffset(tile *lib_image.CIELab, destRect image.Rectangle) TileCorrection {
+	result := TileCorrection{Rect: destRect}
+	tileOffset := destRect.Min.Sub(tile.Bounds().Min)
+	overlap := destRect.Intersect(comp.Bounds)
+
+	if overlap.Empty() {
+		return result
+	}
+	// Composite pixel overlap.Min + (x, y) is tile pixel
+	// overlap.Min + (x, y) - tileOffset.
+	origin := overlap.Min.Sub(tileOffset).Sub(tile.Bounds().Min)
+	dx, dy, score, ok := planeOffset(comp.coveredL(overlap), lPlane(tile), origin, comp.RegistrationRadius)
+	if ok {
+		result.DX, result.DY, result.Score, result.Registered = dx, dy, score, true
+	}
+	return result
+}
+
+// Get the pixels and weights for bilinear interpolation at (x, y) in a
+// width x height grid.  Coordinates outside the grid are clamped to its
+// edges.
+func bilinearTaps(x, y float64, width, height int) (x0, y0, x1, y1 int, fx, fy float64) {
+	x = math.Max(0.0, math.Min(float64(width-1), x))
+	y = math.Max(0.0, math.Min(float64(height-1), y))
+	x0 = int(math.Floor(x))
+	y0 = int(math.Floor(y))
+	x1 = x0 + 1
+	if x1 >= width {
+		x1 = x0
+	}
+	y1 = y0 + 1
+	if y1 >= height {
+		y1 = y0
+	}
+	return x0, y0, x1, y1, x - float64(x0), y - float64(y0)
+}
+
+// Get a pixel value by bilinear interpolation.  Coordinates outside the
+// image are clamped to its edges.
+func bilinearLab(img *lib_image.CIELab, x, y float64) lib_color.CIELab {
+	min := img.Bounds().Min
+	size := img.Bounds().Size()
+	x0, y0, x1, y1, fx, fy := bilinearTaps(x-float64(min.X), y-float64(min.Y), size.X, size.Y)
+	at := func(x, y int) lib_color.CIELab { return img.CIELabAt(x+min.X, y+min.Y) }
+
+	top := mixLab(at(x0, y0), at(x1, y0), fx)
+	bottom := mixLab(at(x0, y1), at(x1, y1), fx)
+	return mixLab(top, bottom, fy)
+}
+
+// Get a copy of a tile whose content is shifted by (dx, dy) pixels.
+func translatedLab(tile *lib_image.CIELab, dx, dy float64) *lib_image.CIELab {
+	bounds := tile.Bounds()
+	result := lib_image.NewCIELab(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			result.SetCIELab(x, y, bilinearLab(tile, float64(x)-dx, float64(y)-dy))
+		}
+	}
+	return result
+}
+
+// Get a value by bilinear interpolation.  Coordinates outside the plane
+// are clamped to its edges.
+func (p *floatPlane) bilinear(x, y float64) float64 {
+	x0, y0, x1, y1, fx, fy := bilinearTaps(x, y, p.width, p.height)
+	top := p.at(x0, y0)*(1.0-fx) + p.at(x1, y0)*fx
+	bottom := p.at(x0, y1)*(1.0-fx) + p.at(x1, y1)*fx
+	return top*(1.0-fy) + bottom*fy
+}
+
+// Get a copy of a plane whose content is shifted by (dx, dy) pixels.
+func (p *floatPlane) translated(dx, dy float64) *floatPlane {
+	result := newFloatPlane(p.width, p.height)
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			result.set(x, y, p.bilinear(float64(x)-dx, float64(y)-dy))
+		}
+	}
+	return result
+}
+
+// Register a tile against previously composited data, recording the
+// correction.  Returns the tile, translated so that it aligns with destRect.
+func (comp *Compositor) register(tile *lib_image.CIELab, destRect image.Rectangle) *lib_image.CIELab {
+	correction := comp.estimateOffset(tile, destRect)
+	comp.Corrections = append(comp.Corrections, correction)
+	if (correction.DX == 0.0) && (correction.DY == 0.0) {
+		return tile
+	}
+	return translatedLab(tile, correction.DX, correction.DY)
+}
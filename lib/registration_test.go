@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// A smoothly textured scene.
+func sceneL(x, y float64) float64 {
+	return 50.0 + 20.0*math.Sin(x/5.0) + 15.0*math.Cos(y/7.0) + 10.0*math.Sin((x+y)/11.0)
+}
+
+// Render a tile of the scene.  The tile's nominal position is destRect,
+// but its true position is offset from that by (dx, dy).
+func sceneTile(destRect image.Rectangle, dx, dy float64) *lib_image.CIELab {
+	result := lib_image.NewCIELab(image.Rect(0, 0, destRect.Dx(), destRect.Dy()))
+	for y := 0; y < destRect.Dy(); y++ {
+		for x := 0; x < destRect.Dx(); x++ {
+			sx := float64(destRect.Min.X+x) + dx
+			sy := float64(destRect.Min.Y+y) + dy
+			result.SetCIELab(x, y, lib_color.CIELab{L: sceneL(sx, sy)})
+		}
+	}
+	return result
+}
+
+func TestRegistrationFindsOffset(t *testing.T) {
+	leftRect := image.Rect(0, 0, 64, 48)
+	rightRect := image.Rect(40, 0, 104, 48)
+
+	for _, offset := range [][2]float64{{0.0, 0.0}, {2.0, -1.0}, {1.5, -0.75}, {-2.25, 0.5}} {
+		comp := NewCompositor(leftRect.Union(rightRect))
+		comp.RegistrationRadius = 3
+		comp.blend(sceneTile(leftRect, 0.0, 0.0), leftRect)
+		comp.recordArea(leftRect, leftRect)
+
+		tile := comp.register(sceneTile(rightRect, offset[0], offset[1]), rightRect)
+		if len(comp.Corrections) != 1 {
+			t.Fatalf("Expected 1 correction, got %v", len(comp.Corrections))
+		}
+		correction := comp.Corrections[0]
+		if !correction.Registered {
+			t.Fatalf("Expected tile to be registered: %#v", correction)
+		}
+		if (math.Abs(correction.DX-offset[0]) > 0.25) || (math.Abs(correction.DY-offset[1]) > 0.25) {
+			t.Errorf("Expected offset %v, got (%v, %v)", offset, correction.DX, correction.DY)
+		}
+
+		// Away from its edges, the registered tile should match the scene.
+		for y := 8; y < 40; y++ {
+			for x := 8; x < 56; x++ {
+				want := sceneL(float64(rightRect.Min.X+x), float64(rightRect.Min.Y+y))
+				if got := tile.CIELabAt(x, y).L; math.Abs(got-want) > 1.0 {
+					t.Fatalf("Offset %v: expected L %v at (%v, %v), got %v", offset, want, x, y, got)
+				}
+			}
+		}
+	}
+}
+
+func TestRegistrationNeedsOverlap(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 48)
+	comp := NewCompositor(rect)
+	comp.RegistrationRadius = 3
+
+	tile := sceneTile(rect, 1.0, 1.0)
+	if got := comp.register(tile, rect); got != tile {
+		t.Error("Expected a tile with no overlap to be left alone")
+	}
+	if comp.Corrections[0].Registered {
+		t.Errorf("Expected no registration without overlap: %#v", comp.Corrections[0])
+	}
+}
+
+func TestRegistrationDisabledByDefault(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	comp := NewCompositor(rect)
+	comp.AddImage(image.NewRGBA(image.Rect(0, 0, 16, 16)), rect)
+	if len(comp.Corrections) != 0 {
+		t.Errorf("Expected no corrections, got %v", comp.Corrections)
+	}
+}
+
+func TestParabolicPeak(t *testing.T) {
+	// Samples of -(x - 0.3)^2 at -1, 0, 1.
+	f := func(x float64) float64 { return -(x - 0.3) * (x - 0.3) }
+	if got := parabolicPeak(f(-1.0), f(0.0), f(1.0)); math.Abs(got-0.3) > 1.0e-9 {
+		t.Errorf("Expected peak at 0.3, got %v", got)
+	}
+	if got := parabolicPeak(1.0, 0.0, 1.0); got != 0.0 {
+		t.Errorf("Expected no refinement at a minimum, got %v", got)
+	}
+}
+
+func TestCoveredEdgeDistanceFollowsRegistration(t *testing.T) {
+	leftRect := image.Rect(0, 0, 64, 48)
+	rightRect := image.Rect(40, 0, 104, 48)
+	comp := NewCompositor(image.Rect(0, 0, 104, 64))
+	correction := TileCorrection{Rect: rightRect, DX: 2.4, DY: -0.6, Registered: true}
+	comp.recordArea(leftRect, leftRect)
+	comp.recordArea(rightRect, correction.registeredRect())
+
+	tests := []struct {
+		x, y int
+		want int
+	}{
+		// Left of the right tile's registered content
+		{41, 20, 22},
+		// 18 pixels inside the registered content; 20 inside the nominal rect
+		{60, 20, 18},
+		{10, 55, -1},
+	}
+	for _, test := range tests {
+		if got := comp.coveredEdgeDistance(test.x, test.y); got != test.want {
+			t.Errorf("Expected %v at (%v, %v), got %v", test.want, test.x, test.y, got)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+)
+
+// ResampleFilter determines how tiles are interpolated when they are
+// resized to the composite's output scale.
+type ResampleFilter int
+
+const (
+	// Take the nearest source pixel.
+	ResampleNearest ResampleFilter = iota
+	// Interpolate linearly between the nearest source pixels.
+	ResampleBilinear
+	// Catmull-Rom cubic spline.
+	ResampleCatmullRom
+	// Windowed sinc with 3 lobes.  Sharpest, but may ring at hard edges.
+	ResampleLanczos3
+)
+
+var resampleFilterNames = map[ResampleFilter]string{
+	ResampleNearest:    "nearest",
+	ResampleBilinear:   "bilinear",
+	ResampleCatmullRom: "catmullrom",
+	ResampleLanczos3:   "lanczos3",
+}
+
+func (filter ResampleFilter) String() string {
+	if name, ok := resampleFilterNames[filter]; ok {
+		return name
+	}
+	return fmt.Sprintf("ResampleFilter(%d)", int(filter))
+}
+
+// Get the ResampleFilter with a given name, e.g., "bilinear".
+func ParseResampleFilter(name string) (ResampleFilter, error) {
+	for filter, filterName := range resampleFilterNames {
+		if filterName == name {
+			return filter, nil
+		}
+	}
+	return ResampleNearest, fmt.Errorf("unknown resample filter %q", name)
+}
+
+func sinc(x float64) float64 {
+	if x == 0.0 {
+		return 1.0
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// Get a filter's kernel and its support radius, in source pixels at
+// unit scale.
+func (filter ResampleFilter) kernel() (func(x float64) float64, float64) {
+	switch filter {
+	case ResampleBilinear:
+		return func(x float64) float64 {
+			return math.Max(0.0, 1.0-math.Abs(x))
+		}, 1.0
+	case ResampleCatmullRom:
+		return func(x float64) float64 {
+			x = math.Abs(x)
+			if x < 1.0 {
+				return 1.5*x*x*x - 2.5*x*x + 1.0
+			}
+			if x < 2.0 {
+				return -0.5*x*x*x + 2.5*x*x - 4.0*x + 2.0
+			}
+			return 0.0
+		}, 2.0
+	case ResampleLanczos3:
+		return func(x float64) float64 {
+			if math.Abs(x) >= 3.0 {
+				return 0.0
+			}
+			return sinc(x) * sinc(x/3.0)
+		}, 3.0
+	}
+	// Nearest neighbor.
+	return func(x float64) float64 {
+		if (x >= -0.5) && (x < 0.5) {
+			return 1.0
+		}
+		return 0.0
+	}, 0.5
+}
+
+// The source pixels, and their weights, that contribute to one
+// destination pixel.
+type resampleTaps struct {
+	first   int
+	weights []float64
+}
+
+// Compute resampling taps for one axis.  When reducing, the kernel is
+// stretched to cover all contributing source pixels.
+func (filter ResampleFilter) taps(srcSize, dstSize int) []resampleTaps {
+	result := make([]resampleTaps, dstSize)
+	ratio := float64(srcSize) / float64(dstSize)
+	kernel, support := filter.kernel()
+	stretch := 1.0
+	if (ratio > 1.0) && (filter != ResampleNearest) {
+		stretch = ratio
+	}
+	support *= stretch
+
+	for i := range result {
+		// Source coordinate of the destination pixel's center.
+		center := (float64(i)+0.5)*ratio - 0.5
+		if filter == ResampleNearest {
+			j := int(math.Floor(center + 0.5))
+			result[i] = resampleTaps{clampInt(j, 0, srcSize-1), []float64{1.0}}
+			continue
+		}
+
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+		weights := make([]float64, last-first+1)
+		sum := 0.0
+		for j := first; j <= last; j++ {
+			w := kernel((float64(j) - center) / stretch)
+			weights[j-first] = w
+			sum += w
+		}
+		if sum != 0.0 {
+			for k := range weights {
+				weights[k] /= sum
+			}
+		}
+		result[i] = resampleTaps{first, weights}
+	}
+	return result
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Resample an image to the given dimensions.  The result's origin is 0, 0.
+// Source pixels beyond the image's edges are taken from the nearest edge.
+func ResampleLab(src *lib_image.CIELab, width, height int, filter ResampleFilter) *lib_image.CIELab {
+	bounds := src.Bounds()
+	srcWidth := bounds.Dx()
+	srcHeight := bounds.Dy()
+	result := lib_image.NewCIELab(image.Rect(0, 0, width, height))
+	if (srcWidth <= 0) || (srcHeight <= 0) {
+		return result
+	}
+
+	xTaps := filter.taps(srcWidth, width)
+	yTaps := filter.taps(srcHeight, height)
+
+	// Resample rows, then columns.
+	horiz := make([]float64, srcHeight*width*3)
+	for y := 0; y < srcHeight; y++ {
+		for x, taps := range xTaps {
+			for channel := 0; channel < 3; channel++ {
+				sum := 0.0
+				for k, w := range taps.weights {
+					sx := clampInt(taps.first+k, 0, srcWidth-1)
+					sum += w * src.Pix[src.PixOffset(bounds.Min.X+sx, bounds.Min.Y+y)+channel]
+				}
+				horiz[(y*width+x)*3+channel] = sum
+			}
+		}
+	}
+
+	for y, taps := range yTaps {
+		for x := 0; x < width; x++ {
+			for channel := 0; channel < 3; channel++ {
+				sum := 0.0
+				for k, w := range taps.weights {
+					sy := clampInt(taps.first+k, 0, srcHeight-1)
+					sum += w * horiz[(sy*width+x)*3+channel]
+				}
+				result.Pix[result.PixOffset(x, y)+channel] = sum
+			}
+		}
+	}
+	return result
+}
+
+// Convert a rectangle in full-sensor coordinates to a rectangle at the
+// given scale, in sensor pixels per output pixel.  The result covers every
+// output pixel that the rectangle touches.
+func ScaledRect(sensorRect image.Rectangle, scale int) image.Rectangle {
+	if scale <= 1 {
+		return sensorRect
+	}
+	floorDiv := func(v int) int {
+		if v < 0 {
+			return -((-v + scale - 1) / scale)
+		}
+		return v / scale
+	}
+	ceilDiv := func(v int) int {
+		return -floorDiv(-v)
+	}
+	return image.Rect(
+		floorDiv(sensorRect.Min.X), floorDiv(sensorRect.Min.Y),
+		ceilDiv(sensorRect.Max.X), ceilDiv(sensorRect.Max.Y))
+}
@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+var allResampleFilters = []ResampleFilter{
+	ResampleNearest, ResampleBilinear, ResampleCatmullRom, ResampleLanczos3,
+}
+
+func TestParseResampleFilter(t *testing.T) {
+	for _, filter := range allResampleFilters {
+		got, err := ParseResampleFilter(filter.String())
+		if err != nil {
+			t.Fatal("Error parsing resample filter name:", err)
+		}
+		if got != filter {
+			t.Errorf("Expected %v, got %v", filter, got)
+		}
+	}
+	if _, err := ParseResampleFilter("no such filter"); err == nil {
+		t.Error("Expected an error for an unknown filter name")
+	}
+}
+
+func TestResamplePreservesUniformImages(t *testing.T) {
+	src := uniformLabTile(image.Rect(10, 20, 26, 32), 42.0)
+	for _, filter := range allResampleFilters {
+		for _, size := range []image.Point{{16, 12}, {64, 48}, {4, 3}, {7, 5}} {
+			result := ResampleLab(src, size.X, size.Y, filter)
+			if !result.Bounds().Eq(image.Rect(0, 0, size.X, size.Y)) {
+				t.Fatalf("%v: expected bounds of size %v, got %v", filter, size, result.Bounds())
+			}
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					if got := result.CIELabAt(x, y).L; math.Abs(got-42.0) > 1.0e-9 {
+						t.Fatalf("%v, size %v: expected L 42 at (%v, %v), got %v", filter, size, x, y, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestResampleUnchangedSize(t *testing.T) {
+	src := lib_image.NewCIELab(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetCIELab(x, y, lib_color.CIELab{L: float64(x*8 + y)})
+		}
+	}
+	for _, filter := range allResampleFilters {
+		result := ResampleLab(src, 8, 8, filter)
+		for i, v := range src.Pix {
+			if math.Abs(result.Pix[i]-v) > 1.0e-9 {
+				t.Fatalf("%v: expected resampling to the same size to be an identity", filter)
+			}
+		}
+	}
+}
+
+func TestResampleReduceAverages(t *testing.T) {
+	// Alternating columns of 0 and 100 should reduce to about 50.
+	src := lib_image.NewCIELab(image.Rect(0, 0, 32, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 32; x++ {
+			src.SetCIELab(x, y, lib_color.CIELab{L: float64((x % 2) * 100)})
+		}
+	}
+	result := ResampleLab(src, 8, 1, ResampleBilinear)
+	for x := 1; x < 7; x++ {
+		if got := result.CIELabAt(x, 0).L; math.Abs(got-50.0) > 1.0 {
+			t.Errorf("Expected reduced value near 50 at %v, got %v", x, got)
+		}
+	}
+}
+
+func TestScaledRect(t *testing.T) {
+	tests := []struct {
+		rect  image.Rectangle
+		scale int
+		want  image.Rectangle
+	}{
+		{image.Rect(1, 1, 1281, 961), 1, image.Rect(1, 1, 1281, 961)},
+		{image.Rect(0, 0, 1280, 960), 2, image.Rect(0, 0, 640, 480)},
+		{image.Rect(1, 1, 1281, 961), 2, image.Rect(0, 0, 641, 481)},
+		{image.Rect(-3, -4, 5, 4), 4, image.Rect(-1, -1, 2, 1)},
+	}
+	for _, test := range tests {
+		if got := ScaledRect(test.rect, test.scale); !got.Eq(test.want) {
+			t.Errorf("ScaledRect(%v, %v): expected %v, got %v", test.rect, test.scale, test.want, got)
+		}
+	}
+}
+
+func uniformGray(rect image.Rectangle, value uint8) *image.Gray {
+	result := image.NewGray(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			result.SetGray(x, y, color.Gray{value})
+		}
+	}
+	return result
+}
+
+func TestCompositeMixedScales(t *testing.T) {
+	// A binned tile covering the whole sensor area, and a full-resolution
+	// tile covering its middle.
+	sensorRect := image.Rect(0, 0, 128, 64)
+	fineRect := image.Rect(32, 16, 96, 48)
+
+	comp := NewScaledCompositor(sensorRect, 1)
+	comp.AddScaledImage(uniformGray(image.Rect(0, 0, 32, 16), 64), sensorRect, 4)
+	comp.AddScaledImage(uniformGray(image.Rect(0, 0, 64, 32), 192), fineRect, 1)
+
+	if !comp.Bounds.Eq(sensorRect) {
+		t.Fatalf("Expected bounds %v, got %v", sensorRect, comp.Bounds)
+	}
+	coarseL := comp.Result.CIELabAt(8, 8).L
+	fineL := comp.Result.CIELabAt(64, 32).L
+	// Color matching pulls the fine tile's values toward the coarse tile's,
+	// but every pixel under the fine tile should come from it.
+	for y := fineRect.Min.Y; y < fineRect.Max.Y; y++ {
+		for x := fineRect.Min.X; x < fineRect.Max.X; x++ {
+			if got := comp.Result.CIELabAt(x, y).L; got != fineL {
+				t.Fatalf("Expected fine tile's value %v at (%v, %v), got %v", fineL, x, y, got)
+			}
+		}
+	}
+	if got := comp.scale(64, 32); got != 1 {
+		t.Errorf("Expected finest scale 1 under the fine tile, got %v", got)
+	}
+	if got := comp.scale(8, 8); got != 4 {
+		t.Errorf("Expected scale 4 outside the fine tile, got %v", got)
+	}
+	if coarseL <= 0.0 {
+		t.Errorf("Expected coarse data outside the fine tile, got L %v", coarseL)
+	}
+}
+
+func TestCompositeAtOutputScale(t *testing.T) {
+	sensorRect := image.Rect(0, 0, 128, 64)
+	comp := NewScaledCompositor(sensorRect, 2)
+	if want := image.Rect(0, 0, 64, 32); !comp.Bounds.Eq(want) {
+		t.Fatalf("Expected bounds %v, got %v", want, comp.Bounds)
+	}
+	comp.AddScaledImage(uniformGray(image.Rect(0, 0, 128, 64), 128), sensorRect, 1)
+	want := lib_image.CIELabFromImage(uniformGray(image.Rect(0, 0, 1, 1), 128)).CIELabAt(0, 0).L
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			if got := comp.Result.CIELabAt(x, y).L; math.Abs(got-want) > 1.0e-9 {
+				t.Fatalf("Expected L %v at (%v, %v), got %v", want, x, y, got)
+			}
+		}
+	}
+}
@@ -2,10 +2,12 @@ package lib
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path/filepath"
 )
 
 func ValidCameras() []string {
@@ -75,22 +77,46 @@ func ParseImageMetadata(rawJson []byte) ([]ImageInfo, error) {
 	if err != nil {
 		return result, err
 	}
-	err = json.Unmarshal(*raw["images"], &result)
+	images, ok := raw["images"]
+	if !ok || images == nil {
+		return result, errors.New("response contains no images")
+	}
+	err = json.Unmarshal(*images, &result)
 	return result, err
 }
 
-func GetImageMetadata(params url.Values) ([]ImageInfo, error) {
-	apiUrl := "https://mars.nasa.gov/rss/api/"
-	fullUrl := apiUrl + "?" + params.Encode()
+var rssAPIURL = "https://mars.nasa.gov/rss/api/"
+
+// Get the raw JSON response for one page of the RSS API.  Responses
+// other than 2xx are errors.
+func GetRawImageMetadata(params url.Values) ([]byte, error) {
+	fullUrl := rssAPIURL + "?" + params.Encode()
 
 	resp, err := http.Get(fullUrl)
 	if err != nil {
-		fmt.Println("Failed getting:", fullUrl, "reason", err)
+		return []byte{}, fmt.Errorf("failed getting %v: %v", fullUrl, err)
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		return []byte{}, fmt.Errorf("failed getting %v: %v", fullUrl, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func GetImageMetadata(params url.Values) ([]ImageInfo, error) {
+	body, err := GetRawImageMetadata(params)
 	if err != nil {
 		return []ImageInfo{}, err
 	}
 	return ParseImageMetadata(body)
 }
+
+// Archive a raw RSS API response page in dirname, so that the page can be
+// re-imported later via ImageDB.ImportFile.
+func SaveRawPage(dirname string, page int, rawJson []byte) error {
+	if err := ensureDirExists(dirname); err != nil {
+		return err
+	}
+	pathname := filepath.Join(dirname, fmt.Sprintf("page_%05d.json", page))
+	return ioutil.WriteFile(pathname, rawJson, 0644)
+}
@@ -2,6 +2,9 @@ package lib
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 )
 
@@ -20,3 +23,28 @@ func TestParseImageMetadata(t *testing.T) {
 		t.Errorf("Expected %v image records, got %v", want, len(got))
 	}
 }
+
+func TestGetRawImageMetadataStatus(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"images": []}`))
+	}))
+	defer server.Close()
+	defer func(saved string) { rssAPIURL = saved }(rssAPIURL)
+	rssAPIURL = server.URL + "/"
+
+	body, err := GetRawImageMetadata(url.Values{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if string(body) != `{"images": []}` {
+		t.Errorf("Unexpected response %q", body)
+	}
+
+	for _, status = range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		if _, err := GetRawImageMetadata(url.Values{}); err == nil {
+			t.Errorf("Expected an error for status %v", status)
+		}
+	}
+}
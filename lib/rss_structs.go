@@ -44,6 +44,28 @@ func (v *optInt) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+// optSol is a sol number.  Unlike optInt, it keeps unknown sols distinct
+// from sol 0, the day of landing.
+type optSol int
+
+const unknownSol optSol = -1
+
+func (v *optSol) UnmarshalJSON(data []byte) error {
+	if isUnknown(data) {
+		*v = unknownSol
+		return nil
+	}
+	return (*optInt)(v).UnmarshalJSON(data)
+}
+
+// Get the value to store in a database.  Unknown sols are stored as NULL.
+func (v optSol) dbValue() interface{} {
+	if v == unknownSol {
+		return nil
+	}
+	return int(v)
+}
+
 type FloatTuple []float64
 
 func (v *FloatTuple) UnmarshalJSON(data []byte) error {
@@ -154,7 +176,7 @@ type ImageInfo struct {
 	WebLink  string `json:"link"`
 
 	Attitude FloatTuple `json:"attitude"`
-	Sol      optInt     `json:"sol"`
+	Sol      optSol     `json:"sol"`
 
 	// How to parse date strings in Go?
 	DateTakenMars string `json:"date_taken_mars"`
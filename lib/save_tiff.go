@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+)
+
+// A minimal baseline TIFF encoder: uncompressed, 8 bits per sample, RGB or
+// RGB with unassociated alpha.  Rows are encoded as they are written, so
+// the image need not be held in memory.  This lets large composites, whose
+// pixels are paged, be saved without materializing them.
+
+// TIFF tags and field types used by WriteTIFF.
+const (
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagPlanarConfig    = 284
+	tagResolutionUnit  = 296
+	tagExtraSamples    = 338
+)
+
+// Strips of about this many bytes are recommended by the TIFF 6.0 spec.
+const tiffStripBytes = 8192
+
+type tiffEntry struct {
+	tag, fieldType uint16
+	values         []uint32
+}
+
+func (e tiffEntry) size() int {
+	if e.fieldType == tiffShort {
+		return 2 * len(e.values)
+	}
+	return 4 * len(e.values)
+}
+
+func (e tiffEntry) count() uint32 {
+	if e.fieldType == tiffRational {
+		// values holds numerator, denominator pairs.
+		return uint32(len(e.values) / 2)
+	}
+	return uint32(len(e.values))
+}
+
+func (e tiffEntry) encode(order binary.ByteOrder) []byte {
+	result := make([]byte, e.size())
+	for i, v := range e.values {
+		if e.fieldType == tiffShort {
+			order.PutUint16(result[2*i:], uint16(v))
+		} else {
+			order.PutUint32(result[4*i:], v)
+		}
+	}
+	return result
+}
+
+type opaquer interface {
+	Opaque() bool
+}
+
+// Encode an image as TIFF.  Images that report themselves to be opaque are
+// saved as RGB, others as RGBA.
+func WriteTIFF(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	if (width <= 0) || (height <= 0) {
+		return errors.New("cannot encode an empty image as TIFF")
+	}
+	samples := 4
+	if o, ok := img.(opaquer); ok && o.Opaque() {
+		samples = 3
+	}
+	rowBytes := width * samples
+	rowsPerStrip := tiffStripBytes / rowBytes
+	if rowsPerStrip < 1 {
+		rowsPerStrip = 1
+	}
+	numStrips := (height + rowsPerStrip - 1) / rowsPerStrip
+	if int64(rowBytes)*int64(height) > math.MaxUint32-(1<<20) {
+		return errors.New("image is too large for TIFF")
+	}
+
+	bitsPerSample := make([]uint32, samples)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = 8
+	}
+	stripOffsets := make([]uint32, numStrips)
+	stripByteCounts := make([]uint32, numStrips)
+	entries := []tiffEntry{
+		{tagImageWidth, tiffLong, []uint32{uint32(width)}},
+		{tagImageLength, tiffLong, []uint32{uint32(height)}},
+		{tagBitsPerSample, tiffShort, bitsPerSample},
+		{tagCompression, tiffShort, []uint32{1}},
+		// RGB
+		{tagPhotometric, tiffShort, []uint32{2}},
+		{tagStripOffsets, tiffLong, stripOffsets},
+		{tagSamplesPerPixel, tiffShort, []uint32{uint32(samples)}},
+		{tagRowsPerStrip, tiffLong, []uint32{uint32(rowsPerStrip)}},
+		{tagStripByteCounts, tiffLong, stripByteCounts},
+		{tagXResolution, tiffRational, []uint32{72, 1}},
+		{tagYResolution, tiffRational, []uint32{72, 1}},
+		// Chunky: samples of each pixel are stored together.
+		{tagPlanarConfig, tiffShort, []uint32{1}},
+		// Inches
+		{tagResolutionUnit, tiffShort, []uint32{2}},
+	}
+	if samples == 4 {
+		// Unassociated alpha
+		entries = append(entries, tiffEntry{tagExtraSamples, tiffShort, []uint32{2}})
+	}
+
+	// Layout: header, IFD, values too large to fit in IFD entries, pixels.
+	const headerSize = 8
+	ifdSize := 2 + 12*len(entries) + 4
+	dataOffset := headerSize + ifdSize
+	for _, e := range entries {
+		if e.size() > 4 {
+			dataOffset += e.size()
+		}
+	}
+	for i := range stripOffsets {
+		rows := rowsPerStrip
+		if i == numStrips-1 {
+			rows = height - i*rowsPerStrip
+		}
+		stripOffsets[i] = uint32(dataOffset + i*rowsPerStrip*rowBytes)
+		stripByteCounts[i] = uint32(rows * rowBytes)
+	}
+
+	order := binary.LittleEndian
+	out := bufio.NewWriter(w)
+	header := []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	order.PutUint32(header[4:], headerSize)
+	out.Write(header)
+
+	ifd := make([]byte, ifdSize)
+	order.PutUint16(ifd, uint16(len(entries)))
+	extra := []byte{}
+	extraOffset := headerSize + ifdSize
+	for i, e := range entries {
+		entry := ifd[2+12*i:]
+		order.PutUint16(entry[0:], e.tag)
+		order.PutUint16(entry[2:], e.fieldType)
+		order.PutUint32(entry[4:], e.count())
+		value := e.encode(order)
+		if len(value) <= 4 {
+			copy(entry[8:12], value)
+		} else {
+			order.PutUint32(entry[8:], uint32(extraOffset+len(extra)))
+			extra = append(extra, value...)
+		}
+	}
+	// The next IFD offset, at the end of ifd, is zero: there is no next IFD.
+	out.Write(ifd)
+	out.Write(extra)
+
+	row := make([]byte, rowBytes)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := 0
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			row[i] = c.R
+			row[i+1] = c.G
+			row[i+2] = c.B
+			if samples == 4 {
+				row[i+3] = c.A
+			}
+			i += samples
+		}
+		if _, err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func SaveTIFF(image image.Image, filename string) error {
+	outf, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer outf.Close()
+
+	return WriteTIFF(outf, image)
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"image"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Minimum-cost seams through tile overlaps.  Pixels on either side of a
+// seam come from the tile on that side, so that the join follows the path
+// along which the tiles differ least, rather than cutting through features
+// that the tiles render differently.
+
+// Find the minimum-cost 8-connected path from the top row of a cost plane to
+// its bottom row, via dynamic programming.  Returns the path's x coordinate
+// for each row.
+func verticalSeam(cost *floatPlane) []int {
+	width := cost.width
+	height := cost.height
+	result := make([]int, height)
+	if (width <= 0) || (height <= 0) {
+		return result
+	}
+
+	// cumulative[y*width+x] is the cost of the cheapest path from the top
+	// row to (x, y).
+	cumulative := make([]float64, width*height)
+	copy(cumulative[:width], cost.pix[:width])
+	for y := 1; y < height; y++ {
+		for x := 0; x < width; x++ {
+			best := cumulative[(y-1)*width+x]
+			if (x > 0) && (cumulative[(y-1)*width+x-1] < best) {
+				best = cumulative[(y-1)*width+x-1]
+			}
+			if (x < width-1) && (cumulative[(y-1)*width+x+1] < best) {
+				best = cumulative[(y-1)*width+x+1]
+			}
+			cumulative[y*width+x] = cost.at(x, y) + best
+		}
+	}
+
+	// Trace back from the cheapest endpoint.
+	bestX := 0
+	lastRow := (height - 1) * width
+	for x := 1; x < width; x++ {
+		if cumulative[lastRow+x] < cumulative[lastRow+bestX] {
+			bestX = x
+		}
+	}
+	result[height-1] = bestX
+	for y := height - 2; y >= 0; y-- {
+		prevX := result[y+1]
+		bestX = prevX
+		for _, x := range []int{prevX - 1, prevX + 1} {
+			if (x >= 0) && (x < width) && (cumulative[y*width+x] < cumulative[y*width+bestX]) {
+				bestX = x
+			}
+		}
+		result[y] = bestX
+	}
+	return result
+}
+
+func (p *floatPlane) transposed() *floatPlane {
+	result := newFloatPlane(p.height, p.width)
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			result.set(y, x, p.at(x, y))
+		}
+	}
+	return result
+}
+
+// Get the cost of a seam passing through each pixel of overlap: the
+// perceptual difference between the tiles where overlap is covered.
+// Uncovered pixels cost more than any path through covered ones, so that
+// seams don't detour through holes in the previous coverage.
+func (comp *Compositor) seamCost(tile *lib_image.CIELab, tileOffset image.Point, overlap image.Rectangle) *floatPlane {
+	result := newFloatPlane(overlap.Dx(), overlap.Dy())
+	covered := make([]bool, len(result.pix))
+	totalCost := 0.0
+	for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+		for x := overlap.Min.X; x < overlap.Max.X; x++ {
+			if comp.weight(x, y) > 0.0 {
+				prev := comp.Result.CIELabAt(x, y)
+				curr := tile.CIELabAt(x-tileOffset.X, y-tileOffset.Y)
+				dE := lib_color.DeltaECIE2000(prev, curr)
+				result.set(x-overlap.Min.X, y-overlap.Min.Y, dE)
+				covered[(y-overlap.Min.Y)*result.width+(x-overlap.Min.X)] = true
+				totalCost += dE
+			}
+		}
+	}
+	for i, ok := range covered {
+		if !ok {
+			result.pix[i] = totalCost + 1.0
+		}
+	}
+	return result
+}
+
+// Get a mask, covering rect, that is true wherever the new tile should
+// supply a pixel.
+func (comp *Compositor) seamMask(tile *lib_image.CIELab, tileOffset image.Point, rect image.Rectangle) []bool {
+	width := rect.Dx()
+	result := make([]bool, width*rect.Dy())
+
+	// Find the extent of the previously covered pixels, and their centroid.
+	overlap := image.Rectangle{}
+	sumX := 0.0
+	sumY := 0.0
+	numCovered := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := (y-rect.Min.Y)*width + (x - rect.Min.X)
+			if comp.weight(x, y) > 0.0 {
+				overlap = overlap.Union(image.Rect(x, y, x+1, y+1))
+				sumX += float64(x)
+				sumY += float64(y)
+				numCovered += 1
+			} else {
+				result[i] = true
+			}
+		}
+	}
+	if numCovered <= 0 {
+		return result
+	}
+	centroidX := sumX / float64(numCovered)
+	centroidY := sumY / float64(numCovered)
+
+	cost := comp.seamCost(tile, tileOffset, overlap)
+
+	// Cut across the overlap's narrower dimension.  The new tile supplies
+	// pixels on the side of the seam away from the previously covered
+	// pixels.
+	tileCenterX := float64(rect.Min.X+rect.Max.X-1) / 2.0
+	tileCenterY := float64(rect.Min.Y+rect.Max.Y-1) / 2.0
+	if overlap.Dy() >= overlap.Dx() {
+		seam := verticalSeam(cost)
+		newOnRight := tileCenterX >= centroidX
+		for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+			seamX := overlap.Min.X + seam[y-overlap.Min.Y]
+			for x := overlap.Min.X; x < overlap.Max.X; x++ {
+				i := (y-rect.Min.Y)*width + (x - rect.Min.X)
+				result[i] = result[i] || ((x >= seamX) == newOnRight)
+			}
+		}
+	} else {
+		seam := verticalSeam(cost.transposed())
+		newBelow := tileCenterY >= centroidY
+		for x := overlap.Min.X; x < overlap.Max.X; x++ {
+			seamY := overlap.Min.Y + seam[x-overlap.Min.X]
+			for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+				i := (y-rect.Min.Y)*width + (x - rect.Min.X)
+				result[i] = result[i] || ((y >= seamY) == newBelow)
+			}
+		}
+	}
+	return result
+}
+
+// Blend a tile by cutting along a minimum-cost seam through its overlap
+// with previously composited data.
+func (comp *Compositor) blendSeam(tile *lib_image.CIELab, destRect image.Rectangle) {
+	tileOffset := destRect.Min.Sub(tile.Bounds().Min)
+	rect := destRect.Intersect(comp.Bounds)
+	if rect.Empty() {
+		return
+	}
+
+	mask := comp.seamMask(tile, tileOffset, rect)
+	width := rect.Dx()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if mask[(y-rect.Min.Y)*width+(x-rect.Min.X)] {
+				comp.Result.SetCIELab(x, y, tile.CIELabAt(x-tileOffset.X, y-tileOffset.Y))
+				comp.setWeight(x, y, 1.0)
+				comp.setSource(x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"image"
+	"testing"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+func TestVerticalSeamFollowsCheapestPath(t *testing.T) {
+	// Every column is expensive except one, which wanders.
+	width := 7
+	height := 5
+	cheapX := []int{1, 2, 3, 3, 4}
+	cost := newFloatPlane(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			cost.set(x, y, 10.0)
+		}
+		cost.set(cheapX[y], y, 0.0)
+	}
+
+	got := verticalSeam(cost)
+	for y, want := range cheapX {
+		if got[y] != want {
+			t.Fatalf("Expected seam %v, got %v", cheapX, got)
+		}
+	}
+}
+
+func TestTransposedSeam(t *testing.T) {
+	cost := newFloatPlane(6, 3)
+	for x := 0; x < 6; x++ {
+		for y := 0; y < 3; y++ {
+			if y != 2 {
+				cost.set(x, y, 5.0)
+			}
+		}
+	}
+	for _, y := range verticalSeam(cost.transposed()) {
+		if y != 2 {
+			t.Fatalf("Expected a horizontal seam along row 2, got %v", y)
+		}
+	}
+}
+
+func TestBlendSeamAvoidsFeatures(t *testing.T) {
+	leftRect := image.Rect(0, 0, 64, 32)
+	rightRect := image.Rect(40, 0, 104, 32)
+	comp := NewCompositor(leftRect.Union(rightRect))
+	comp.BlendMode = BlendSeam
+
+	// Identical tiles, except that a "rock" at the left edge of the overlap
+	// is rendered differently by the two tiles.  The cheapest seam must pass
+	// to one side of it.
+	rock := image.Rect(40, 0, 50, 32)
+	leftTile := uniformLabTile(image.Rect(0, 0, 64, 32), 50.0)
+	rightTile := uniformLabTile(image.Rect(0, 0, 64, 32), 50.0)
+	for y := rock.Min.Y; y < rock.Max.Y; y++ {
+		for x := rock.Min.X; x < rock.Max.X; x++ {
+			leftTile.SetCIELab(x, y, lib_color.CIELab{L: 30.0})
+			rightTile.SetCIELab(x-rightRect.Min.X, y, lib_color.CIELab{L: 70.0})
+		}
+	}
+
+	comp.blend(leftTile, leftRect)
+	comp.recordArea(leftRect, leftRect)
+	comp.blend(rightTile, rightRect)
+
+	// The seam should pass to the right of the rock, which should therefore
+	// come entirely from the left tile.
+	for y := rock.Min.Y; y < rock.Max.Y; y++ {
+		for x := rock.Min.X; x < rock.Max.X; x++ {
+			if got := comp.Result.CIELabAt(x, y).L; got != 30.0 {
+				t.Fatalf("Expected the seam to avoid the rock; got L %v at (%v, %v)", got, x, y)
+			}
+		}
+	}
+	// Beyond the overlap, pixels come from the right tile.
+	if got := comp.Result.CIELabAt(80, 16).L; got != 50.0 {
+		t.Errorf("Expected right tile's value beyond the overlap; got %v", got)
+	}
+}
+
+func TestSeamCostAvoidsUncoveredPixels(t *testing.T) {
+	comp := NewCompositor(image.Rect(0, 0, 8, 8))
+	// Previous coverage is L-shaped, leaving a hole in the lower right of
+	// the overlap's bounding box.
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (y < 4) || (x < 2) {
+				comp.Result.SetCIELab(x, y, lib_color.CIELab{L: 40.0})
+				comp.setWeight(x, y, 1.0)
+			}
+		}
+	}
+	tile := uniformLabTile(image.Rect(0, 0, 8, 8), 60.0)
+	overlap := image.Rect(0, 0, 8, 8)
+	cost := comp.seamCost(tile, image.Point{}, overlap)
+
+	totalCovered := 0.0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if comp.weight(x, y) > 0.0 {
+				if cost.at(x, y) <= 0.0 {
+					t.Fatalf("Expected a positive cost at covered (%v, %v)", x, y)
+				}
+				totalCovered += cost.at(x, y)
+			}
+		}
+	}
+	for y := 4; y < 8; y++ {
+		for x := 2; x < 8; x++ {
+			if got := cost.at(x, y); got <= totalCovered {
+				t.Fatalf("Expected uncovered (%v, %v) to cost more than %v, got %v", x, y, totalCovered, got)
+			}
+		}
+	}
+	// The seam stays within the covered pixels.
+	for y, x := range verticalSeam(cost) {
+		if comp.weight(x, y) <= 0.0 {
+			t.Fatalf("Expected the seam to avoid uncovered pixels, got x %v in row %v", x, y)
+		}
+	}
+}
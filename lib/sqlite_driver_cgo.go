@@ -0,0 +1,13 @@
+//go:build !purego
+// +build !purego
+
+package lib
+
+// By default, use the cgo-based SQLite driver.
+// Build with -tags purego to use a pure-Go driver instead.
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteDriverName = "sqlite3"
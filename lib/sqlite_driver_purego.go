@@ -0,0 +1,13 @@
+//go:build purego
+// +build purego
+
+package lib
+
+// A pure-Go SQLite driver, for builds without cgo, e.g.,
+//   CGO_ENABLED=0 GOARCH=arm64 go build -tags purego ./...
+
+import (
+	_ "modernc.org/sqlite"
+)
+
+const sqliteDriverName = "sqlite"
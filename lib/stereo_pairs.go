@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+type StereoPair struct {
+	Left, Right string // image_ids
+}
+
+func idsMatch(imageID1, imageID2 string) bool {
+	// If TWO IDs differ only in the 2nd rune - one being
+	// 'L' and the other being 'R' - then the IDs are
+	// probably for the same snapshot.
+	run1 := []rune(imageID1)
+	run2 := []rune(imageID2)
+	if (len(run1) > 3) && (len(run2) > 3) {
+		prefix1 := string(run1[:1])
+		prefix2 := string(run2[:1])
+		suffix1 := string(run1[2:])
+		suffix2 := string(run2[2:])
+		if (prefix1 == prefix2) && (suffix1 == suffix2) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLRSuffix(s string) (prefix, suffix string) {
+	// Front hazcams use, e.g., "LEFT_A"
+	suffixes := []string{"_LEFT", "_RIGHT", "LEFT_A", "RIGHT_A"}
+
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return strings.Replace(s, suffix, "", 1), suffix
+		}
+	}
+	return s, ""
+}
+
+func instrumentsMatch(inst1, inst2 string) bool {
+	pf1, lr1 := splitLRSuffix(inst1)
+	pf2, lr2 := splitLRSuffix(inst2)
+	return (pf1 == pf2) && (lr1 != "") && (lr2 != "") && (lr1 != lr2)
+}
+
+// Find pairs of full-color images taken at the same time by the left and
+// right cameras of a stereo camera pair.
+func FindStereoPairs(store ImageStore) ([]StereoPair, error) {
+	result := []StereoPair{}
+
+	records, err := store.Query(ImageQuery{SampleType: "Full", ColorTypes: []string{"F"}})
+	if err != nil {
+		return result, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		sclkI := float64(records[i].Extended.Sclk)
+		sclkJ := float64(records[j].Extended.Sclk)
+		if sclkI != sclkJ {
+			return lessNaNFirst(sclkI, sclkJ)
+		}
+		return records[i].Camera.Instrument < records[j].Camera.Instrument
+	})
+
+	prevID := ""
+	prevInstrument := ""
+	prevSclk := -1.0
+	for _, record := range records {
+		imageID := record.ImageID
+		instrument := record.Camera.Instrument
+		sclk := float64(record.Extended.Sclk)
+
+		dt := math.Abs(sclk - prevSclk)
+		if (dt <= 1.0) && idsMatch(prevID, imageID) && instrumentsMatch(instrument, prevInstrument) {
+			pair := StereoPair{imageID, prevID}
+			leftOrRightPrev := []rune(prevID)[1]
+			if leftOrRightPrev == 'L' {
+				pair = StereoPair{prevID, imageID}
+			}
+			result = append(result, pair)
+		}
+
+		prevID = imageID
+		prevInstrument = instrument
+		prevSclk = sclk
+	}
+	return result, nil
+}
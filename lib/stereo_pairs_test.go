@@ -1,4 +1,4 @@
-package main
+package lib
 
 import "testing"
 
@@ -18,4 +18,3 @@ func TestIDsMatch(t *testing.T) {
 		t.Error("IDs w. same prefix, and 'L' or 'R', should match.")
 	}
 }
-
@@ -0,0 +1,319 @@
+// Package traverse reconstructs the rover's traverse from per-image
+// site/drive indices and positions.
+package traverse
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// UnknownSol is the sol of records whose sol is not known.  Sol 0 is the
+// day of landing.
+const UnknownSol = -1
+
+// Record holds the per-image information needed to reconstruct a traverse.
+type Record struct {
+	ImageID string
+	Camera  string
+	// UnknownSol if unknown
+	Sol   int
+	Site  int
+	Drive int
+	// Rover position in the site frame.  Components are NaN if unknown.
+	XYZ [3]float64
+}
+
+// Position describes one rover stop, identified by its site and drive
+// indices.  The drive index is reset each time a new site frame is declared,
+// so positions are ordered by site, then drive.
+type Position struct {
+	Site  int
+	Drive int
+
+	// Range of the known sols of records at this position.  Both are
+	// UnknownSol if no record has a known sol.
+	FirstSol int
+	LastSol  int
+
+	// Mean rover position in the site frame, averaged over all records
+	// having a known position.  Components are NaN if no record at this
+	// position has a known XYZ.
+	XYZ [3]float64
+
+	// Number of images taken by each camera at this position.
+	CameraCounts map[string]int
+	// IDs of all images taken at this position, sorted.
+	ImageIDs []string
+}
+
+// HasXYZ is true if the position's coordinates are known.
+func (p Position) HasXYZ() bool {
+	return hasXYZ(p.XYZ)
+}
+
+// HasSols is true if the sol of at least one image at the position is known.
+func (p Position) HasSols() bool {
+	return p.FirstSol != UnknownSol
+}
+
+// NumImages gets the total number of images taken at this position.
+func (p Position) NumImages() int {
+	return len(p.ImageIDs)
+}
+
+// Traverse is an ordered list of rover positions.
+type Traverse struct {
+	Positions []Position
+}
+
+type siteDrive struct {
+	site, drive int
+}
+
+type positionAccum struct {
+	pos      Position
+	xyzMean  [3]float64
+	numXYZ   int
+	haveSols bool
+}
+
+func hasXYZ(xyz [3]float64) bool {
+	return !(math.IsNaN(xyz[0]) || math.IsNaN(xyz[1]) || math.IsNaN(xyz[2]))
+}
+
+// New builds a traverse from image records, in any order.
+func New(records []Record) Traverse {
+	accums := map[siteDrive]*positionAccum{}
+	for _, record := range records {
+		key := siteDrive{record.Site, record.Drive}
+		accum, ok := accums[key]
+		if !ok {
+			accum = &positionAccum{
+				pos: Position{
+					Site:         record.Site,
+					Drive:        record.Drive,
+					FirstSol:     UnknownSol,
+					LastSol:      UnknownSol,
+					CameraCounts: map[string]int{},
+				},
+			}
+			accums[key] = accum
+		}
+
+		pos := &accum.pos
+		if record.Sol != UnknownSol {
+			if !accum.haveSols || record.Sol < pos.FirstSol {
+				pos.FirstSol = record.Sol
+			}
+			if !accum.haveSols || record.Sol > pos.LastSol {
+				pos.LastSol = record.Sol
+			}
+			accum.haveSols = true
+		}
+
+		pos.CameraCounts[record.Camera] += 1
+		pos.ImageIDs = append(pos.ImageIDs, record.ImageID)
+
+		if hasXYZ(record.XYZ) {
+			// Use a running mean, so that records with identical positions
+			// yield exactly that position.
+			accum.numXYZ += 1
+			for i, v := range record.XYZ {
+				accum.xyzMean[i] += (v - accum.xyzMean[i]) / float64(accum.numXYZ)
+			}
+		}
+	}
+
+	result := Traverse{Positions: make([]Position, 0, len(accums))}
+	for _, accum := range accums {
+		pos := accum.pos
+		nan := math.NaN()
+		pos.XYZ = [3]float64{nan, nan, nan}
+		if accum.numXYZ > 0 {
+			pos.XYZ = accum.xyzMean
+		}
+		sort.Strings(pos.ImageIDs)
+		result.Positions = append(result.Positions, pos)
+	}
+
+	sort.Slice(result.Positions, func(i, j int) bool {
+		pi := result.Positions[i]
+		pj := result.Positions[j]
+		if pi.Site != pj.Site {
+			return pi.Site < pj.Site
+		}
+		return pi.Drive < pj.Drive
+	})
+	return result
+}
+
+// At gets the position for a site and drive.  The boolean result is false if
+// the traverse has no such position.
+func (t Traverse) At(site, drive int) (Position, bool) {
+	i := sort.Search(len(t.Positions), func(i int) bool {
+		p := t.Positions[i]
+		return (p.Site > site) || ((p.Site == site) && (p.Drive >= drive))
+	})
+	if (i < len(t.Positions)) && (t.Positions[i].Site == site) && (t.Positions[i].Drive == drive) {
+		return t.Positions[i], true
+	}
+	return Position{}, false
+}
+
+// ImagesAt gets the IDs of all images taken at a site and drive.
+func (t Traverse) ImagesAt(site, drive int) []string {
+	pos, ok := t.At(site, drive)
+	if !ok {
+		return []string{}
+	}
+	return pos.ImageIDs
+}
+
+// Cameras gets the sorted names of all cameras that took images along the
+// traverse.
+func (t Traverse) Cameras() []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, pos := range t.Positions {
+		for camera := range pos.CameraCounts {
+			if !seen[camera] {
+				seen[camera] = true
+				result = append(result, camera)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// GeoJSON support.  Note that positions are expressed in the frame of
+// their site.  Coordinates are not longitude/latitude, and positions from
+// different sites are not in a common frame.
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+func (t Traverse) geoJSON() geoJSONFeatureCollection {
+	lineCoords := [][]float64{}
+	sites := []int{}
+	drives := []int{}
+	points := []geoJSONFeature{}
+	for _, pos := range t.Positions {
+		if !pos.HasXYZ() {
+			continue
+		}
+		coords := []float64{pos.XYZ[0], pos.XYZ[1], pos.XYZ[2]}
+		lineCoords = append(lineCoords, coords)
+		sites = append(sites, pos.Site)
+		drives = append(drives, pos.Drive)
+
+		points = append(points, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: coords},
+			Properties: map[string]interface{}{
+				"site":          pos.Site,
+				"drive":         pos.Drive,
+				"first_sol":     solValue(pos.FirstSol),
+				"last_sol":      solValue(pos.LastSol),
+				"num_images":    pos.NumImages(),
+				"camera_counts": pos.CameraCounts,
+			},
+		})
+	}
+
+	line := geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geoJSONGeometry{Type: "LineString", Coordinates: lineCoords},
+		Properties: map[string]interface{}{
+			"frame":  "site",
+			"sites":  sites,
+			"drives": drives,
+		},
+	}
+	return geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: append([]geoJSONFeature{line}, points...),
+	}
+}
+
+// WriteGeoJSON writes the traverse as a GeoJSON FeatureCollection.  The first
+// feature is a LineString through all positions with known coordinates;
+// it is followed by a Point feature for each such position.
+func (t Traverse) WriteGeoJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(t.geoJSON())
+}
+
+// Unknown sols are null in GeoJSON.
+func solValue(sol int) interface{} {
+	if sol == UnknownSol {
+		return nil
+	}
+	return sol
+}
+
+func formatSol(sol int) string {
+	if sol == UnknownSol {
+		return ""
+	}
+	return fmt.Sprint(sol)
+}
+
+func formatCoord(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// WriteCSV writes one row per position, including positions with unknown
+// coordinates.  Unknown sols and coordinates are empty.  Image counts for each camera appear in trailing columns.
+func (t Traverse) WriteCSV(w io.Writer) error {
+	cameras := t.Cameras()
+
+	writer := csv.NewWriter(w)
+	header := []string{"site", "drive", "first_sol", "last_sol", "x", "y", "z", "num_images"}
+	header = append(header, cameras...)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, pos := range t.Positions {
+		row := []string{
+			fmt.Sprint(pos.Site),
+			fmt.Sprint(pos.Drive),
+			formatSol(pos.FirstSol),
+			formatSol(pos.LastSol),
+			formatCoord(pos.XYZ[0]),
+			formatCoord(pos.XYZ[1]),
+			formatCoord(pos.XYZ[2]),
+			fmt.Sprint(pos.NumImages()),
+		}
+		for _, camera := range cameras {
+			row = append(row, fmt.Sprint(pos.CameraCounts[camera]))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,191 @@
+package traverse
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+var nan = math.NaN()
+
+func sampleRecords() []Record {
+	return []Record{
+		{"NLF_2", "NAVCAM_LEFT", 24, 3, 792, [3]float64{34.0, 52.0, -0.1}},
+		{"FLF_1", "FRONT_HAZCAM_LEFT_A", 23, 3, 770, [3]float64{nan, nan, nan}},
+		{"NLF_1", "NAVCAM_LEFT", 23, 3, 770, [3]float64{nan, nan, nan}},
+		{"NRF_2", "NAVCAM_RIGHT", 25, 3, 792, [3]float64{36.0, 54.0, -0.3}},
+		{"NLF_3", "NAVCAM_LEFT", 30, 4, 0, [3]float64{0.0, 0.0, 0.0}},
+	}
+}
+
+func TestNewIgnoresUnknownSols(t *testing.T) {
+	records := append(sampleRecords(),
+		Record{"NLF_4", "NAVCAM_LEFT", UnknownSol, 3, 792, [3]float64{nan, nan, nan}},
+		Record{"NLF_5", "NAVCAM_LEFT", UnknownSol, 5, 10, [3]float64{1.0, 2.0, 3.0}},
+	)
+	trav := New(records)
+
+	pos, _ := trav.At(3, 792)
+	if (pos.FirstSol != 24) || (pos.LastSol != 25) || !pos.HasSols() {
+		t.Errorf("Expected sols 24 ... 25, got %v ... %v", pos.FirstSol, pos.LastSol)
+	}
+	pos, _ = trav.At(5, 10)
+	if pos.HasSols() || (pos.FirstSol != UnknownSol) || (pos.LastSol != UnknownSol) {
+		t.Errorf("Expected unknown sols, got %v ... %v", pos.FirstSol, pos.LastSol)
+	}
+
+	var buf bytes.Buffer
+	if err := trav.WriteCSV(&buf); err != nil {
+		t.Fatal("Error writing CSV:", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal("Could not parse CSV:", err)
+	}
+	last := rows[len(rows)-1]
+	if (last[0] != "5") || (last[2] != "") || (last[3] != "") {
+		t.Errorf("Expected empty sols for unknown sols, got %v", last)
+	}
+
+	buf.Reset()
+	if err := trav.WriteGeoJSON(&buf); err != nil {
+		t.Fatal("Error writing GeoJSON:", err)
+	}
+	var parsed struct {
+		Features []struct {
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatal("Could not parse GeoJSON:", err)
+	}
+	point := parsed.Features[len(parsed.Features)-1].Properties
+	if (point["first_sol"] != nil) || (point["last_sol"] != nil) {
+		t.Errorf("Expected null sols, got %v", point)
+	}
+}
+
+func TestNew(t *testing.T) {
+	trav := New(sampleRecords())
+
+	want := []struct{ site, drive, firstSol, lastSol, numImages int }{
+		{3, 770, 23, 23, 2},
+		{3, 792, 24, 25, 2},
+		{4, 0, 30, 30, 1},
+	}
+	if len(trav.Positions) != len(want) {
+		t.Fatalf("Expected %v positions, got %v", len(want), len(trav.Positions))
+	}
+	for i, w := range want {
+		got := trav.Positions[i]
+		if (got.Site != w.site) || (got.Drive != w.drive) ||
+			(got.FirstSol != w.firstSol) || (got.LastSol != w.lastSol) ||
+			(got.NumImages() != w.numImages) {
+			t.Errorf("Position %v: want %+v, got %+v", i, w, got)
+		}
+	}
+
+	if trav.Positions[0].HasXYZ() {
+		t.Error("Expected position with unknown XYZ to report HasXYZ() == false")
+	}
+	wantXYZ := [3]float64{35.0, 53.0, -0.2}
+	for i, v := range trav.Positions[1].XYZ {
+		if math.Abs(v-wantXYZ[i]) > 1.0e-9 {
+			t.Errorf("Expected mean XYZ %v, got %v", wantXYZ, trav.Positions[1].XYZ)
+			break
+		}
+	}
+
+	counts := trav.Positions[1].CameraCounts
+	if (counts["NAVCAM_LEFT"] != 1) || (counts["NAVCAM_RIGHT"] != 1) {
+		t.Errorf("Unexpected camera counts %v", counts)
+	}
+}
+
+func TestImagesAt(t *testing.T) {
+	trav := New(sampleRecords())
+
+	got := trav.ImagesAt(3, 770)
+	want := []string{"FLF_1", "NLF_1"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected images %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected images %v, got %v", want, got)
+		}
+	}
+
+	if got := trav.ImagesAt(99, 1); len(got) != 0 {
+		t.Errorf("Expected no images at an unknown position, got %v", got)
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(sampleRecords()).WriteGeoJSON(&buf); err != nil {
+		t.Fatal("Error writing GeoJSON:", err)
+	}
+
+	var parsed struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatal("Could not parse GeoJSON:", err)
+	}
+	if parsed.Type != "FeatureCollection" {
+		t.Errorf("Expected a FeatureCollection, got %v", parsed.Type)
+	}
+	// One LineString, plus a Point for each position with a known XYZ.
+	if len(parsed.Features) != 3 {
+		t.Fatalf("Expected 3 features, got %v", len(parsed.Features))
+	}
+	line := parsed.Features[0].Geometry
+	if line.Type != "LineString" {
+		t.Errorf("Expected first feature to be a LineString, got %v", line.Type)
+	}
+	var coords [][]float64
+	if err := json.Unmarshal(line.Coordinates, &coords); err != nil {
+		t.Fatal("Could not parse LineString coordinates:", err)
+	}
+	if len(coords) != 2 {
+		t.Errorf("Expected 2 LineString coordinates, got %v", coords)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(sampleRecords()).WriteCSV(&buf); err != nil {
+		t.Fatal("Error writing CSV:", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal("Could not parse CSV:", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %v", rows)
+	}
+	wantHeader := []string{
+		"site", "drive", "first_sol", "last_sol", "x", "y", "z", "num_images",
+		"FRONT_HAZCAM_LEFT_A", "NAVCAM_LEFT", "NAVCAM_RIGHT",
+	}
+	for i, w := range wantHeader {
+		if rows[0][i] != w {
+			t.Errorf("Expected header %v, got %v", wantHeader, rows[0])
+			break
+		}
+	}
+	// Unknown coordinates are written as empty fields.
+	if rows[1][4] != "" {
+		t.Errorf("Expected empty x for unknown position, got %q", rows[1][4])
+	}
+}
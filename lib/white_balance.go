@@ -0,0 +1,420 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	lib_image "github.com/mchapman87501/go_mars_2020_img_utils/lib/image"
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+// Automatic white balance.  An estimator guesses the white point of the
+// light illuminating a scene from the scene's linear RGB pixel values;
+// chromatic adaptation then maps that white point to a target white, e.g.,
+// D65 to make the scene look as if it were lit by terrestrial daylight.
+
+// WhiteBalanceEstimator determines how a scene's white point is estimated.
+type WhiteBalanceEstimator int
+
+const (
+	// Assume the scene averages to gray.
+	WhiteGrayWorld WhiteBalanceEstimator = iota
+	// Assume the brightest value of each channel is white.
+	WhitePatch
+	// Like WhitePatch, but use a high percentile of each channel, which is
+	// less sensitive to noise and specular highlights.
+	WhitePercentile
+)
+
+var whiteBalanceEstimatorNames = map[WhiteBalanceEstimator]string{
+	WhiteGrayWorld:  "gray-world",
+	WhitePatch:      "white-patch",
+	WhitePercentile: "percentile",
+}
+
+func (estimator WhiteBalanceEstimator) String() string {
+	if name, ok := whiteBalanceEstimatorNames[estimator]; ok {
+		return name
+	}
+	return fmt.Sprintf("WhiteBalanceEstimator(%d)", int(estimator))
+}
+
+// Get the WhiteBalanceEstimator with a given name, e.g., "gray-world".
+func ParseWhiteBalanceEstimator(name string) (WhiteBalanceEstimator, error) {
+	for estimator, estimatorName := range whiteBalanceEstimatorNames {
+		if estimatorName == name {
+			return estimator, nil
+		}
+	}
+	return WhiteGrayWorld, fmt.Errorf("unknown white balance estimator %q", name)
+}
+
+// WhiteBalancePreset names a commonly used set of WhiteBalanceOptions.
+type WhiteBalancePreset int
+
+const (
+	// Leave colors as they are.
+	WhiteBalanceNone WhiteBalancePreset = iota
+	// Keep the warm cast of Martian daylight, but remove any cast due to
+	// the camera.
+	WhiteBalanceMars
+	// Show the scene as if it were lit by terrestrial daylight.
+	WhiteBalanceEarth
+)
+
+var whiteBalancePresetNames = map[WhiteBalancePreset]string{
+	WhiteBalanceNone:  "none",
+	WhiteBalanceMars:  "mars",
+	WhiteBalanceEarth: "earth",
+}
+
+func (preset WhiteBalancePreset) String() string {
+	if name, ok := whiteBalancePresetNames[preset]; ok {
+		return name
+	}
+	return fmt.Sprintf("WhiteBalancePreset(%d)", int(preset))
+}
+
+// Get the WhiteBalancePreset with a given name, e.g., "mars".
+func ParseWhiteBalancePreset(name string) (WhiteBalancePreset, error) {
+	for preset, presetName := range whiteBalancePresetNames {
+		if presetName == name {
+			return preset, nil
+		}
+	}
+	return WhiteBalanceNone, fmt.Errorf("unknown white balance preset %q", name)
+}
+
+// Get the options for a preset.  WhiteBalanceNone has the default options;
+// callers should skip white balancing altogether.
+func (preset WhiteBalancePreset) Options() WhiteBalanceOptions {
+	result := DefaultWhiteBalanceOptions()
+	switch preset {
+	case WhiteBalanceMars:
+		// Bright surfaces are the best guide to the color of the light.
+		result.Estimator = WhitePercentile
+		result.Target = lib_color.WhiteMarsDaylight
+	case WhiteBalanceEarth:
+		result.Estimator = WhiteGrayWorld
+		result.Target = lib_color.WhiteD65
+	}
+	return result
+}
+
+// Get a preset and its options by name, e.g., from command-line flags.
+// Non-empty estimatorName and adaptationName override the preset's
+// estimator and chromatic adaptation method.
+func ParseWhiteBalanceOptions(presetName, estimatorName, adaptationName string) (WhiteBalancePreset, WhiteBalanceOptions, error) {
+	preset, err := ParseWhiteBalancePreset(presetName)
+	if err != nil {
+		return preset, DefaultWhiteBalanceOptions(), err
+	}
+	result := preset.Options()
+	if estimatorName != "" {
+		if result.Estimator, err = ParseWhiteBalanceEstimator(estimatorName); err != nil {
+			return preset, result, err
+		}
+	}
+	if adaptationName != "" {
+		if result.Method, err = lib_color.ParseAdaptationMethod(adaptationName); err != nil {
+			return preset, result, err
+		}
+	}
+	return preset, result, nil
+}
+
+// WhiteBalanceOptions control how white balance is estimated and applied.
+type WhiteBalanceOptions struct {
+	Estimator WhiteBalanceEstimator
+	// Fraction of unclipped pixels darker than the white point, for
+	// WhitePercentile.
+	Percentile float64
+	Method     lib_color.AdaptationMethod
+	// The white point to which the scene's white point is mapped.
+	Target lib_color.CIEXYZ
+	// Number of goroutines used for per-pixel operations.  Zero means one
+	// per CPU.
+	Workers int
+}
+
+func DefaultWhiteBalanceOptions() WhiteBalanceOptions {
+	return WhiteBalanceOptions{
+		Estimator:  WhiteGrayWorld,
+		Percentile: 0.98,
+		Method:     lib_color.AdaptBradford,
+		Target:     lib_color.WhiteD65,
+	}
+}
+
+// Pixels with any linear channel at least this bright are taken to be
+// clipped, and are ignored when estimating white.
+const whiteClipLevel = 0.97
+
+const whiteHistogramBins = 4096
+
+// Statistics of linear RGB pixel values, from which white points are
+// estimated.  Statistics gathered by separate workers can be merged.
+type whiteStats struct {
+	count int
+	sum   [3]float64
+	max   [3]float64
+	hist  [3][]int
+}
+
+func newWhiteStats() *whiteStats {
+	result := &whiteStats{}
+	for c := range result.hist {
+		result.hist[c] = make([]int, whiteHistogramBins)
+	}
+	return result
+}
+
+func (s *whiteStats) add(rgb [3]float64) {
+	for _, v := range rgb {
+		if v >= whiteClipLevel {
+			return
+		}
+	}
+	s.count++
+	for c, v := range rgb {
+		s.sum[c] += v
+		s.max[c] = math.Max(s.max[c], v)
+		bin := int(v * whiteHistogramBins)
+		if bin < 0 {
+			bin = 0
+		} else if bin >= whiteHistogramBins {
+			bin = whiteHistogramBins - 1
+		}
+		s.hist[c][bin]++
+	}
+}
+
+func (s *whiteStats) merge(other *whiteStats) {
+	s.count += other.count
+	for c := range s.sum {
+		s.sum[c] += other.sum[c]
+		s.max[c] = math.Max(s.max[c], other.max[c])
+		for i, n := range other.hist[c] {
+			s.hist[c][i] += n
+		}
+	}
+}
+
+// Get the value of channel c below which fraction of the pixels lie.
+func (s *whiteStats) percentile(c int, fraction float64) float64 {
+	limit := int(math.Ceil(fraction * float64(s.count)))
+	total := 0
+	for i, n := range s.hist[c] {
+		total += n
+		if total >= limit {
+			return float64(i+1) / whiteHistogramBins
+		}
+	}
+	return 1.0
+}
+
+// Estimate the scene's white point, normalized to Y = 1.  Scenes from
+// which no white can be estimated, e.g., black scenes, are taken to be
+// neutral.
+func (s *whiteStats) white(opts WhiteBalanceOptions) lib_color.CIEXYZ {
+	neutral := lib_color.LinearRGBToCIEXYZ(1.0, 1.0, 1.0)
+	if s.count <= 0 {
+		return neutral
+	}
+	rgb := [3]float64{}
+	for c := range rgb {
+		switch opts.Estimator {
+		case WhitePatch:
+			rgb[c] = s.max[c]
+		case WhitePercentile:
+			rgb[c] = s.percentile(c, opts.Percentile)
+		default:
+			rgb[c] = s.sum[c] / float64(s.count)
+		}
+		if rgb[c] <= 0.0 {
+			return neutral
+		}
+	}
+	result := lib_color.LinearRGBToCIEXYZ(rgb[0], rgb[1], rgb[2])
+	if result.Y <= 0.0 {
+		return neutral
+	}
+	return lib_color.CIEXYZ{X: result.X / result.Y, Y: 1.0, Z: result.Z / result.Y}
+}
+
+// WhiteBalance maps colors from a scene's estimated white point to a
+// target white point.
+type WhiteBalance struct {
+	SceneWhite  lib_color.CIEXYZ
+	TargetWhite lib_color.CIEXYZ
+	Method      lib_color.AdaptationMethod
+	adaptation  lib_color.ChromaticAdaptation
+}
+
+func NewWhiteBalance(sceneWhite lib_color.CIEXYZ, opts WhiteBalanceOptions) WhiteBalance {
+	return WhiteBalance{
+		SceneWhite:  sceneWhite,
+		TargetWhite: opts.Target,
+		Method:      opts.Method,
+		adaptation:  lib_color.NewChromaticAdaptation(opts.Method, sceneWhite, opts.Target),
+	}
+}
+
+func (wb WhiteBalance) Apply(c lib_color.CIEXYZ) lib_color.CIEXYZ {
+	return wb.adaptation.Apply(c)
+}
+
+// Get the white balance as a matrix that multiplies linear sRGB column
+// vectors.
+func (wb WhiteBalance) linearRGBMatrix() (result [3][3]float64) {
+	for j := 0; j < 3; j++ {
+		basis := [3]float64{}
+		basis[j] = 1.0
+		xyz := wb.Apply(lib_color.LinearRGBToCIEXYZ(basis[0], basis[1], basis[2]))
+		result[0][j], result[1][j], result[2][j] = xyz.LinearRGB()
+	}
+	return
+}
+
+// Linear values of 8- and 16-bit sRGB components
+var linearSRGB8 = func() (result [256]float64) {
+	for i := range result {
+		result[i] = lib_color.SRGBToLinear(float64(i) / 255.0)
+	}
+	return
+}()
+
+var linearSRGB16Once sync.Once
+var linearSRGB16 []float64
+
+func linearSRGB16Table() []float64 {
+	linearSRGB16Once.Do(func() {
+		linearSRGB16 = make([]float64, 65536)
+		for i := range linearSRGB16 {
+			linearSRGB16[i] = lib_color.SRGBToLinear(float64(i) / 65535.0)
+		}
+	})
+	return linearSRGB16
+}
+
+// Call fn with the linear RGB values and non-premultiplied alpha of each
+// pixel in each row band of img, on up to workers goroutines.  fn receives
+// the index of its band.
+func forEachLinearPixel(img image.Image, workers int, fn func(i, x, y int, rgb [3]float64, alpha uint16)) {
+	lib_image.ForEachRowBand(img.Bounds(), workers, func(i int, band image.Rectangle) {
+		if src, ok := img.(*image.NRGBA); ok {
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				for x := band.Min.X; x < band.Max.X; x++ {
+					pix := src.Pix[src.PixOffset(x, y):]
+					rgb := [3]float64{linearSRGB8[pix[0]], linearSRGB8[pix[1]], linearSRGB8[pix[2]]}
+					fn(i, x, y, rgb, uint16(pix[3])*0x101)
+				}
+			}
+			return
+		}
+		table := linearSRGB16Table()
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				fn(i, x, y, [3]float64{table[c.R], table[c.G], table[c.B]}, c.A)
+			}
+		}
+	})
+}
+
+// Estimate the white point, normalized to Y = 1, of the light
+// illuminating an sRGB image.
+func EstimateSceneWhite(img image.Image, opts WhiteBalanceOptions) lib_color.CIEXYZ {
+	stats := make([]*whiteStats, lib_image.NumWorkers(opts.Workers))
+	for i := range stats {
+		stats[i] = newWhiteStats()
+	}
+	forEachLinearPixel(img, opts.Workers, func(i, _, _ int, rgb [3]float64, alpha uint16) {
+		// Transparent pixels show nothing of the scene.
+		if alpha > 0 {
+			stats[i].add(rgb)
+		}
+	})
+	for _, s := range stats[1:] {
+		stats[0].merge(s)
+	}
+	return stats[0].white(opts)
+}
+
+// White balance an sRGB image.  The result is an *image.NRGBA64 if img
+// has 16 bits per channel, otherwise an *image.NRGBA.  Alpha is unchanged.
+func WhiteBalanceImage(img image.Image, opts WhiteBalanceOptions) (image.Image, WhiteBalance) {
+	wb := NewWhiteBalance(EstimateSceneWhite(img, opts), opts)
+	m := wb.linearRGBMatrix()
+	balance := func(rgb [3]float64) (result [3]float64) {
+		for c := range result {
+			result[c] = lib_color.LinearToSRGB(m[c][0]*rgb[0] + m[c][1]*rgb[1] + m[c][2]*rgb[2])
+		}
+		return
+	}
+
+	bounds := img.Bounds()
+	switch img.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		result := image.NewNRGBA64(bounds)
+		forEachLinearPixel(img, opts.Workers, func(_, x, y int, rgb [3]float64, alpha uint16) {
+			balanced := balance(rgb)
+			result.SetNRGBA64(x, y, color.NRGBA64{
+				R: uint16(math.Round(balanced[0] * 65535.0)),
+				G: uint16(math.Round(balanced[1] * 65535.0)),
+				B: uint16(math.Round(balanced[2] * 65535.0)),
+				A: alpha,
+			})
+		})
+		return result, wb
+	}
+	result := image.NewNRGBA(bounds)
+	forEachLinearPixel(img, opts.Workers, func(_, x, y int, rgb [3]float64, alpha uint16) {
+		balanced := balance(rgb)
+		pix := result.Pix[result.PixOffset(x, y):]
+		pix[0] = uint8(math.Round(balanced[0] * 255.0))
+		pix[1] = uint8(math.Round(balanced[1] * 255.0))
+		pix[2] = uint8(math.Round(balanced[2] * 255.0))
+		pix[3] = uint8(alpha >> 8)
+	})
+	return result, wb
+}
+
+// Estimate the white point of the covered pixels of the result, and adapt
+// them to opts.Target.  Call this before CompressDynamicRange, which
+// changes the colors from which white is estimated.  comp.Workers, not
+// opts.Workers, sets the number of goroutines used.
+func (comp *Compositor) WhiteBalance(opts WhiteBalanceOptions) WhiteBalance {
+	stats := make([]*whiteStats, lib_image.NumWorkers(comp.Workers))
+	for i := range stats {
+		stats[i] = newWhiteStats()
+	}
+	lib_image.ForEachImagePart(comp.Result, comp.Workers, func(i int, part image.Rectangle) {
+		for y := part.Min.Y; y < part.Max.Y; y++ {
+			for x := part.Min.X; x < part.Max.X; x++ {
+				if comp.Covered(x, y) {
+					r, g, b := comp.Result.CIELabAt(x, y).CIEXYZ().LinearRGB()
+					stats[i].add([3]float64{r, g, b})
+				}
+			}
+		}
+	})
+	for _, s := range stats[1:] {
+		stats[0].merge(s)
+	}
+
+	wb := NewWhiteBalance(stats[0].white(opts), opts)
+	lib_image.ForEachImagePart(comp.Result, comp.Workers, func(_ int, part image.Rectangle) {
+		for y := part.Min.Y; y < part.Max.Y; y++ {
+			for x := part.Min.X; x < part.Max.X; x++ {
+				if comp.Covered(x, y) {
+					comp.Result.SetCIELab(x, y, wb.Apply(comp.Result.CIELabAt(x, y).CIEXYZ()).CIELab())
+				}
+			}
+		}
+	})
+	return wb
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	lib_color "github.com/mchapman87501/go_mars_2020_img_utils/lib/image/color"
+)
+
+func TestParseWhiteBalanceNames(t *testing.T) {
+	for estimator := range whiteBalanceEstimatorNames {
+		parsed, err := ParseWhiteBalanceEstimator(estimator.String())
+		if (err != nil) || (parsed != estimator) {
+			t.Errorf("Expected %v, got %v (%v)", estimator, parsed, err)
+		}
+	}
+	if _, err := ParseWhiteBalanceEstimator("bogus"); err == nil {
+		t.Error("Expected an error for an unknown estimator")
+	}
+	for preset := range whiteBalancePresetNames {
+		parsed, err := ParseWhiteBalancePreset(preset.String())
+		if (err != nil) || (parsed != preset) {
+			t.Errorf("Expected %v, got %v (%v)", preset, parsed, err)
+		}
+	}
+	if _, err := ParseWhiteBalancePreset("bogus"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+}
+
+func TestParseWhiteBalanceOptions(t *testing.T) {
+	preset, opts, err := ParseWhiteBalanceOptions("mars", "", "")
+	if err != nil {
+		t.Fatal("Error parsing options:", err)
+	}
+	if (preset != WhiteBalanceMars) || (opts != WhiteBalanceMars.Options()) {
+		t.Errorf("Expected the mars preset's options, got %v %+v", preset, opts)
+	}
+
+	_, opts, err = ParseWhiteBalanceOptions("mars", "gray-world", "cat02")
+	if err != nil {
+		t.Fatal("Error parsing options:", err)
+	}
+	if (opts.Estimator != WhiteGrayWorld) || (opts.Method != lib_color.AdaptCAT02) {
+		t.Errorf("Expected overridden estimator and method, got %+v", opts)
+	}
+	if opts.Target != lib_color.WhiteMarsDaylight {
+		t.Errorf("Expected the preset's target to be kept, got %v", opts.Target)
+	}
+
+	for _, names := range [][3]string{{"bogus", "", ""}, {"earth", "bogus", ""}, {"earth", "", "bogus"}} {
+		if _, _, err := ParseWhiteBalanceOptions(names[0], names[1], names[2]); err == nil {
+			t.Errorf("Expected an error for %v", names)
+		}
+	}
+}
+
+// Linear RGB gains of a reddish, dim-blue light
+var castGains = [3]float64{0.9, 0.6, 0.35}
+
+// A scene of gray steps lit by castGains.
+func castGrayImage() *image.NRGBA {
+	result := image.NewNRGBA(image.Rect(0, 0, 64, 16))
+	for x := 0; x < 64; x++ {
+		gray := 0.05 + 0.95*float64(x)/63.0
+		c := color.NRGBA{A: 0xff}
+		rgb := [3]*uint8{&c.R, &c.G, &c.B}
+		for i, gain := range castGains {
+			*rgb[i] = uint8(math.Round(lib_color.LinearToSRGB(gray*gain) * 255.0))
+		}
+		for y := 0; y < 16; y++ {
+			result.SetNRGBA(x, y, c)
+		}
+	}
+	return result
+}
+
+func TestWhiteBalanceImageNeutralizesCast(t *testing.T) {
+	for estimator := range whiteBalanceEstimatorNames {
+		for method := lib_color.AdaptBradford; method <= lib_color.AdaptCAT02; method++ {
+			opts := WhiteBalanceEarth.Options()
+			opts.Estimator = estimator
+			opts.Method = method
+			balanced, _ := WhiteBalanceImage(castGrayImage(), opts)
+			bounds := balanced.Bounds()
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := balanced.At(x, 0).(color.NRGBA)
+				spread := math.Max(math.Max(float64(c.R), float64(c.G)), float64(c.B)) -
+					math.Min(math.Min(float64(c.R), float64(c.G)), float64(c.B))
+				if spread > 3.0 {
+					t.Fatalf("%v/%v: expected gray at x=%v, got %v", estimator, method, x, c)
+				}
+			}
+		}
+	}
+}
+
+func TestWhiteBalancePresets(t *testing.T) {
+	gray := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(gray, gray.Bounds(), image.NewUniform(color.RGBA{0xc0, 0xc0, 0xc0, 0xff}), image.Point{}, draw.Src)
+
+	earth, wb := WhiteBalanceImage(gray, WhiteBalanceEarth.Options())
+	if c := earth.At(0, 0).(color.NRGBA); (c != color.NRGBA{0xc0, 0xc0, 0xc0, 0xff}) {
+		t.Errorf("Expected Earth white balance to leave gray alone, got %v", c)
+	}
+	if x, y := wb.SceneWhite.Chromaticity(); (math.Abs(x-0.3127) > 1.0e-3) || (math.Abs(y-0.3290) > 1.0e-3) {
+		t.Errorf("Expected a D65 scene white, got (%v, %v)", x, y)
+	}
+
+	mars, _ := WhiteBalanceImage(gray, WhiteBalanceMars.Options())
+	if c := mars.At(0, 0).(color.NRGBA); !((c.R > c.G) && (c.G > c.B)) {
+		t.Errorf("Expected Mars white balance to make gray warm, got %v", c)
+	}
+}
+
+func TestWhiteBalanceImageKeeps16Bits(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA64(x, y, color.NRGBA64{0x8001, 0x8001, 0x8001, 0xffff})
+		}
+	}
+	balanced, _ := WhiteBalanceImage(img, WhiteBalanceEarth.Options())
+	c, ok := balanced.At(0, 0).(color.NRGBA64)
+	if !ok {
+		t.Fatalf("Expected an NRGBA64 result, got %T", balanced)
+	}
+	// The D65 white of Lab differs slightly from that of sRGB.
+	for _, v := range []uint16{c.R, c.G, c.B} {
+		if math.Abs(float64(v)-0x8001) > 8.0 {
+			t.Errorf("Expected 16-bit gray to be unchanged, got %v", c)
+		}
+	}
+}
+
+func TestCompositorWhiteBalance(t *testing.T) {
+	tile := castGrayImage()
+	comp := NewCompositor(image.Rect(0, 0, 96, 16))
+	comp.AddImage(tile, image.Rect(0, 0, 64, 16))
+	wb := comp.WhiteBalance(WhiteBalanceEarth.Options())
+	if x, _ := wb.SceneWhite.Chromaticity(); x <= 0.3127 {
+		t.Errorf("Expected a reddish scene white, got %v", wb.SceneWhite)
+	}
+	for x := 0; x < 96; x++ {
+		pix := comp.Result.CIELabAt(x, 0)
+		if !comp.Covered(x, 0) {
+			if (pix != lib_color.CIELab{}) {
+				t.Fatalf("Expected uncovered pixel at x=%v to be unchanged, got %v", x, pix)
+			}
+			continue
+		}
+		if math.Hypot(pix.A, pix.B) > 1.0 {
+			t.Fatalf("Expected gray at x=%v, got %v", x, pix)
+		}
+	}
+}